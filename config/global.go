@@ -4,61 +4,118 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/jinzhu/configor"
 )
 
-var params = struct {
+// Config holds the settings of one server instance
+type Config struct {
 	LogLevel string `default:"info" env:"Loglevel"`
 	LogFile  string `default:"/tmp/debug.log" env:"LogFile"`
-	PoolSize int    `default:"10"`
-	UDP      struct {
+	// workers handling sessions of each socket, packets of a session are always handled by the same one
+	PoolSize int `default:"10"`
+	// packets queued per worker, when it is full the read loop waits on "block" or gives up the packet on "drop"
+	QueueSize   int    `default:"1024"`
+	QueuePolicy string `default:"block"`
+	UDP         struct {
 		IP   string `default:"127.0.0.1"`
 		Port int    `default:"9090"`
+		// sockets bound with SO_REUSEPORT, each has its own read loop and workers
+		Sockets int `default:"1"`
 	}
 	SRT struct {
 		Latency struct {
 			TX uint16 `default:"120"`
 			RX uint16 `default:"20"`
 		}
+		// pointer keeps an explicit false apart from an absent value
+		TLPktDrop *bool `default:"true"`
+		NAKReport *bool `default:"true"`
 	}
 	HLS struct {
 		Server struct {
 			Port int `default:"9091"`
 		}
+		// seconds of a segment, it is cut at the first keyframe after
+		TargetDuration float64 `default:"5"`
+		// segments are kept in "memory" or in files under Dir on "disk"
+		Store string `default:"memory"`
+		Dir   string `default:"/tmp/gosrt/hls"`
+		// segments in live playlist
+		Window int `default:"3"`
+		// minutes of dvr playlist, 0 disables it and -1 keeps the whole stream as an EVENT playlist
+		DVR float64 `default:"0"`
+		// seconds an ended stream is served as VOD before its segments are removed, 0 removes them at once
+		Retention int `default:"300"`
+		// seconds of a part of low-latency hls, about 0.2 to 0.5, 0 disables it
+		PartTarget float64 `default:"0"`
 	}
-}{}
+	Shutdown struct {
+		// seconds to wait for peers notified and outputs flushed
+		Timeout int `default:"5"`
+	}
+}
 
-func InitConfig() {
+// InitConfig loads property.yaml in working directory, it panics if the file is bad
+func InitConfig() *Config {
 	pwd, _ := os.Getwd()
 	file := "property.yaml"
 	path := filepath.Join(pwd, file)
 
-	if err := configor.Load(&params, path); err != nil {
+	c, err := Load(path)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Load reads files into a new config, absent values are filled by defaults
+func Load(files ...string) (*Config, error) {
+	c := new(Config)
+	if err := configor.Load(c, files...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Default returns a config of default values
+func Default() *Config {
+	c, err := Load()
+	if err != nil {
 		panic(err)
 	}
+	return c
 }
 
-func GetLogLevel() string {
-	return params.LogLevel
+func (c *Config) UDPAddr() string {
+	return fmt.Sprintf("%s:%d", c.UDP.IP, c.UDP.Port)
 }
 
-func GetUDPAddr() string {
-	return fmt.Sprintf("%s:%d", params.UDP.IP, params.UDP.Port)
+func (c *Config) HLSAddr() string {
+	return fmt.Sprintf(":%d", c.HLS.Server.Port)
 }
 
-func GetHLSPort() int {
-	return params.HLS.Server.Port
+func (c *Config) TLPktDrop() bool {
+	return c.SRT.TLPktDrop == nil || *c.SRT.TLPktDrop
 }
 
-func GetPoolSize() int {
-	return params.PoolSize
+func (c *Config) NAKReport() bool {
+	return c.SRT.NAKReport == nil || *c.SRT.NAKReport
 }
 
-func GetTx() uint16 {
-	return params.SRT.Latency.TX
+func (c *Config) ShutdownTimeout() time.Duration {
+	return time.Duration(c.Shutdown.Timeout) * time.Second
+}
+
+// HLSDVR returns the duration of dvr playlist, negative for the whole stream
+func (c *Config) HLSDVR() time.Duration {
+	if c.HLS.DVR < 0 {
+		return -1
+	}
+	return time.Duration(c.HLS.DVR * float64(time.Minute))
 }
 
-func GetRx() uint16 {
-	return params.SRT.Latency.RX
+func (c *Config) HLSRetention() time.Duration {
+	return time.Duration(c.HLS.Retention) * time.Second
 }
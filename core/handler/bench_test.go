@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"encoding/binary"
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/beleege/gosrt/core/session"
+	"github.com/beleege/gosrt/protocol/srt"
+	"github.com/beleege/gosrt/util/buffer"
+)
+
+// BenchmarkDataPath runs data packets through handler chain and window to a consumer
+func BenchmarkDataPath(b *testing.B) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	s := session.NewSRTSession(conn, conn.LocalAddr(), nil)
+	for _, st := range []session.State{session.SOpen, session.SSetCookie, session.SRepeat} {
+		_ = s.Transit(st, "bench")
+	}
+	_ = s.Connect()
+	s.Agree(srt.SRTFlagTLPKTDROP, 120)
+	h, _ := DefaultChain().Build()
+
+	done := make(chan int)
+	go func() {
+		n := 0
+		for batch := range s.RecWin.ListenBatch() {
+			for _, p := range batch {
+				p.Release()
+			}
+			n += len(batch)
+		}
+		done <- n
+	}()
+
+	pkt := make([]byte, 16+1316)
+	pkt[4] = 0xC0
+	b.SetBytes(1316)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint32(pkt, uint32(i))
+		buf := buffer.Get()
+		box := NewBufferBox(s, buf, copy(buf.B, pkt))
+		if err = h.Execute(box); err != nil {
+			b.Fatal(err)
+		}
+		box.Release()
+		// keep window from overflow
+		for s.RecWin.Len() > 512 {
+			runtime.Gosched()
+		}
+	}
+	for s.RecWin.AckSeq() != uint32(b.N) {
+		runtime.Gosched()
+	}
+	b.StopTimer()
+	s.Shutdown("bench")
+	if n := <-done; n != b.N {
+		b.Errorf("consume %d packets, expected %d", n, b.N)
+	}
+}
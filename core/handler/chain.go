@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"github.com/pkg/errors"
+)
+
+// names of built-in stages in default order
+const (
+	StageValidator  = "validator"
+	StageDecoder    = "decoder"
+	StageAckAck     = "ackack"
+	StageControl    = "control"
+	StageShutdown   = "shutdown"
+	StageHandshake  = "handshake"
+	StageDataStream = "datastream"
+)
+
+// Handler is a stage of handler chain, it handles box or passes it to the next stage
+type Handler interface {
+	HasNext() bool
+	Next(h Handler)
+	Execute(box *Box) error
+}
+
+// Factory creates a stage, every built chain gets its own stages
+type Factory func() Handler
+
+// Base links a stage to the next one, embed it to implement Handler
+type Base struct {
+	nextHandler Handler
+}
+
+func (b *Base) HasNext() bool {
+	return b.nextHandler != nil
+}
+
+func (b *Base) Next(h Handler) {
+	b.nextHandler = h
+}
+
+// Pass hands box to the next stage
+func (b *Base) Pass(box *Box) error {
+	if b.nextHandler == nil {
+		return errors.New("no next handler")
+	}
+	return b.nextHandler.Execute(box)
+}
+
+type funcHandler struct {
+	Base
+	f func(box *Box, pass func() error) error
+}
+
+func (h *funcHandler) Execute(box *Box) error {
+	return h.f(box, func() error {
+		return h.Pass(box)
+	})
+}
+
+// Func makes a stage of f, f calls pass to continue the chain or returns to stop it
+func Func(f func(box *Box, pass func() error) error) Factory {
+	return func() Handler {
+		return &funcHandler{f: f}
+	}
+}
+
+type stage struct {
+	name    string
+	factory Factory
+}
+
+// Chain registers stages in order, it is not safe to modify while building
+type Chain struct {
+	stages []stage
+}
+
+func NewChain() *Chain {
+	return new(Chain)
+}
+
+// DefaultChain contains the built-in stages
+func DefaultChain() *Chain {
+	c := NewChain()
+	c.stages = []stage{
+		{StageValidator, func() Handler { return NewValidator() }},
+		{StageDecoder, func() Handler { return NewDecoder() }},
+		{StageAckAck, func() Handler { return NewAckAck() }},
+		{StageControl, func() Handler { return NewControl() }},
+		{StageShutdown, func() Handler { return NewShutdown() }},
+		{StageHandshake, func() Handler { return NewHandshake() }},
+		{StageDataStream, func() Handler { return NewDataStream() }},
+	}
+	return c
+}
+
+// Use appends a stage to the end
+func (c *Chain) Use(name string, f Factory) error {
+	return c.insert(len(c.stages), name, f)
+}
+
+// Before inserts a stage in front of stage at
+func (c *Chain) Before(at, name string, f Factory) error {
+	i := c.index(at)
+	if i < 0 {
+		return errors.Errorf("stage[%s] not found", at)
+	}
+	return c.insert(i, name, f)
+}
+
+// After inserts a stage behind stage at
+func (c *Chain) After(at, name string, f Factory) error {
+	i := c.index(at)
+	if i < 0 {
+		return errors.Errorf("stage[%s] not found", at)
+	}
+	return c.insert(i+1, name, f)
+}
+
+func (c *Chain) Remove(name string) {
+	if i := c.index(name); i >= 0 {
+		c.stages = append(c.stages[:i], c.stages[i+1:]...)
+	}
+}
+
+func (c *Chain) Names() []string {
+	names := make([]string, 0, len(c.stages))
+	for _, st := range c.stages {
+		names = append(names, st.name)
+	}
+	return names
+}
+
+// Build creates and links stages, returns the first one
+func (c *Chain) Build() (Handler, error) {
+	if len(c.stages) == 0 {
+		return nil, errors.New("chain is empty")
+	}
+	handlers := make([]Handler, len(c.stages))
+	for i, st := range c.stages {
+		handlers[i] = st.factory()
+	}
+	for i := len(handlers) - 2; i >= 0; i-- {
+		handlers[i].Next(handlers[i+1])
+	}
+	return handlers[0], nil
+}
+
+func (c *Chain) insert(i int, name string, f Factory) error {
+	if f == nil {
+		return errors.Errorf("stage[%s] factory is nil", name)
+	}
+	if c.index(name) >= 0 {
+		return errors.Errorf("stage[%s] exists", name)
+	}
+	c.stages = append(c.stages, stage{})
+	copy(c.stages[i+1:], c.stages[i:])
+	c.stages[i] = stage{name: name, factory: f}
+	return nil
+}
+
+func (c *Chain) index(name string) int {
+	for i, st := range c.stages {
+		if st.name == name {
+			return i
+		}
+	}
+	return -1
+}
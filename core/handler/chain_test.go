@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/beleege/gosrt/core/session"
+	"github.com/beleege/gosrt/protocol/srt"
+)
+
+func record(name string, order *[]string) Factory {
+	return Func(func(box *Box, pass func() error) error {
+		*order = append(*order, name)
+		if box.Bytes() == nil {
+			return nil
+		}
+		return pass()
+	})
+}
+
+func TestChain(t *testing.T) {
+	order := make([]string, 0, 4)
+	c := NewChain()
+	_ = c.Use("a", record("a", &order))
+	_ = c.Use("d", record("d", &order))
+	if err := c.Before("d", "b", record("b", &order)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.After("b", "c", record("c", &order)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Use("a", record("a", &order)); err == nil {
+		t.Error("duplicate stage should fail")
+	}
+	if err := c.After("x", "e", record("e", &order)); err == nil {
+		t.Error("unknown stage should fail")
+	}
+	c.Remove("d")
+
+	expected := []string{"a", "b", "c"}
+	if !reflect.DeepEqual(c.Names(), expected) {
+		t.Fatalf("unexpected stages: %v", c.Names())
+	}
+	h, err := c.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the last stage has no next one
+	if err = h.Execute(NewBox(nil, []byte{0})); err == nil {
+		t.Error("pass after the last stage should fail")
+	}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("unexpected execute order: %v", order)
+	}
+}
+
+func TestUserDefStage(t *testing.T) {
+	// user defined control packet with subtype 1
+	b := []byte{0xFF, 0xFF, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+	s := session.NewSRTSession(nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}, nil)
+	defer s.Shutdown("test")
+
+	h, _ := DefaultChain().Build()
+	// only KMREQ is answered by default chain, other subtypes are ignored
+	if err := h.Execute(NewBox(s, b)); err != nil {
+		t.Errorf("default chain should ignore user defined packet: %v", err)
+	}
+
+	var got *srt.ControlPacket
+	c := DefaultChain()
+	err := c.After(StageDecoder, "userdef", Func(func(box *Box, pass func() error) error {
+		if cp := box.ControlPacket(); cp != nil && cp.CType == srt.CTUserDef {
+			got = cp
+			return nil
+		}
+		return pass()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, err = c.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.Execute(NewBox(s, b)); err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Subtype != 1 {
+		t.Errorf("user defined packet is not handled: %+v", got)
+	}
+}
+
+// loopback returns a connected session sending to its own conn
+func loopback(t *testing.T) (*session.SRTSession, *net.UDPConn) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := session.NewSRTSession(conn, conn.LocalAddr(), nil)
+	for _, st := range []session.State{session.SOpen, session.SSetCookie, session.SRepeat} {
+		_ = s.Transit(st, "test")
+	}
+	_ = s.Connect()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	return s, conn
+}
+
+func TestControlTypes(t *testing.T) {
+	s, conn := loopback(t)
+	defer conn.Close()
+	defer s.Shutdown("test")
+	h, _ := DefaultChain().Build()
+
+	win := s.RecWin
+	win.SetDrop(false)
+	data := make([]byte, 16+188)
+	if err := h.Execute(NewBox(s, data)); err != nil {
+		t.Fatal(err)
+	}
+	binary.BigEndian.PutUint32(data, 3)
+	if err := h.Execute(NewBox(s, data)); err != nil {
+		t.Fatal(err)
+	}
+	// DROPREQ of 1 and 2, then a congestion warning and a type unknown
+	dropreq := []byte{0x80, 0x07, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2}
+	for _, b := range [][]byte{dropreq, {0x80, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0x80, 0x99, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}} {
+		if err := h.Execute(NewBox(s, b)); err != nil {
+			t.Errorf("control type %#x: %v", b[1], err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for win.AckSeq() != 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if win.AckSeq() != 4 || s.State() != session.SConnect {
+		t.Errorf("window should move on after DROPREQ: ack %d, %s", win.AckSeq(), s.State())
+	}
+}
+
+func TestAckStats(t *testing.T) {
+	s, conn := loopback(t)
+	defer conn.Close()
+	defer s.Shutdown("test")
+	h, _ := DefaultChain().Build()
+
+	cp := &srt.ControlPacket{CType: srt.CTAck}
+	ack := cp.Ack(1, s.ThatSID, 0, 20000, 5000, 8192, 100, 1000, 131600, &s.OpenTime)
+	if err := h.Execute(NewBox(s, ack)); err != nil {
+		t.Fatal(err)
+	}
+	st := s.Stats(false)
+	if st.RTT != 20*time.Millisecond || st.RTTVar != 5*time.Millisecond || st.Bandwidth != 1000 || st.PeerRate != 100 {
+		t.Errorf("sender should take rtt and rates from ack: %+v", st)
+	}
+}
+
+func TestKeyRefresh(t *testing.T) {
+	s, conn := loopback(t)
+	defer conn.Close()
+	defer s.Shutdown("test")
+	h, _ := DefaultChain().Build()
+
+	// KMREQ of a stream not encrypted is answered with the state of no secret
+	kmreq := []byte{0xFF, 0xFF, 0x00, srt.HSExtTypeKMReq, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0x12, 0x20, 0x29, 0x03}
+	if err := h.Execute(NewBox(s, kmreq)); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1500)
+	n, _, err := conn.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp := srt.ParseCPacket(b[:n])
+	if rsp.CType != srt.CTUserDef || rsp.Subtype != srt.HSExtTypeKMRsp || len(rsp.CIF) != 4 ||
+		binary.BigEndian.Uint32(rsp.CIF) != srt.KMStateNoSecret {
+		t.Errorf("unexpected KMRSP: %+v", rsp)
+	}
+
+	// a pkg failed to decrypt is dropped without error
+	data := make([]byte, 16+188)
+	data[4] = srt.KKEven << 3
+	if err = h.Execute(NewBox(s, data)); err != nil {
+		t.Fatal(err)
+	}
+	if st := s.Stats(false); st.Total.PktRcvUndecrypt != 1 || s.RecWin.Len() != 0 {
+		t.Errorf("pkg should be dropped: %+v", st.Total)
+	}
+}
+
+func TestRepeatedConclusion(t *testing.T) {
+	s, conn := loopback(t)
+	defer conn.Close()
+	defer s.Shutdown("test")
+	s.ThatSID, s.Cookie = 7, 9
+	s.Conclusion = []byte{0x80, 0, 0, 0, 1, 2, 3, 4}
+	h, _ := DefaultChain().Build()
+
+	conclusion := func(cookie uint32) []byte {
+		b := make([]byte, 64)
+		b[0] = 0x80
+		binary.BigEndian.PutUint32(b[16:20], srt.HSv5)
+		binary.BigEndian.PutUint32(b[36:40], srt.HSTypeConclusion)
+		binary.BigEndian.PutUint32(b[40:44], s.ThatSID)
+		binary.BigEndian.PutUint32(b[44:48], cookie)
+		return b
+	}
+	// a conclusion of another cookie is not answered
+	if err := h.Execute(NewBox(s, conclusion(10))); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Execute(NewBox(s, conclusion(s.Cookie))); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1500)
+	n, _, err := conn.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != string(s.Conclusion) {
+		t.Errorf("unexpected response %x", b[:n])
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, _, err = conn.ReadFrom(b); err == nil {
+		t.Error("conclusion should be answered once")
+	}
+}
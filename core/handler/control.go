@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"encoding/binary"
+
+	"github.com/beleege/gosrt/protocol/srt"
+	"github.com/beleege/gosrt/util/log"
+	"github.com/pkg/errors"
+)
+
+// control handles feedback for the data we send and keepalive
+type control struct {
+	Base
+}
+
+func NewControl() *control {
+	c := new(control)
+	return c
+}
+
+func (c *control) Execute(box *Box) error {
+	if box.s.CP == nil {
+		if c.HasNext() {
+			return c.Pass(box)
+		}
+		return errors.New("no handler after control")
+	}
+
+	switch box.s.CP.CType {
+	case srt.CTKeepalive:
+		box.s.CP = nil
+		return nil
+	case srt.CTAck:
+		onAck(box)
+		box.s.CP = nil
+		return nil
+	case srt.CTNAck:
+		onNAck(box)
+		box.s.CP = nil
+		return nil
+	case srt.CTUserDef:
+		onUserDef(box)
+		box.s.CP = nil
+		return nil
+	case srt.CTDropReq:
+		onDropReq(box)
+		box.s.CP = nil
+		return nil
+	case srt.CTPeerErr:
+		log.Infof("peer [%s] reports error[%d]", box.s.GetPeer(), box.s.CP.SpecInfo)
+		box.s.CP = nil
+		return nil
+	}
+	if c.HasNext() {
+		return c.Pass(box)
+	}
+	return errors.New("no handler after control")
+}
+
+func onAck(box *Box) {
+	s := box.s
+	if s.SndBuf == nil || len(s.CP.CIF) < 4 {
+		return
+	}
+	ack := srt.ParseACK(s.CP.CIF)
+	s.SndBuf.Ack(ack.LastACK)
+	// light ack has no ack no and needs no ackack
+	if s.CP.SpecInfo == 0 {
+		return
+	}
+	s.OnACK(ack)
+	cp := new(srt.ControlPacket)
+	cp.CType = srt.CTAckAck
+	_, _ = s.Write(cp.AckAck(&s.OpenTime, s.CP.SpecInfo, s.ThatSID))
+}
+
+func onNAck(box *Box) {
+	s := box.s
+	if s.SndBuf == nil {
+		return
+	}
+	for _, r := range srt.ParseLossList(s.CP.CIF) {
+		s.Retransmit(r[0], r[1])
+	}
+}
+
+// onDropReq stops waiting for the pkgs given up by sender
+func onDropReq(box *Box) {
+	s := box.s
+	if len(s.CP.CIF) < 8 {
+		return
+	}
+	first := binary.BigEndian.Uint32(s.CP.CIF[:4])
+	last := binary.BigEndian.Uint32(s.CP.CIF[4:8])
+	log.Debugf("peer [%s] drops pkgs from %d to %d", s.GetPeer(), first, last)
+	s.RecWin.Drop(first, last)
+}
+
+// onUserDef answers KMREQ sent to refresh keys, the keys in use are kept if it is rejected
+func onUserDef(box *Box) {
+	s := box.s
+	if s.CP.Subtype != srt.HSExtTypeKMReq {
+		return
+	}
+	rsp := s.CP.CIF
+	if err := s.Rekey(s.CP.CIF); err != nil {
+		log.Errorf("refresh keys of [%s] fail: %s", s.GetPeer(), err.Error())
+		state := uint32(srt.KMStateBadSecret)
+		if s.Decrypter == nil {
+			state = srt.KMStateNoSecret
+		}
+		rsp = make([]byte, 4)
+		binary.BigEndian.PutUint32(rsp, state)
+	}
+	cp := new(srt.ControlPacket)
+	cp.CType = srt.CTUserDef
+	cp.Subtype = srt.HSExtTypeKMRsp
+	_, _ = s.Write(cp.KeyMaterial(&s.OpenTime, s.ThatSID, rsp))
+}
@@ -4,11 +4,12 @@ import (
 	"github.com/beleege/gosrt/core/session"
 	"github.com/beleege/gosrt/protocol/srt"
 	"github.com/beleege/gosrt/util/log"
+	"github.com/beleege/gosrt/util/window"
 	"github.com/pkg/errors"
 )
 
 type dataStream struct {
-	nextHandler srtHandler
+	Base
 }
 
 func NewDataStream() *dataStream {
@@ -16,46 +17,67 @@ func NewDataStream() *dataStream {
 	return d
 }
 
-func (d *dataStream) hasNext() bool {
-	return d.nextHandler != nil
-}
-
-func (d *dataStream) next(next srtHandler) {
-	d.nextHandler = next
-}
-
-func (d *dataStream) execute(box *Box) error {
+func (d *dataStream) Execute(box *Box) error {
 	if box.s.DP != nil {
+		if box.s.DP.KK != srt.KKNone {
+			// a pkg failed to decrypt is counted and dropped, the stream goes on
+			if err := box.s.Decrypt(box.s.DP); err != nil {
+				log.Debugf("drop pkg[%d] of %s: %s", box.s.DP.SequenceNum, box.s.StreamID, err.Error())
+				return nil
+			}
+		}
 		box.s.RecWin.Append(box.s.DP)
 		box.s.AddACKAction(ack)
 		return nil
-	} else if d.hasNext() {
-		return d.nextHandler.execute(box)
+	} else if d.HasNext() {
+		return d.Pass(box)
+	} else if box.s.CP != nil {
+		// control pkgs of unknown type end here, peer stays connected
+		log.Debugf("ignore control pkg type[%#x] of %s", box.s.CP.CType, box.s.StreamID)
+		box.s.CP = nil
+		return nil
 	}
 
 	return errors.New("no handler after dataStream")
 }
 
 func ack(s *session.SRTSession, seq uint32) {
-	log.Infof("fire ack to %s", s.StreamID)
+	log.Debugf("fire ack to %s", s.StreamID)
 	cp := new(srt.ControlPacket)
 	cp.CType = srt.CTAck
 
-	ackNo := s.ACKNo
-	if ackNo <= 0 {
-		ackNo = 1
+	ackNo := s.NextACK()
+	rtt, rttVar := s.RTT()
+	leftMFW := s.MFW - s.RecWin.Len()
+	pRate, bandwidth := s.RecWin.Rates()
+	// bytes rate from the average payload size
+	rRate := uint32(0)
+	if c := s.RecWin.Counters(); c.Received > 0 {
+		rRate = uint32(uint64(pRate) * c.Bytes / c.Received)
 	}
-	rtt := s.RTTTime
-	if rtt <= 0 {
-		rtt = 100000
+	_, _ = s.Write(cp.Ack(ackNo, s.ThatSID, seq+1, rtt, rttVar, leftMFW, pRate, bandwidth, rRate, &s.OpenTime))
+}
+
+func reportLoss(s *session.SRTSession) {
+	for ranges := range s.RecWin.ListenLoss() {
+		if s.State() != session.SConnect {
+			return
+		}
+		_, _ = s.Write(nak(s, ranges))
 	}
-	rttDiff := s.RTTDiff
-	if rttDiff <= 0 {
-		rttDiff = 50000
+}
+
+func nak(s *session.SRTSession, ranges []window.LossRange) []byte {
+	loss := make([]uint32, 0, len(ranges)*2)
+	for _, r := range ranges {
+		if r.Start == r.End {
+			loss = append(loss, r.Start)
+		} else {
+			loss = append(loss, r.Start|srt.LossRangeFlag, r.End)
+		}
 	}
-	leftMFW := s.MFW - s.RecWin.Len()
-	pRate := 1000
-	bandwidth := 1000
-	rRate := 1000
-	_, _ = s.Write(cp.Ack(ackNo, s.ThatSID, seq+1, rtt, rttDiff, leftMFW, uint32(pRate), uint32(bandwidth), uint32(rRate), &s.OpenTime))
+	log.Debugf("fire nak to %s with %+v", s.StreamID, ranges)
+	cp := new(srt.ControlPacket)
+	cp.CType = srt.CTNAck
+	return cp.NAck(&s.OpenTime, s.ThatSID, loss)
 }
@@ -7,7 +7,7 @@ import (
 )
 
 type decoder struct {
-	nextHandler srtHandler
+	Base
 }
 
 func NewDecoder() *decoder {
@@ -15,19 +15,12 @@ func NewDecoder() *decoder {
 	return d
 }
 
-func (d *decoder) hasNext() bool {
-	return d.nextHandler != nil
-}
-
-func (d *decoder) next(next srtHandler) {
-	d.nextHandler = next
-}
-
-func (d *decoder) execute(box *Box) error {
+func (d *decoder) Execute(box *Box) error {
 	t := box.b[:1][0] >> 7
 	if t == srt.PTypeControl {
 		//log.Debugf("-----------------------------------------------")
 		//log.Debugf("binary data:\n%s", hex.Dump(s.Data))
+		box.s.DP = nil
 		pkg := srt.ParseCPacket(box.b)
 		//log.Debugf("control pkg type is %d", pkg.CType)
 		if pkg.CType == srt.CTHandShake {
@@ -37,15 +30,16 @@ func (d *decoder) execute(box *Box) error {
 			box.s.CP = pkg
 		}
 	} else if t == srt.PTypeData {
-		if box.s.Status.Load().(int) != session.SConnect {
+		if box.s.State() != session.SConnect {
 			// TODO clear session
 			return errors.Errorf("session is not connected")
 		}
-		pkg := srt.ParseDPacket(box.b)
+		box.s.CP = nil
+		pkg := srt.ParseDPacketBuffer(box.buf, box.b)
 		box.s.SetDP(pkg)
 	}
-	if d.hasNext() {
-		return d.nextHandler.execute(box)
+	if d.HasNext() {
+		return d.Pass(box)
 	}
 	return errors.New("no handler after decoder")
 }
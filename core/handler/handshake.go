@@ -2,9 +2,9 @@ package handler
 
 import (
 	"encoding/binary"
+	"fmt"
 	"time"
 
-	"github.com/beleege/gosrt/config"
 	"github.com/beleege/gosrt/core/session"
 	"github.com/beleege/gosrt/protocol/srt"
 	"github.com/beleege/gosrt/util/log"
@@ -13,7 +13,7 @@ import (
 )
 
 type handshake struct {
-	nextHandler srtHandler
+	Base
 }
 
 func NewHandshake() *handshake {
@@ -21,28 +21,21 @@ func NewHandshake() *handshake {
 	return h
 }
 
-func (h *handshake) hasNext() bool {
-	return h.nextHandler != nil
-}
-
-func (h *handshake) next(next srtHandler) {
-	h.nextHandler = next
-}
-
-func (h *handshake) execute(box *Box) error {
-	ss := box.s.Status.Load().(int)
-	if box.s.CP != nil && box.s.CP.CType == srt.CTHandShake && ss != session.SConnect {
-		if ss == session.SOpen {
+func (h *handshake) Execute(box *Box) error {
+	ss := box.s.State()
+	if box.s.CP != nil && box.s.CP.CType == srt.CTHandShake {
+		if ss == session.SConnect {
+			return resendConclusion(box)
+		} else if ss == session.SOpen {
 			return responseAndSetCookie(box)
 		} else if ss == session.SRepeat {
 			return establishConnection(box)
 		} else {
-			log.Infof("illegal handshake status: %d", box.s.Status.Load().(int))
-			box.s.Status.Store(session.SShutdown)
+			box.s.Shutdown(fmt.Sprintf("illegal handshake state: %s", ss))
 			return errors.Errorf("handshake fail")
 		}
-	} else if h.hasNext() {
-		return h.nextHandler.execute(box)
+	} else if h.HasNext() {
+		return h.Pass(box)
 	}
 	return errors.New("no handler after handshake")
 }
@@ -71,8 +64,7 @@ func responseAndSetCookie(box *Box) error {
 	if _, err = box.s.Write(box.b[:64]); err != nil {
 		return err
 	}
-	box.s.Status.Store(session.SSetCookie)
-	return nil
+	return box.s.Transit(session.SSetCookie, "cookie sent")
 }
 
 func buildCookie(box *Box, ipv4 *[4]byte) {
@@ -90,22 +82,134 @@ func establishConnection(box *Box) error {
 	if err != nil {
 		return nil
 	}
+	if reason := admit(box.s); reason != 0 {
+		return rejectConnection(box, reason)
+	}
+	if len(box.b) < 80 {
+		box.b = append(box.b, make([]byte, 80-len(box.b))...)
+	}
 	binary.BigEndian.PutUint32(box.b[8:12], uint32(0))
 	binary.BigEndian.PutUint32(box.b[12:16], box.s.ThatSID)
-	binary.BigEndian.PutUint16(box.b[22:24], uint16(1))
+	binary.BigEndian.PutUint16(box.b[22:24], uint16(srt.HSFlagHSREQ))
 	binary.BigEndian.PutUint32(box.b[40:44], box.s.ThisSID)
 	box.b[48] = ipv4[3]
 	box.b[49] = ipv4[2]
 	box.b[50] = ipv4[1]
 	box.b[51] = ipv4[0]
 
+	rsp := negotiate(box.s)
 	binary.BigEndian.PutUint16(box.b[64:66], uint16(srt.HSExtTypeHSRsp))
-	binary.BigEndian.PutUint16(box.b[76:78], math.MaxUInt16(box.s.TSBPD.TxDelay, config.GetRx()))
-	binary.BigEndian.PutUint16(box.b[78:80], math.MaxUInt16(box.s.TSBPD.RxDelay, config.GetTx()))
+	binary.BigEndian.PutUint16(box.b[66:68], uint16(3))
+	rsp.Encode(box.b[68:80])
+	box.b = box.b[:80]
+	if box.s.Decrypter != nil {
+		// accepted key material is sent back as KMRSP
+		km := &srt.HSExtension{EType: srt.HSExtTypeKMRsp, EContent: box.s.KMReq}
+		box.b = append(box.b, km.Bytes()...)
+	}
 
-	if _, err = box.s.Write(box.b[:80]); err != nil {
+	if _, err = box.s.Write(box.b); err != nil {
 		return err
 	}
-	box.s.Status.Store(session.SConnect)
+	box.s.Conclusion = append([]byte(nil), box.b...)
+	if err = box.s.Connect(); err != nil {
+		return err
+	}
+	go reportLoss(box.s)
 	return nil
 }
+
+// resendConclusion answers a conclusion repeated by the connected caller, the response sent may be lost
+func resendConclusion(box *Box) error {
+	s := box.s
+	cif := srt.ParseHCIF(s.CP.CIF)
+	if cif.HType != srt.HSTypeConclusion || cif.SocketID != s.ThatSID || cif.Cookie != s.Cookie || s.Conclusion == nil {
+		return nil
+	}
+	log.Debugf("resend conclusion response to [%s]", s.GetPeer())
+	_, err := s.Write(s.Conclusion)
+	return err
+}
+
+// admit asks admission hook of listener and checks encryption, returns rejection reason or zero
+func admit(s *session.SRTSession) uint32 {
+	if s.Opts.Admit != nil {
+		req := &session.ConnRequest{StreamID: s.StreamID, Peer: s.RemoteAddr(), Encrypted: s.KMReq != nil}
+		if s.TSBPD != nil {
+			req.SRTVersion = s.TSBPD.SRTVersion
+		}
+		if a := s.Opts.Admit(req); a != nil {
+			if a.Reject != 0 {
+				return a.Reject
+			}
+			s.Passphrase = a.Passphrase
+			if a.Latency > 0 {
+				opts := *s.Opts
+				opts.RxLatency = a.Latency
+				opts.TxLatency = a.Latency
+				s.Opts = &opts
+			}
+		}
+	}
+
+	if (len(s.Passphrase) > 0) != (s.KMReq != nil) {
+		return srt.RejUnsecure
+	}
+	if s.KMReq != nil {
+		km, err := srt.ParseKeyMaterial(s.KMReq)
+		if err == nil {
+			s.Decrypter, err = km.Decrypter(s.Passphrase)
+		}
+		if err != nil {
+			log.Errorf("key material of [%s] is rejected: %s", s.GetPeer(), err.Error())
+			return srt.RejBadSecret
+		}
+	}
+	return 0
+}
+
+func rejectConnection(box *Box, reason uint32) error {
+	log.Infof("reject [%s] stream[%s] with reason[%d]", box.s.GetPeer(), box.s.StreamID, reason)
+	binary.BigEndian.PutUint32(box.b[8:12], uint32(0))
+	binary.BigEndian.PutUint32(box.b[12:16], box.s.ThatSID)
+	binary.BigEndian.PutUint32(box.b[36:40], reason)
+	binary.BigEndian.PutUint32(box.b[40:44], box.s.ThisSID)
+	if _, err := box.s.Write(box.b[:64]); err != nil {
+		return err
+	}
+	box.s.Shutdown(fmt.Sprintf("rejected with reason[%d]", reason))
+	return nil
+}
+
+// negotiate builds HSRSP from caller's HSREQ and applies the agreed flags to the receiver
+func negotiate(s *session.SRTSession) *srt.HSExtTSBPD {
+	req := s.TSBPD
+	if req == nil {
+		req = new(srt.HSExtTSBPD)
+	}
+	rsp := new(srt.HSExtTSBPD)
+	rsp.SRTVersion = srt.SRTVersion
+	// caller sends with TSBPD, so we receive with it and vice versa
+	if req.Has(srt.SRTFlagTSBPDSND) {
+		rsp.SRTFlags |= srt.SRTFlagTSBPDRCV
+	}
+	if req.Has(srt.SRTFlagTSBPDRCV) {
+		rsp.SRTFlags |= srt.SRTFlagTSBPDSND
+	}
+	if req.Has(srt.SRTFlagTLPKTDROP) && s.Opts.TLPktDrop {
+		rsp.SRTFlags |= srt.SRTFlagTLPKTDROP
+	}
+	if req.Has(srt.SRTFlagNAKREPORT) && s.Opts.NAKReport {
+		rsp.SRTFlags |= srt.SRTFlagNAKREPORT
+	}
+	if req.Has(srt.SRTFlagREXMITFLG) {
+		rsp.SRTFlags |= srt.SRTFlagREXMITFLG
+	}
+	// receiver delay covers the delay caller asks for sending and vice versa
+	rsp.TxDelay = math.MaxUInt16(req.RxDelay, s.Opts.RxLatency)
+	rsp.RxDelay = math.MaxUInt16(req.TxDelay, s.Opts.TxLatency)
+
+	s.Agree(rsp.SRTFlags, rsp.TxDelay)
+	log.Infof("negotiate with [%s]: version[%x] flags[%x] latency[%dms]", s.GetPeer(), req.SRTVersion, s.SRTFlags, s.Latency)
+	return rsp
+}
@@ -1,93 +1,156 @@
 package handler
 
 import (
-	"strconv"
+	"sync"
 	"time"
 
-	"github.com/beleege/gosrt/config"
 	"github.com/beleege/gosrt/core/session"
 	"github.com/beleege/gosrt/protocol/srt"
+	"github.com/beleege/gosrt/util/buffer"
 	"github.com/beleege/gosrt/util/log"
 	"github.com/beleege/gosrt/util/pool"
 )
 
+var _boxPool = sync.Pool{New: func() interface{} {
+	return new(Box)
+}}
+
+// Box is a received packet on its way through handler chain
 type Box struct {
 	s *session.SRTSession
 	b []byte
+	// holds b if it is pooled
+	buf     *buffer.Buffer
+	handler Handler
 }
 
 func NewBox(s *session.SRTSession, b []byte) *Box {
-	box := new(Box)
+	box := _boxPool.Get().(*Box)
 	box.s = s
 	box.b = b
 	return box
 }
 
-var Queue = make(chan *Box, 1024)
+// NewBufferBox takes over the hold of buf, it is released with box
+func NewBufferBox(s *session.SRTSession, buf *buffer.Buffer, n int) *Box {
+	box := NewBox(s, buf.B[:n])
+	box.buf = buf
+	return box
+}
+
+func (box *Box) Session() *session.SRTSession {
+	return box.s
+}
+
+// Bytes returns the raw packet
+func (box *Box) Bytes() []byte {
+	return box.b
+}
+
+// Buffer returns the pooled buffer holding raw packet, nil if it is not pooled
+func (box *Box) Buffer() *buffer.Buffer {
+	return box.buf
+}
 
-var taskPool *pool.Pool
+// ControlPacket returns the packet parsed by decoder, nil if it is a data packet
+func (box *Box) ControlPacket() *srt.ControlPacket {
+	return box.s.CP
+}
 
-type srtHandler interface {
-	hasNext() bool
-	next(h srtHandler)
-	execute(s *Box) error
+// DataPacket returns the packet parsed by decoder, nil if it is a control packet
+func (box *Box) DataPacket() *srt.DataPacket {
+	return box.s.DP
 }
 
-type srtContext struct {
-	handler srtHandler
-	box     *Box
+// Release drops the hold of buffer and recycles box, stages keeping the packet must Retain it before
+func (box *Box) Release() {
+	if box.buf != nil {
+		box.buf.Release()
+	}
+	*box = Box{}
+	_boxPool.Put(box)
 }
 
-func (c *srtContext) GetID() string {
-	return strconv.Itoa(int(c.box.s.ThatSID))
+func (box *Box) GetID() string {
+	return box.s.Key()
 }
 
-func (c *srtContext) GetTask() pool.Task {
+func (box *Box) GetTask() pool.Task {
 	return func(args ...interface{}) error {
-		if err := c.handler.execute(c.box); err != nil {
-			log.Errorf("handle session fail: %s", err.Error())
-			closeConnect(c.box)
-		}
-		return nil
+		return box.Run()
 	}
 }
 
-func Task() {
-	taskPool = pool.NewFixedSizePool(config.GetPoolSize())
-	defer taskPool.Clear()
+// Run executes handler chain and releases box
+func (box *Box) Run() error {
+	s := box.s
+	if err := box.handler.Execute(box); err != nil {
+		log.Errorf("handle session fail: %s", err.Error())
+		CloseConnect(s)
+	}
+	// parsed packets refer to buffer
+	s.CP, s.DP = nil, nil
+	box.Release()
+	return nil
+}
+
+// Dispatcher runs handler chain for boxes, boxes of a session are handled in order
+type Dispatcher struct {
+	pool  *pool.Pool
+	chain Handler
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewDispatcher runs the default chain on size workers
+func NewDispatcher(size int) *Dispatcher {
+	opts := pool.DefaultOptions()
+	opts.Workers = size
+	d, _ := NewChainDispatcher(DefaultChain(), opts)
+	return d
+}
 
-	chain := wrap()
-	for box := range Queue {
-		ctx := &srtContext{handler: chain, box: box}
-		if err := taskPool.Execute(ctx); err != nil {
-			log.Errorf("task execute fail: %s", err.Error())
-			taskPool.Remove(ctx.GetID())
-		}
+func NewChainDispatcher(c *Chain, opts *pool.Options) (*Dispatcher, error) {
+	chain, err := c.Build()
+	if err != nil {
+		return nil, err
 	}
+	d := new(Dispatcher)
+	d.pool = pool.New(opts)
+	d.chain = chain
+	d.done = make(chan struct{})
+	return d, nil
 }
 
-func wrap() srtHandler {
-	handlers := selectHandlers()
-	for i := len(handlers) - 2; i >= 0; i-- {
-		handlers[i].next(handlers[i+1])
+// Dispatch queues box to the worker of its session, it blocks or drops by the pool policy
+func (d *Dispatcher) Dispatch(box *Box) {
+	box.handler = d.chain
+	if err := d.pool.Execute(box); err != nil {
+		log.Debugf("session[%s] drop packet: %s", box.s.GetPeer(), err.Error())
+		box.Release()
 	}
-	return handlers[0]
 }
 
-func selectHandlers() []srtHandler {
-	list := make([]srtHandler, 0, 6)
-	list = append(list, NewValidator())
-	list = append(list, NewDecoder())
-	list = append(list, NewAckAck())
-	list = append(list, NewShutdown())
-	list = append(list, NewHandshake())
-	list = append(list, NewDataStream())
-	return list
+// Run blocks until Close, then waits for the queued boxes handled
+func (d *Dispatcher) Run() {
+	<-d.done
+	d.pool.Clear()
 }
 
-func closeConnect(box *Box) {
-	s := box.s
-	if s.Status.Load().(int) == session.SShutdown {
+// Release skips and releases the queued boxes of a removed session, its later boxes are dropped
+func (d *Dispatcher) Release(s *session.SRTSession) {
+	d.pool.Remove(s.Key())
+}
+
+func (d *Dispatcher) Close() {
+	d.once.Do(func() {
+		close(d.done)
+	})
+}
+
+// CloseConnect notifies peer of shutdown
+func CloseConnect(s *session.SRTSession) {
+	if s.State() == session.SShutdown {
 		return
 	}
 	p := new(srt.ControlPacket)
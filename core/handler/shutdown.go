@@ -1,14 +1,13 @@
 package handler
 
 import (
-	"github.com/beleege/gosrt/core/session"
 	"github.com/beleege/gosrt/protocol/srt"
 	"github.com/beleege/gosrt/util/log"
 	"github.com/pkg/errors"
 )
 
 type shutdown struct {
-	nextHandler srtHandler
+	Base
 }
 
 func NewShutdown() *shutdown {
@@ -16,21 +15,15 @@ func NewShutdown() *shutdown {
 	return h
 }
 
-func (h *shutdown) hasNext() bool {
-	return h.nextHandler != nil
-}
-
-func (h *shutdown) next(next srtHandler) {
-	h.nextHandler = next
-}
-
-func (h *shutdown) execute(box *Box) error {
+func (h *shutdown) Execute(box *Box) error {
 	if box.s.CP != nil && box.s.CP.CType == srt.CTShutdown {
-		log.Infof("stream[%s] session shutdown", box.s.StreamID)
-		box.s.Status.Store(session.SShutdown)
+		c := box.s.Stats(false).Total
+		log.Infof("stream[%s] session shutdown, received[%d] lost[%d] retransmitted[%d] dropped[%d] belated[%d]",
+			box.s.StreamID, c.PktRecv, c.PktRcvLoss, c.PktRcvRetrans, c.PktRcvDrop, c.PktRcvBelated)
+		box.s.Shutdown("peer shutdown")
 		return nil
-	} else if h.hasNext() {
-		return h.nextHandler.execute(box)
+	} else if h.HasNext() {
+		return h.Pass(box)
 	}
 	return errors.New("no handler after shutdown")
 }
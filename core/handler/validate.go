@@ -9,7 +9,7 @@ const (
 )
 
 type validator struct {
-	nextHandler srtHandler
+	Base
 }
 
 func NewValidator() *validator {
@@ -17,19 +17,11 @@ func NewValidator() *validator {
 	return v
 }
 
-func (v *validator) hasNext() bool {
-	return v.nextHandler != nil
-}
-
-func (v *validator) next(next srtHandler) {
-	v.nextHandler = next
-}
-
-func (v *validator) execute(box *Box) error {
+func (v *validator) Execute(box *Box) error {
 	if size := len(box.b); size > _maxPacketBytes {
 		return errors.Errorf("package size[%d] is illegal", len(box.b))
-	} else if v.hasNext() {
-		return v.nextHandler.execute(box)
+	} else if v.HasNext() {
+		return v.Pass(box)
 	}
 	return errors.New("no handler after validator")
 }
@@ -2,61 +2,126 @@ package selector
 
 import (
 	"net"
-	"sync"
+	"sync/atomic"
 
 	"github.com/beleege/gosrt/core/handler"
 	"github.com/beleege/gosrt/core/session"
+	"github.com/beleege/gosrt/util/batch"
+	"github.com/beleege/gosrt/util/buffer"
 	"github.com/beleege/gosrt/util/log"
 )
 
-const (
-	_mtuLimit = 1400
-)
+// Selector reads packets from conn and dispatches them by peer session
+type Selector struct {
+	conn       net.PacketConn
+	dispatcher *handler.Dispatcher
+	sessions   *session.Registry
+	// nil opts makes selector serve the sessions added only
+	opts *session.Options
+	// subscribes state events of sessions created by selector
+	OnEvent session.EventHook
+	// no more handshake is accepted once set
+	draining int32
+}
 
-var (
-	_sessions map[string]*session.SRTSession
-	_pool     *sync.Pool
-)
+func New(conn net.PacketConn, d *handler.Dispatcher, opts *session.Options) *Selector {
+	sel := new(Selector)
+	sel.conn = conn
+	sel.dispatcher = d
+	sel.sessions = session.NewRegistry()
+	sel.sessions.Subscribe(func(n *session.Notice) {
+		if n.Change == session.Removed {
+			d.Release(n.Session)
+		}
+	})
+	sel.opts = opts
+	return sel
+}
 
-func Select(conn net.PacketConn) {
-	_sessions = make(map[string]*session.SRTSession)
-	//_pool = &sync.Pool{New: newBuf}
+// batchReader reads many datagrams in one call
+type batchReader interface {
+	ReadBatch(ms []batch.Message) (int, error)
+}
 
+// Select reads conn until it is closed, in batches if conn supports
+func (sel *Selector) Select() {
 	defer func() {
-		close(handler.Queue)
+		sel.dispatcher.Close()
 	}()
 
+	if br, ok := sel.conn.(batchReader); ok {
+		sel.selectBatch(br)
+		return
+	}
 	for {
-		buf := make([]byte, _mtuLimit)
-		if n, from, err := conn.ReadFrom(buf); err == nil {
-			client := from.String()
-
-			s := _sessions[client]
-			if s == nil {
-				log.Infof("########## create session for %s", client)
-				s = session.NewSRTSession(conn, from)
-				_sessions[client] = s
-			}
-
-			handler.Queue <- handler.NewBox(s, buf[:n])
+		buf := buffer.Get()
+		if n, from, err := sel.conn.ReadFrom(buf.B); err == nil {
+			sel.onPacket(buf, n, from)
 		} else {
+			buf.Release()
 			//l.notifyReadError(errors.WithStack(err))
 			return
 		}
 	}
 }
 
-func GetAllSession() (list []*session.SRTSession) {
-	for k, _ := range _sessions {
-		list = append(list, _sessions[k])
+func (sel *Selector) selectBatch(br batchReader) {
+	ms := make([]batch.Message, batch.Size)
+	for {
+		n, err := br.ReadBatch(ms)
+		for i := 0; i < n; i++ {
+			// box takes over the buffer
+			sel.onPacket(ms[i].Buf, ms[i].N, ms[i].Addr)
+			ms[i] = batch.Message{}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// onPacket dispatches the datagram of buf to session, buf is released in the end
+func (sel *Selector) onPacket(buf *buffer.Buffer, n int, from net.Addr) {
+	client := from.String()
+
+	draining := atomic.LoadInt32(&sel.draining) == 1
+	s := sel.sessions.Get(client)
+	if s == nil {
+		if sel.opts == nil || draining {
+			buf.Release()
+			return
+		}
+		log.Infof("########## create session for %s", client)
+		s = session.NewSRTSession(sel.conn, from, sel.opts)
+		if sel.OnEvent != nil {
+			s.Subscribe(sel.OnEvent)
+		}
+		sel.sessions.Add(client, s)
+	}
+
+	if draining && s.State() != session.SConnect {
+		buf.Release()
+		return
 	}
-	return
+	s.Touch()
+	sel.dispatcher.Dispatch(handler.NewBufferBox(s, buf, n))
+}
+
+// Drain stops accepting handshakes, connected sessions are still served
+func (sel *Selector) Drain() {
+	atomic.StoreInt32(&sel.draining, 1)
+}
+
+// Add serves a session created outside, normally by caller
+func (sel *Selector) Add(s *session.SRTSession) {
+	sel.sessions.Add(s.GetPeer(), s)
 }
 
-func Recycle(d []byte) {
-	_pool.Put(d)
+// Registry holds the sessions served, shutdown ones are removed
+func (sel *Selector) Registry() *session.Registry {
+	return sel.sessions
 }
 
-func newBuf() interface{} {
-	return make([]byte, _mtuLimit)
+func (sel *Selector) Sessions() []*session.SRTSession {
+	return sel.sessions.All()
 }
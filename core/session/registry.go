@@ -0,0 +1,131 @@
+package session
+
+import (
+	"sync"
+)
+
+type Change int
+
+const (
+	Added Change = iota
+	Removed
+)
+
+// Notice is fired after a session is added to or removed from registry
+type Notice struct {
+	Change  Change
+	Key     string
+	Session *SRTSession
+}
+
+type NoticeHook func(n *Notice)
+
+// Registry keeps sessions by key, a session is removed once it is shutdown
+type Registry struct {
+	mu       sync.RWMutex
+	sessions map[string]*SRTSession
+	hooks    map[int]NoticeHook
+	nextHook int
+}
+
+func NewRegistry() *Registry {
+	r := new(Registry)
+	r.sessions = make(map[string]*SRTSession)
+	r.hooks = make(map[int]NoticeHook)
+	return r
+}
+
+func (r *Registry) Get(key string) *SRTSession {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.sessions[key]
+}
+
+// Add replaces the session of key, the old one is shutdown
+func (r *Registry) Add(key string, s *SRTSession) {
+	r.mu.Lock()
+	old := r.sessions[key]
+	r.sessions[key] = s
+	r.mu.Unlock()
+
+	if old != nil {
+		old.Shutdown("replaced by new session")
+		r.notify(&Notice{Change: Removed, Key: key, Session: old})
+	}
+	s.Subscribe(func(e *Event) {
+		if e.To == SShutdown {
+			r.remove(key, s)
+		}
+	})
+	r.notify(&Notice{Change: Added, Key: key, Session: s})
+	if s.State() == SShutdown {
+		r.remove(key, s)
+	}
+}
+
+func (r *Registry) Remove(key string) {
+	if s := r.Get(key); s != nil {
+		r.remove(key, s)
+	}
+}
+
+func (r *Registry) remove(key string, s *SRTSession) {
+	r.mu.Lock()
+	if r.sessions[key] != s {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.sessions, key)
+	r.mu.Unlock()
+
+	r.notify(&Notice{Change: Removed, Key: key, Session: s})
+}
+
+func (r *Registry) All() []*SRTSession {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]*SRTSession, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		list = append(list, s)
+	}
+	return list
+}
+
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.sessions)
+}
+
+// Subscribe registers a hook for added and removed sessions, returns an id to unsubscribe
+func (r *Registry) Subscribe(h NoticeHook) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextHook++
+	r.hooks[r.nextHook] = h
+	return r.nextHook
+}
+
+func (r *Registry) Unsubscribe(id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.hooks, id)
+}
+
+func (r *Registry) notify(n *Notice) {
+	r.mu.RLock()
+	hooks := make([]NoticeHook, 0, len(r.hooks))
+	for _, h := range r.hooks {
+		hooks = append(hooks, h)
+	}
+	r.mu.RUnlock()
+
+	for _, h := range hooks {
+		h(n)
+	}
+}
@@ -0,0 +1,53 @@
+package session
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	changes := make([]Change, 0, 4)
+	id := r.Subscribe(func(n *Notice) {
+		changes = append(changes, n.Change)
+	})
+
+	a := NewSRTSession(nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}, nil)
+	b := NewSRTSession(nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9001}, nil)
+	r.Add(a.GetPeer(), a)
+	r.Add(b.GetPeer(), b)
+	if r.Len() != 2 || r.Get(a.GetPeer()) != a {
+		t.Fatalf("registry should hold 2 sessions, got %d", r.Len())
+	}
+
+	a.Shutdown("test")
+	if r.Get(a.GetPeer()) != nil || r.Len() != 1 {
+		t.Error("shutdown session should be removed")
+	}
+
+	// a new session of the same peer replaces the old one
+	c := NewSRTSession(nil, b.RemoteAddr(), nil)
+	r.Add(c.GetPeer(), c)
+	if b.State() != SShutdown {
+		t.Error("replaced session should be shutdown")
+	}
+	if r.Get(c.GetPeer()) != c || r.Len() != 1 {
+		t.Error("replaced session should not remove the new one")
+	}
+
+	r.Unsubscribe(id)
+	r.Remove(c.GetPeer())
+	if r.Len() != 0 {
+		t.Error("registry should be empty")
+	}
+
+	expected := []Change{Added, Added, Removed, Removed, Added}
+	if len(changes) != len(expected) {
+		t.Fatalf("unexpected notices: %v", changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("notice %d is %d, expected %d", i, changes[i], expected[i])
+		}
+	}
+}
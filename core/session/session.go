@@ -2,41 +2,87 @@ package session
 
 import (
 	"container/list"
-	"github.com/beleege/gosrt/util/codec"
-	"github.com/beleege/gosrt/util/window"
+	"fmt"
 	"math/rand"
 	"net"
 	"strconv"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"time"
 
+	"github.com/beleege/gosrt/config"
 	"github.com/beleege/gosrt/protocol/srt"
+	"github.com/beleege/gosrt/util/buffer"
+	"github.com/beleege/gosrt/util/codec"
 	"github.com/beleege/gosrt/util/log"
+	"github.com/beleege/gosrt/util/seqno"
+	"github.com/beleege/gosrt/util/window"
+	"github.com/pkg/errors"
 )
 
 const (
-	SNew       = 0
-	SOpen      = 1
-	SSetCookie = 2
-	SRepeat    = 3
-	SConnect   = 4
-	SIllegal   = 0xFFFFFFFE
-	SShutdown  = 0xFFFFFFFF
+	_sendBufSize      = 8192
+	_handshakeTimeout = 5 * time.Second
+	_idleTimeout      = 5 * time.Second
 )
 
 type ACKAction func(s *SRTSession, seq uint32)
 
+// ConnRequest describes a caller before it is accepted
+type ConnRequest struct {
+	StreamID   string
+	Peer       net.Addr
+	SRTVersion uint32
+	Encrypted  bool
+}
+
+// Admission decides a caller, zero Reject accepts it
+type Admission struct {
+	Reject     uint32 // rejection reason sent in conclusion response
+	Passphrase string // decrypts the stream, caller must be encrypted if set
+	Latency    uint16 // receiver TSBPD delay in milliseconds, zero keeps the default
+}
+
+type AdmitFunc func(req *ConnRequest) *Admission
+
+// Options are local settings to negotiate with peer
+type Options struct {
+	RxLatency uint16 // receiver TSBPD delay in milliseconds
+	TxLatency uint16 // sender TSBPD delay in milliseconds
+	TLPktDrop bool
+	NAKReport bool
+	Admit     AdmitFunc
+	// session is shutdown if a handshake state lasts longer
+	HandshakeTimeout time.Duration
+	// connected session is shutdown if peer sends nothing for this long
+	IdleTimeout time.Duration
+}
+
+// DefaultOptions returns the options of the default config
+func DefaultOptions() *Options {
+	return NewOptions(config.Default())
+}
+
+// NewOptions builds options from the srt settings of c
+func NewOptions(c *config.Config) *Options {
+	return &Options{
+		RxLatency: c.SRT.Latency.RX,
+		TxLatency: c.SRT.Latency.TX,
+		TLPktDrop: c.TLPktDrop(),
+		NAKReport: c.NAKReport(),
+
+		HandshakeTimeout: _handshakeTimeout,
+		IdleTimeout:      _idleTimeout,
+	}
+}
+
 type SRTSession struct {
 	conn     net.PacketConn
 	peer     net.Addr
 	OpenTime time.Time
 	RecWin   *window.Entity
 	ActList  *list.List
-	ACKNo    uint32
-	ACKTime  uint32
-	RTTTime  uint32
-	RTTDiff  uint32
+	actMu    sync.Mutex
 
 	CP   *srt.ControlPacket
 	DP   *srt.DataPacket
@@ -50,19 +96,43 @@ type SRTSession struct {
 	Cookie   uint32
 	StreamID string
 	TSBPD    *srt.HSExtTSBPD
-	Status   atomic.Value
+	SRTFlags uint32 // flags agreed by both sides in HSREQ/HSRSP
+	Latency  uint16 // receiver TSBPD delay in milliseconds
+	Opts     *Options
+	SndBuf   *window.SendBuffer
+	// KMREQ of caller, nil if stream is not encrypted
+	KMReq      []byte
+	Passphrase string
+	Decrypter  *srt.Decrypter
+	// conclusion response sent, it is sent again for a repeated conclusion
+	Conclusion []byte
+
+	fsm     *machine
+	traffic traffic
+	done    chan struct{}
+	// local socket id in text, known before handshake
+	key string
 }
 
 func (s *SRTSession) Write(b []byte) (n int, err error) {
 	return s.conn.WriteTo(b, s.peer)
 }
 
-func NewSRTSession(c net.PacketConn, a net.Addr) *SRTSession {
+// NewSRTSession opens a session of peer a on c with opts, nil opts takes the defaults
+func NewSRTSession(c net.PacketConn, a net.Addr, opts *Options) *SRTSession {
 	s := new(SRTSession)
 	s.conn = c
 	s.peer = a
 	s.OpenTime = time.Now()
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	s.Opts = opts
+	s.done = make(chan struct{})
 	s.RecWin = window.New(1024, func(seq uint32) {
+		s.actMu.Lock()
+		defer s.actMu.Unlock()
+
 		if s.ActList.Len() > 0 {
 			e := s.ActList.Front()
 			if f, ok := e.Value.(ACKAction); ok {
@@ -74,16 +144,170 @@ func NewSRTSession(c net.PacketConn, a net.Addr) *SRTSession {
 	})
 	s.ActList = list.New()
 	s.ThisSID = rand.New(rand.NewSource(s.OpenTime.UnixNano())).Uint32()
-	s.Status.Store(SNew)
+	s.key = strconv.FormatUint(uint64(s.ThisSID), 10)
+	s.fsm = newMachine(s, s.Opts.HandshakeTimeout, s.Opts.IdleTimeout)
+	s.fsm.subscribe(func(e *Event) {
+		log.Infof("session[%s] %s -> %s: %s", s.GetPeer(), e.From, e.To, e.Reason)
+		if e.To == SShutdown {
+			close(s.done)
+			// stop window goroutines and let consumers of batch quit
+			s.RecWin.Close()
+		}
+	})
 	return s
 }
 
+// SetOptions replaces options before handshake, the timer of current state restarts with the new timeouts
+func (s *SRTSession) SetOptions(opts *Options) {
+	s.Opts = opts
+	s.fsm.mu.Lock()
+	defer s.fsm.mu.Unlock()
+
+	s.fsm.handshake = opts.HandshakeTimeout
+	s.fsm.idle = opts.IdleTimeout
+	s.fsm.arm()
+}
+
+func (s *SRTSession) State() State {
+	return s.fsm.current()
+}
+
+// Transit moves session to state if the transition is legal
+func (s *SRTSession) Transit(to State, reason string) error {
+	return s.fsm.transit(to, reason)
+}
+
+// Subscribe registers a hook fired after every state transition
+func (s *SRTSession) Subscribe(h EventHook) {
+	s.fsm.subscribe(h)
+}
+
+// Touch marks peer alive
+func (s *SRTSession) Touch() {
+	s.fsm.touch()
+}
+
+// Connect marks handshake done and prepares sending from the initial seq no
+func (s *SRTSession) Connect() error {
+	s.SndBuf = window.NewSendBuffer(_sendBufSize, s.SendNo)
+	return s.Transit(SConnect, "handshake done")
+}
+
+func (s *SRTSession) Shutdown(reason string) {
+	_ = s.Transit(SShutdown, reason)
+}
+
+// Done is closed when session is shutdown
+func (s *SRTSession) Done() <-chan struct{} {
+	return s.done
+}
+
+// SendData sends a copy of b as a message, it must fit in a packet
+func (s *SRTSession) SendData(b []byte) error {
+	buf := buffer.Get()
+	// send buffer holds payload until acknowledged
+	p := s.SndBuf.Next(buf, buf.B[:copy(buf.B, b)], uint32(time.Since(s.OpenTime).Microseconds()), s.ThatSID)
+	if err := s.WritePacket(p); err != nil {
+		return err
+	}
+	s.countSent(p)
+	return nil
+}
+
+// WritePacket encodes p into a pooled buffer and sends it
+func (s *SRTSession) WritePacket(p *srt.DataPacket) error {
+	out := buffer.Get()
+	defer out.Release()
+	_, err := s.Write(out.B[:p.Encode(out.B)])
+	return err
+}
+
+// Retransmit resends the pkgs from first to last reported lost by peer, pkgs already acknowledged are skipped
+func (s *SRTSession) Retransmit(first, last uint32) {
+	head, next := s.SndBuf.Bounds()
+	// an ack crossing the nak has released the start of range
+	if seqno.SeqOffset(first, head) > 0 {
+		first = head
+	}
+	// pkgs not sent yet are not lost
+	if seqno.SeqOffset(last, next) <= 0 {
+		last = seqno.Decrement(next)
+	}
+	for seq := first; seqno.SeqOffset(seq, last) >= 0; seq = seqno.Increment(seq) {
+		p := s.SndBuf.Get(seq)
+		if p == nil {
+			continue
+		}
+		// pkg in send buffer is shared with SendData
+		r := *p
+		r.R = true
+		s.traffic.mu.Lock()
+		s.traffic.sndLoss++
+		s.traffic.mu.Unlock()
+		if err := s.WritePacket(&r); err == nil {
+			s.traffic.mu.Lock()
+			s.traffic.sent++
+			s.traffic.retrans++
+			s.traffic.byteSent += uint64(len(p.Content))
+			s.traffic.byteRetrans += uint64(len(p.Content))
+			s.traffic.mu.Unlock()
+		}
+		p.Release()
+	}
+}
+
+// Decrypt decrypts p in place with the key agreed in handshake
+func (s *SRTSession) Decrypt(p *srt.DataPacket) error {
+	err := errors.New("encrypted packet without key")
+	if s.Decrypter != nil {
+		err = s.Decrypter.Decrypt(p)
+	}
+	if err != nil {
+		s.traffic.mu.Lock()
+		s.traffic.undecrypt++
+		s.traffic.mu.Unlock()
+	}
+	return err
+}
+
+// Rekey loads the keys of a KMREQ sent by peer after handshake
+func (s *SRTSession) Rekey(b []byte) error {
+	if s.Decrypter == nil {
+		return errors.New("stream is not encrypted")
+	}
+	km, err := srt.ParseKeyMaterial(b)
+	if err != nil {
+		return err
+	}
+	return s.Decrypter.Update(km, s.Passphrase)
+}
+
+// AddACKAction adds f if there is no action pending, only the first action is fired
 func (s *SRTSession) AddACKAction(f ACKAction) {
 	if f != nil {
-		s.ActList.PushBack(f)
+		s.actMu.Lock()
+		defer s.actMu.Unlock()
+
+		if s.ActList.Len() == 0 {
+			s.ActList.PushBack(f)
+		}
 	}
 }
 
+func (s *SRTSession) Agreed(flag uint32) bool {
+	return s.SRTFlags&flag != 0
+}
+
+// Agree applies the flags and latency negotiated in HSREQ/HSRSP to receiver
+func (s *SRTSession) Agree(flags uint32, latency uint16) {
+	s.SRTFlags = flags
+	s.Latency = latency
+	s.RecWin.SetDrop(s.Agreed(srt.SRTFlagTLPKTDROP))
+	s.RecWin.SetPeriodicNAK(s.Agreed(srt.SRTFlagNAKREPORT))
+	s.RecWin.SetRexmitFlag(s.Agreed(srt.SRTFlagREXMITFLG))
+	s.RecWin.SetLatency(time.Duration(latency) * time.Millisecond)
+}
+
 func (s *SRTSession) SetDP(pkg *srt.DataPacket) {
 	s.DP = pkg
 	s.SendNo = pkg.SequenceNum
@@ -91,29 +315,33 @@ func (s *SRTSession) SetDP(pkg *srt.DataPacket) {
 
 func (s *SRTSession) SetCP(pkg *srt.ControlPacket, cif *srt.HandShakeCIF) {
 	s.CP = pkg
-	s.parseHSExtension(cif.HSExt)
+	if s.State() == SConnect {
+		// repeated handshake after connected
+		return
+	}
+	s.ParseHSExtension(cif.HSExt)
+	var err error
 	if cif.Version == srt.HSv4 {
 		s.SendNo = cif.InitSequenceNum
 		s.MTU = cif.MTU
 		s.MFW = cif.MFW
 		s.ThatSID = cif.SocketID
 		if cif.HType == srt.HSTypeInduction {
-			s.Status.Store(SOpen)
+			err = s.Transit(SOpen, "induction")
 		}
 	} else if cif.Version == srt.HSv5 {
 		if cif.Cookie != s.Cookie {
-			log.Errorf("cookie[%d] is not match", cif.Cookie)
-			s.Status.Store(SIllegal)
-			return
-		}
-		if cif.HType == srt.HSTypeConclusion {
-			s.Status.Store(SRepeat)
-			s.ACKTime = uint32(time.Now().Unix())
+			err = s.Transit(SIllegal, fmt.Sprintf("cookie[%d] is not match", cif.Cookie))
+		} else if cif.HType == srt.HSTypeConclusion {
+			err = s.Transit(SRepeat, "conclusion")
 		}
 	}
+	if err != nil {
+		log.Errorf("session[%s] handshake fail: %s", s.GetPeer(), err.Error())
+	}
 }
 
-func (s *SRTSession) parseHSExtension(b []byte) {
+func (s *SRTSession) ParseHSExtension(b []byte) {
 	if len(b) == 0 {
 		return
 	}
@@ -131,20 +359,27 @@ func (s *SRTSession) parseHSExtension(b []byte) {
 			fallthrough
 		case srt.HSExtTypeHSRsp:
 			s.TSBPD = srt.ParseHExtension(ext.EContent)
+		case srt.HSExtTypeKMReq:
+			// response is built in the same buffer, keep a copy
+			s.KMReq = append([]byte(nil), ext.EContent...)
 		case srt.HSExtTypeSID:
-			s.StreamID = string(ext.EContent)
+			s.StreamID = srt.ParseStreamID(ext.EContent)
 		}
 	}
 }
 
 func parseMultiExt(b []byte) []*srt.HSExtension {
 	exts := make([]*srt.HSExtension, 0, 2)
-	for len(b) > 0 {
+	for len(b) >= 4 {
 		hse := new(srt.HSExtension)
 
 		b = codec.Decode16u(b, &hse.EType)
 		b = codec.Decode16u(b, &hse.ELength)
 		l := int(hse.ELength) * 4
+		if l > len(b) {
+			log.Errorf("extension[%d] length[%d] is illegal", hse.EType, l)
+			break
+		}
 		hse.EContent = b[:l]
 		b = b[l:]
 
@@ -166,6 +401,19 @@ func (s *SRTSession) GetPeerIPv4() (*[4]byte, error) {
 	return bytes, nil
 }
 
+func (s *SRTSession) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *SRTSession) RemoteAddr() net.Addr {
+	return s.peer
+}
+
+// Key identifies session in workers
+func (s *SRTSession) Key() string {
+	return s.key
+}
+
 func (s *SRTSession) GetPeer() string {
 	return s.peer.String()
 }
@@ -0,0 +1,98 @@
+package session
+
+import (
+	"net"
+	"testing"
+
+	"github.com/beleege/gosrt/protocol/srt"
+	"github.com/beleege/gosrt/util/seqno"
+)
+
+// recorder keeps the datagrams written to it
+type recorder struct {
+	net.PacketConn
+	sent [][]byte
+}
+
+func (r *recorder) WriteTo(b []byte, _ net.Addr) (int, error) {
+	r.sent = append(r.sent, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+// discard drops the datagrams written to it
+type discard struct {
+	net.PacketConn
+}
+
+func (discard) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return len(b), nil
+}
+
+func TestRetransmit(t *testing.T) {
+	conn := new(recorder)
+	s := NewSRTSession(conn, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}, nil)
+	s.SendNo = 100
+	for _, st := range []State{SOpen, SSetCookie, SRepeat} {
+		_ = s.Transit(st, "handshake")
+	}
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := s.SendData([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// an ack of 105 crosses the nak of 90 to 107
+	s.SndBuf.Ack(105)
+	conn.sent = nil
+	s.Retransmit(90, 107)
+
+	if len(conn.sent) != 3 {
+		t.Fatalf("3 pkgs should be resent, got %d", len(conn.sent))
+	}
+	for i, b := range conn.sent {
+		p := srt.ParseDPacket(b)
+		if p.SequenceNum != uint32(105+i) || !p.R {
+			t.Errorf("unexpected pkg %d of R %v", p.SequenceNum, p.R)
+		}
+	}
+	if st := s.Stats(false); st.Total.PktSndLoss != 3 || st.Total.PktRetrans != 3 {
+		t.Errorf("unexpected stats: %+v", st.Total)
+	}
+}
+
+func TestSendRetransmit(t *testing.T) {
+	s := NewSRTSession(discard{}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}, nil)
+	for _, st := range []State{SOpen, SSetCookie, SRepeat} {
+		_ = s.Transit(st, "handshake")
+	}
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// naks and acks race with sending
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			_ = s.SendData([]byte{byte(i)})
+		}
+	}()
+	for sending := true; sending; {
+		select {
+		case <-done:
+			sending = false
+		default:
+		}
+		// the pkg just sent is lost and the one before is acknowledged
+		_, next := s.SndBuf.Bounds()
+		last := seqno.Decrement(next)
+		s.Retransmit(last, last)
+		s.SndBuf.Ack(last)
+	}
+	s.SndBuf.Ack(1000)
+	if s.SndBuf.Len() != 0 {
+		t.Errorf("%d pkgs are left", s.SndBuf.Len())
+	}
+}
@@ -0,0 +1,185 @@
+package session
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type State uint32
+
+const (
+	SNew State = iota
+	SOpen
+	SSetCookie
+	SRepeat
+	SConnect
+	SIllegal
+	SShutdown
+)
+
+var _stateNames = [...]string{"new", "open", "set-cookie", "repeat", "connect", "illegal", "shutdown"}
+
+func (st State) String() string {
+	if int(st) < len(_stateNames) {
+		return _stateNames[st]
+	}
+	return "unknown"
+}
+
+// _transitions lists the legal next states of each state, shutdown is final
+var _transitions = map[State][]State{
+	SNew:       {SOpen, SIllegal, SShutdown},
+	SOpen:      {SOpen, SSetCookie, SIllegal, SShutdown},
+	SSetCookie: {SOpen, SRepeat, SIllegal, SShutdown},
+	SRepeat:    {SConnect, SIllegal, SShutdown},
+	SConnect:   {SShutdown},
+	SIllegal:   {SShutdown},
+}
+
+// Event is fired after a session changes state
+type Event struct {
+	Session *SRTSession
+	From    State
+	To      State
+	Reason  string
+}
+
+type EventHook func(e *Event)
+
+// machine guards state transitions of a session and shuts it down when handshake or a state after lasts too long
+type machine struct {
+	mu    sync.Mutex
+	s     *SRTSession
+	state State
+	timer *time.Timer
+	// generation of timer, a fired timer of an older state is ignored
+	gen uint64
+	// last packet arrival in unix nano
+	active int64
+	// handshake states and connected idle timeout
+	handshake time.Duration
+	idle      time.Duration
+	hooks     []EventHook
+}
+
+func newMachine(s *SRTSession, handshake, idle time.Duration) *machine {
+	m := new(machine)
+	m.s = s
+	m.state = SNew
+	m.handshake = handshake
+	m.idle = idle
+	m.active = time.Now().UnixNano()
+	m.arm()
+	return m
+}
+
+func (m *machine) current() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.state
+}
+
+func (m *machine) subscribe(h EventHook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hooks = append(m.hooks, h)
+}
+
+func (m *machine) touch() {
+	atomic.StoreInt64(&m.active, time.Now().UnixNano())
+}
+
+func (m *machine) transit(to State, reason string) error {
+	m.mu.Lock()
+	from := m.state
+	if !legal(from, to) {
+		m.mu.Unlock()
+		return errors.Errorf("illegal transition from %s to %s", from, to)
+	}
+	m.state = to
+	// handshake is timed from the first induction, a repeated induction does not extend it
+	if from == SNew || !handshaking(to) {
+		m.arm()
+	}
+	hooks := m.hooks
+	m.mu.Unlock()
+
+	e := &Event{Session: m.s, From: from, To: to, Reason: reason}
+	for _, h := range hooks {
+		h(e)
+	}
+	return nil
+}
+
+// warn: need lock protect
+func (m *machine) arm() {
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	m.gen++
+	gen := m.gen
+	switch m.state {
+	case SShutdown:
+		return
+	case SConnect:
+		if m.idle > 0 {
+			m.timer = time.AfterFunc(m.idle, func() {
+				m.onIdle(gen)
+			})
+		}
+	default:
+		if m.handshake > 0 {
+			m.timer = time.AfterFunc(m.handshake, func() {
+				m.onTimeout(gen)
+			})
+		}
+	}
+}
+
+func (m *machine) onTimeout(gen uint64) {
+	m.mu.Lock()
+	st := m.state
+	stale := gen != m.gen
+	m.mu.Unlock()
+	if stale {
+		return
+	}
+	_ = m.transit(SShutdown, "timeout in "+st.String())
+}
+
+func (m *machine) onIdle(gen uint64) {
+	m.mu.Lock()
+	if gen != m.gen {
+		m.mu.Unlock()
+		return
+	}
+	if left := m.idle - time.Duration(time.Now().UnixNano()-atomic.LoadInt64(&m.active)); left > 0 {
+		// peer is alive, check again when it may be idle
+		m.timer = time.AfterFunc(left, func() {
+			m.onIdle(gen)
+		})
+		m.mu.Unlock()
+		return
+	}
+	m.mu.Unlock()
+	_ = m.transit(SShutdown, "peer idle timeout")
+}
+
+func handshaking(st State) bool {
+	return st < SConnect
+}
+
+func legal(from, to State) bool {
+	for _, st := range _transitions[from] {
+		if st == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package session
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTransit(t *testing.T) {
+	s := NewSRTSession(nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}, nil)
+	events := make([]State, 0, 4)
+	s.Subscribe(func(e *Event) {
+		events = append(events, e.To)
+	})
+
+	if err := s.Transit(SConnect, "skip handshake"); err == nil {
+		t.Error("new session should not connect directly")
+	}
+	for _, st := range []State{SOpen, SSetCookie, SRepeat, SConnect} {
+		if err := s.Transit(st, "handshake"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Transit(SOpen, "induction again"); err == nil {
+		t.Error("connected session should not restart handshake")
+	}
+	s.Shutdown("test")
+	select {
+	case <-s.Done():
+	default:
+		t.Error("done should be closed after shutdown")
+	}
+	if len(events) != 5 || events[4] != SShutdown {
+		t.Errorf("unexpected events: %v", events)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	s := NewSRTSession(nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}, nil)
+	opts := *s.Opts
+	opts.HandshakeTimeout = 20 * time.Millisecond
+	s.SetOptions(&opts)
+	_ = s.Transit(SOpen, "induction")
+
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("session should be shutdown after handshake timeout")
+	}
+	if st := s.State(); st != SShutdown {
+		t.Errorf("state is %s", st)
+	}
+}
+
+func TestRepeatedInduction(t *testing.T) {
+	s := NewSRTSession(nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}, nil)
+	opts := *s.Opts
+	opts.HandshakeTimeout = 50 * time.Millisecond
+	s.SetOptions(&opts)
+
+	// inductions keep coming without conclusion
+	deadline := time.After(time.Second)
+	for {
+		_ = s.Transit(SOpen, "induction")
+		_ = s.Transit(SSetCookie, "cookie sent")
+		select {
+		case <-s.Done():
+			return
+		case <-deadline:
+			t.Fatalf("session in %s should be shutdown after handshake timeout", s.State())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	s := NewSRTSession(nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}, nil)
+	opts := *s.Opts
+	opts.IdleTimeout = 50 * time.Millisecond
+	s.SetOptions(&opts)
+	for _, st := range []State{SOpen, SSetCookie, SRepeat} {
+		_ = s.Transit(st, "handshake")
+	}
+	_ = s.Connect()
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		s.Touch()
+	}
+	if st := s.State(); st != SConnect {
+		t.Fatalf("active session is %s", st)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("session should be shutdown after idle timeout")
+	}
+}
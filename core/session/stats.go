@@ -0,0 +1,236 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/beleege/gosrt/protocol/srt"
+)
+
+const (
+	// acks remembered for rtt, an ackack older than these is ignored
+	_ackHistory = 16
+	// rtt and rtt variance before the first ackack, in microseconds
+	_initRTT    = 100000
+	_initRTTVar = 50000
+)
+
+// Counters are the cumulative traffic of a session
+type Counters struct {
+	PktSent       uint64 // data pkts sent, retransmissions included
+	PktSentUnique uint64 // data pkts sent for the first time
+	PktRetrans    uint64 // data pkts retransmitted for NAK
+	PktSndLoss    uint64 // pkts reported lost by peer
+	PktSndDrop    uint64 // pkts given up by send buffer before acknowledged
+	ByteSent      uint64 // payload bytes sent, retransmissions included
+	ByteRetrans   uint64 // payload bytes retransmitted
+
+	PktRecv         uint64 // data pkts received, duplicate and belated ones included
+	PktRcvLoss      uint64 // pkts detected lost by a gap of seq no
+	PktRcvRetrans   uint64 // pkts received with R flag
+	PktRcvDrop      uint64 // lost pkts given up after the latency passed
+	PktRcvBelated   uint64 // pkts received after their position was delivered
+	PktRcvUndecrypt uint64 // pkts failed to decrypt
+	ByteRecv        uint64 // payload bytes received
+}
+
+func (c Counters) sub(o Counters) Counters {
+	return Counters{
+		PktSent:         c.PktSent - o.PktSent,
+		PktSentUnique:   c.PktSentUnique - o.PktSentUnique,
+		PktRetrans:      c.PktRetrans - o.PktRetrans,
+		PktSndLoss:      c.PktSndLoss - o.PktSndLoss,
+		PktSndDrop:      c.PktSndDrop - o.PktSndDrop,
+		ByteSent:        c.ByteSent - o.ByteSent,
+		ByteRetrans:     c.ByteRetrans - o.ByteRetrans,
+		PktRecv:         c.PktRecv - o.PktRecv,
+		PktRcvLoss:      c.PktRcvLoss - o.PktRcvLoss,
+		PktRcvRetrans:   c.PktRcvRetrans - o.PktRcvRetrans,
+		PktRcvDrop:      c.PktRcvDrop - o.PktRcvDrop,
+		PktRcvBelated:   c.PktRcvBelated - o.PktRcvBelated,
+		PktRcvUndecrypt: c.PktRcvUndecrypt - o.PktRcvUndecrypt,
+		ByteRecv:        c.ByteRecv - o.ByteRecv,
+	}
+}
+
+// Stats is a snapshot of session like srt_bstats of libsrt
+type Stats struct {
+	Elapsed  time.Duration // since session opened
+	Period   time.Duration // since the last cleared snapshot
+	Total    Counters
+	Interval Counters // traffic in period
+
+	RTT        time.Duration // smoothed round trip time
+	RTTVar     time.Duration // round trip time variance
+	Bandwidth  uint32        // estimated link capacity in pkts per second
+	PeerRate   uint32        // pkts per second received by peer, reported in its acks
+	SendRate   float64       // Mbps sent in period
+	RecvRate   float64       // Mbps received in period
+	FlightSize int           // pkts sent and not acknowledged
+	RcvBuf     int           // pkts held by receive window
+	Latency    time.Duration // negotiated TSBPD delay
+}
+
+type ackRecord struct {
+	no uint32
+	t  time.Time
+}
+
+// traffic holds the counters not kept by windows
+type traffic struct {
+	mu          sync.Mutex
+	sent        uint64
+	sentUnique  uint64
+	retrans     uint64
+	sndLoss     uint64
+	byteSent    uint64
+	byteRetrans uint64
+	undecrypt   uint64
+	// snapshot of the last clear
+	last  Counters
+	since time.Time
+	// ack no of the last ack and send time of the recent ones
+	ackNo uint32
+	acks  [_ackHistory]ackRecord
+	// smoothed rtt and variance in microseconds, zero until the first ackack or ack of peer
+	rtt    int64
+	rttVar int64
+	// rates reported in acks of peer
+	pktRate   uint32
+	bandwidth uint32
+}
+
+// NextACK returns the ack no of a new ack and keeps its send time for rtt
+func (s *SRTSession) NextACK() uint32 {
+	s.traffic.mu.Lock()
+	defer s.traffic.mu.Unlock()
+
+	// ack no 0 is reserved for light ack
+	if s.traffic.ackNo++; s.traffic.ackNo == 0 {
+		s.traffic.ackNo = 1
+	}
+	s.traffic.acks[s.traffic.ackNo%_ackHistory] = ackRecord{no: s.traffic.ackNo, t: time.Now()}
+	return s.traffic.ackNo
+}
+
+// OnAckAck samples rtt from the ack of no, it is smoothed as RFC 6298
+func (s *SRTSession) OnAckAck(no uint32) {
+	s.traffic.mu.Lock()
+	defer s.traffic.mu.Unlock()
+
+	r := s.traffic.acks[no%_ackHistory]
+	if r.no != no || r.t.IsZero() {
+		return
+	}
+	sample := time.Since(r.t).Microseconds()
+	if s.traffic.rtt == 0 {
+		s.traffic.rtt = sample
+		s.traffic.rttVar = sample / 2
+		return
+	}
+	diff := s.traffic.rtt - sample
+	if diff < 0 {
+		diff = -diff
+	}
+	s.traffic.rttVar = (3*s.traffic.rttVar + diff) / 4
+	s.traffic.rtt = (7*s.traffic.rtt + sample) / 8
+}
+
+// OnACK records rtt and rates measured by peer in a full ack, so that the sending side has them as well
+func (s *SRTSession) OnACK(a *srt.ACKCIF) {
+	s.traffic.mu.Lock()
+	defer s.traffic.mu.Unlock()
+
+	if a.RTT > 0 {
+		if s.traffic.rtt == 0 {
+			s.traffic.rtt = int64(a.RTT)
+			s.traffic.rttVar = int64(a.RTTVar)
+		} else {
+			s.traffic.rtt = (7*s.traffic.rtt + int64(a.RTT)) / 8
+			s.traffic.rttVar = (3*s.traffic.rttVar + int64(a.RTTVar)) / 4
+		}
+	}
+	if a.PktRate > 0 {
+		s.traffic.pktRate = a.PktRate
+	}
+	if a.Bandwidth > 0 {
+		s.traffic.bandwidth = a.Bandwidth
+	}
+}
+
+// RTT returns the smoothed rtt and variance in microseconds, the initial values before measured
+func (s *SRTSession) RTT() (rtt, rttVar uint32) {
+	s.traffic.mu.Lock()
+	defer s.traffic.mu.Unlock()
+
+	if s.traffic.rtt == 0 {
+		return _initRTT, _initRTTVar
+	}
+	return uint32(s.traffic.rtt), uint32(s.traffic.rttVar)
+}
+
+// Stats returns the counters and gauges of session, clear starts a new period for the next snapshot
+func (s *SRTSession) Stats(clear bool) *Stats {
+	w := s.RecWin.Counters()
+	_, bandwidth := s.RecWin.Rates()
+	st := new(Stats)
+	st.RcvBuf = int(s.RecWin.Len())
+	st.Latency = time.Duration(s.Latency) * time.Millisecond
+	if s.SndBuf != nil {
+		st.FlightSize = s.SndBuf.Len()
+		st.Total.PktSndDrop = s.SndBuf.Dropped()
+	}
+	st.Bandwidth = bandwidth
+	st.Total.PktRecv = w.Received
+	st.Total.PktRcvLoss = w.Lost
+	st.Total.PktRcvRetrans = w.Retransmitted
+	st.Total.PktRcvDrop = w.Dropped
+	st.Total.PktRcvBelated = w.Belated
+	st.Total.ByteRecv = w.Bytes
+
+	now := time.Now()
+	s.traffic.mu.Lock()
+	defer s.traffic.mu.Unlock()
+
+	st.Total.PktSent = s.traffic.sent
+	st.Total.PktSentUnique = s.traffic.sentUnique
+	st.Total.PktRetrans = s.traffic.retrans
+	st.Total.PktSndLoss = s.traffic.sndLoss
+	st.Total.ByteSent = s.traffic.byteSent
+	st.Total.ByteRetrans = s.traffic.byteRetrans
+	st.Total.PktRcvUndecrypt = s.traffic.undecrypt
+	st.RTT = time.Duration(s.traffic.rtt) * time.Microsecond
+	st.RTTVar = time.Duration(s.traffic.rttVar) * time.Microsecond
+	st.PeerRate = s.traffic.pktRate
+	// sender knows the capacity of link from acks only
+	if st.Bandwidth == 0 {
+		st.Bandwidth = s.traffic.bandwidth
+	}
+
+	since := s.traffic.since
+	if since.IsZero() {
+		since = s.OpenTime
+	}
+	st.Elapsed = now.Sub(s.OpenTime)
+	st.Period = now.Sub(since)
+	st.Interval = st.Total.sub(s.traffic.last)
+	if secs := st.Period.Seconds(); secs > 0 {
+		st.SendRate = float64(st.Interval.ByteSent) * 8 / 1e6 / secs
+		st.RecvRate = float64(st.Interval.ByteRecv) * 8 / 1e6 / secs
+	}
+	if clear {
+		s.traffic.last = st.Total
+		s.traffic.since = now
+	}
+	return st
+}
+
+// countSent counts a pkg sent for the first time
+func (s *SRTSession) countSent(p *srt.DataPacket) {
+	s.traffic.mu.Lock()
+	defer s.traffic.mu.Unlock()
+
+	s.traffic.sent++
+	s.traffic.sentUnique++
+	s.traffic.byteSent += uint64(len(p.Content))
+}
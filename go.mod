@@ -6,4 +6,6 @@ require (
 	github.com/jinzhu/configor v1.2.1
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.8.1
+	golang.org/x/net v0.7.0
+	golang.org/x/sys v0.5.0
 )
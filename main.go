@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/beleege/gosrt/server"
 	logger "log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/beleege/gosrt/config"
@@ -14,8 +18,6 @@ const (
 	version = "0.1"
 )
 
-var ShutDownSignal = make(chan error)
-
 func printBanner() {
 	logger.Println(fmt.Sprintf(`
 ===============================================
@@ -30,38 +32,43 @@ func printBanner() {
 version: %s`, version))
 }
 
-func loadResource() {
-	config.InitConfig()
+func loadResource() *config.Config {
+	return config.InitConfig()
 }
 
-func preInit() {
-	log.InitLog()
+func preInit(conf *config.Config) {
+	log.InitLog(conf.LogLevel)
 }
 
-func serverInit() {
-	go server.SetupUDPServer()
-	// here need programmatically setup media server
-	go server.SetupHLSServer()
+func serverInit(ctx context.Context, conf *config.Config) *server.Server {
+	srv := server.New(conf)
+	if err := srv.Start(ctx); err != nil {
+		panic(err)
+	}
+	return srv
 }
 
 func main() {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Errorf("srt server panic: ", r)
+			log.Errorf("srt server panic: %v", r)
 			time.Sleep(1 * time.Second)
 		}
 		log.Infof("srt server stop")
 	}()
 
 	printBanner()
-	loadResource()
-	preInit()
-	serverInit()
+	conf := loadResource()
+	preInit(conf)
 
-	select {
-	case err := <-ShutDownSignal:
-		if err != nil {
-			panic(err)
-		}
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		log.Infof("receive signal %s, srt server shutting down", <-sig)
+		cancel()
+	}()
+
+	<-serverInit(ctx, conf).Done()
 }
@@ -0,0 +1,130 @@
+package audio
+
+var (
+	// kbps by frmsizecod/2
+	_ac3Bitrates    = [...]int{32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 448, 512, 576, 640}
+	_ac3SampleRates = [3]int{48000, 44100, 32000}
+	// channels of acmod without lfe
+	_ac3Channels = [8]int{2, 1, 2, 3, 3, 4, 4, 5}
+	// audio blocks by numblkscod
+	_eac3Blocks = [4]int{1, 2, 3, 6}
+)
+
+// AC3 is the header of an AC-3 or E-AC-3 syncframe
+type AC3 struct {
+	Frame
+	// bit stream id, over 10 for E-AC-3
+	BSID uint8
+	// stream type of E-AC-3, 1 for a dependent substream
+	StreamType uint8
+	ACMod      uint8
+	LFE        bool
+}
+
+// ParseAC3 parses the header of the syncframe at the beginning of b, by its bsid as AC-3 or E-AC-3
+func ParseAC3(b []byte) (*AC3, error) {
+	if len(b) < 2 || b[0] != 0x0B || b[1] != 0x77 {
+		return nil, ErrSync
+	}
+	if len(b) < 8 {
+		return nil, ErrShort
+	}
+	h := new(AC3)
+	h.BSID = b[5] >> 3
+	switch {
+	case h.BSID <= 10:
+		if !h.ac3(b) {
+			return nil, ErrSync
+		}
+	case h.BSID <= 16:
+		h.eac3(b)
+	default:
+		return nil, ErrSync
+	}
+	h.Channels = _ac3Channels[h.ACMod]
+	if h.LFE {
+		h.Channels++
+	}
+	return h, nil
+}
+
+func (h *AC3) ac3(b []byte) bool {
+	fscod := int(b[4] >> 6)
+	frmsizecod := int(b[4] & 0x3F)
+	if fscod == 3 || frmsizecod/2 >= len(_ac3Bitrates) {
+		return false
+	}
+	h.Codec = "ac-3"
+	h.SampleRate = _ac3SampleRates[fscod]
+	// 16 bits words of 1536 samples
+	words := _ac3Bitrates[frmsizecod/2] * 96000 / h.SampleRate
+	if fscod == 1 && frmsizecod&1 == 1 {
+		words++
+	}
+	h.Size = words * 2
+	h.Samples = 1536
+
+	// acmod follows bsid and bsmod
+	r := newBitReader(b[6:])
+	h.ACMod = uint8(r.u(3))
+	if h.ACMod&0x01 != 0 && h.ACMod != 1 {
+		// cmixlev
+		r.u(2)
+	}
+	if h.ACMod&0x04 != 0 {
+		// surmixlev
+		r.u(2)
+	}
+	if h.ACMod == 2 {
+		// dsurmod
+		r.u(2)
+	}
+	h.LFE = r.u(1) == 1
+	return true
+}
+
+func (h *AC3) eac3(b []byte) {
+	h.Codec = "ec-3"
+	h.StreamType = b[2] >> 6
+	h.Size = (int(b[2]&0x07)<<8 | int(b[3]) + 1) * 2
+	fscod := int(b[4] >> 6)
+	blocks := 6
+	if fscod == 3 {
+		// reduced sample rates of fscod2
+		fscod2 := int(b[4]>>4) & 0x03
+		if fscod2 < 3 {
+			h.SampleRate = _ac3SampleRates[fscod2] / 2
+		}
+	} else {
+		h.SampleRate = _ac3SampleRates[fscod]
+		blocks = _eac3Blocks[b[4]>>4&0x03]
+	}
+	h.ACMod = b[4] >> 1 & 0x07
+	h.LFE = b[4]&0x01 != 0
+	if h.StreamType != 1 {
+		h.Samples = 256 * blocks
+	}
+}
+
+// bitReader reads bits of b in order, zero is read over the end
+type bitReader struct {
+	b   []byte
+	pos int
+}
+
+func newBitReader(b []byte) *bitReader {
+	return &bitReader{b: b}
+}
+
+func (r *bitReader) u(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		var bit uint32
+		if r.pos>>3 < len(r.b) {
+			bit = uint32(r.b[r.pos>>3]>>(7-uint(r.pos&7))) & 1
+		}
+		v = v<<1 | bit
+		r.pos++
+	}
+	return v
+}
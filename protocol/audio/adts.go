@@ -0,0 +1,54 @@
+package audio
+
+import (
+	"fmt"
+)
+
+var _aacSampleRates = [...]int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// ADTS is the header of an AAC frame in ADTS
+type ADTS struct {
+	Frame
+	// audio object type minus one, 1 for AAC LC
+	Profile uint8
+	// channel_configuration, zero if channels are in the program config element
+	ChannelConfig uint8
+	HeaderSize    int
+	// raw data blocks of frame
+	Blocks int
+}
+
+// ParseADTS parses the header of the ADTS frame at the beginning of b
+func ParseADTS(b []byte) (*ADTS, error) {
+	if len(b) < 2 || b[0] != 0xFF || b[1]&0xF6 != 0xF0 {
+		return nil, ErrSync
+	}
+	if len(b) < 7 {
+		return nil, ErrShort
+	}
+	sf := int(b[2]>>2) & 0x0F
+	if sf >= len(_aacSampleRates) {
+		return nil, ErrSync
+	}
+	h := new(ADTS)
+	h.Profile = b[2] >> 6
+	h.ChannelConfig = (b[2]&0x01)<<2 | b[3]>>6
+	h.HeaderSize = 7
+	if b[1]&0x01 == 0 {
+		// crc follows
+		h.HeaderSize = 9
+	}
+	h.Size = int(b[3]&0x03)<<11 | int(b[4])<<3 | int(b[5]>>5)
+	if h.Size < h.HeaderSize {
+		return nil, ErrSync
+	}
+	h.Blocks = int(b[6]&0x03) + 1
+	h.Codec = fmt.Sprintf("mp4a.40.%d", h.Profile+1)
+	h.SampleRate = _aacSampleRates[sf]
+	h.Channels = int(h.ChannelConfig)
+	if h.ChannelConfig == 7 {
+		h.Channels = 8
+	}
+	h.Samples = 1024 * h.Blocks
+	return h, nil
+}
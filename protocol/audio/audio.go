@@ -0,0 +1,130 @@
+package audio
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrSync tells no frame starts at the beginning, the parser looks for the next one
+	ErrSync  = errors.New("audio frame is out of sync")
+	ErrShort = errors.New("audio frame is too short")
+)
+
+// Codec is the kind of an audio elementary stream
+type Codec int
+
+const (
+	CodecUnknown Codec = iota
+	// AAC in ADTS
+	CodecAAC
+	// MPEG-1/2 audio of Layer I, II or III
+	CodecMPEG
+	// AC-3 or E-AC-3 syncframes
+	CodecAC3
+	// Opus access units, each after the control header of Opus in MPEG-2 TS
+	CodecOpus
+)
+
+// Frame is the header of an audio frame
+type Frame struct {
+	// RFC 6381 codec string as in the HLS CODECS attribute
+	Codec string
+	// bytes of frame including header
+	Size       int
+	SampleRate int
+	Channels   int
+	// samples of a channel, zero for a dependent substream sharing time with its independent one
+	Samples int
+}
+
+// Duration returns the duration of frame in seconds
+func (f *Frame) Duration() float64 {
+	if f.SampleRate <= 0 {
+		return 0
+	}
+	return float64(f.Samples) / float64(f.SampleRate)
+}
+
+// Info describes an audio stream from its frames
+type Info struct {
+	Codec      string
+	SampleRate int
+	Channels   int
+	// duration of a frame in seconds
+	FrameDuration float64
+}
+
+// Parser follows the frames of an audio stream
+type Parser struct {
+	codec Codec
+	info  *Info
+}
+
+func NewParser(c Codec) *Parser {
+	p := new(Parser)
+	p.codec = c
+	return p
+}
+
+// Info returns the stream info from the last frame, nil until a frame is found
+func (p *Parser) Info() *Info {
+	return p.info
+}
+
+// Parse reads the frames of PES data b, returns their duration in seconds, a truncated frame is counted
+func (p *Parser) Parse(b []byte) float64 {
+	var d float64
+	for len(b) > 0 {
+		f, err := p.frame(b)
+		if err == ErrSync {
+			// garbage before a frame
+			b = b[1:]
+			continue
+		}
+		if err != nil || f.Size <= 0 {
+			break
+		}
+		if f.Samples > 0 {
+			d += f.Duration()
+			info := Info{Codec: f.Codec, SampleRate: f.SampleRate, Channels: f.Channels, FrameDuration: f.Duration()}
+			if p.info == nil || *p.info != info {
+				p.info = &info
+			}
+		}
+		if f.Size >= len(b) {
+			break
+		}
+		b = b[f.Size:]
+	}
+	return d
+}
+
+func (p *Parser) frame(b []byte) (*Frame, error) {
+	switch p.codec {
+	case CodecAAC:
+		h, err := ParseADTS(b)
+		if err != nil {
+			return nil, err
+		}
+		return &h.Frame, nil
+	case CodecMPEG:
+		h, err := ParseMPEGAudio(b)
+		if err != nil {
+			return nil, err
+		}
+		return &h.Frame, nil
+	case CodecAC3:
+		h, err := ParseAC3(b)
+		if err != nil {
+			return nil, err
+		}
+		return &h.Frame, nil
+	case CodecOpus:
+		h, err := ParseOpus(b)
+		if err != nil {
+			return nil, err
+		}
+		return &h.Frame, nil
+	}
+	return nil, errors.New("unknown audio codec")
+}
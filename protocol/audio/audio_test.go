@@ -0,0 +1,99 @@
+package audio
+
+import (
+	"testing"
+)
+
+// adtsFrame builds an AAC LC frame of 48kHz stereo of size bytes
+func adtsFrame(size int) []byte {
+	b := make([]byte, size)
+	copy(b, []byte{0xFF, 0xF1, 0x4C, 0x80 | byte(size>>11), byte(size >> 3), byte(size&0x07)<<5 | 0x1F, 0xFC})
+	return b
+}
+
+func TestADTS(t *testing.T) {
+	h, err := ParseADTS(adtsFrame(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Codec != "mp4a.40.2" || h.SampleRate != 48000 || h.Channels != 2 || h.Size != 100 || h.Samples != 1024 || h.HeaderSize != 7 {
+		t.Errorf("unexpected adts: %+v", h)
+	}
+	if _, err := ParseADTS([]byte{0xFF, 0xF1, 0x4C}); err != ErrShort {
+		t.Errorf("short header: %v", err)
+	}
+}
+
+func TestMPEGAudio(t *testing.T) {
+	// Layer II 192kbps 48kHz stereo
+	h, err := ParseMPEGAudio([]byte{0xFF, 0xFD, 0xA4, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Codec != "mp4a.6B" || h.Layer != 2 || h.Bitrate != 192 || h.SampleRate != 48000 || h.Size != 576 || h.Samples != 1152 {
+		t.Errorf("unexpected mpeg audio: %+v", h)
+	}
+	// Layer III of MPEG-2 128kbps 24kHz mono
+	h, err = ParseMPEGAudio([]byte{0xFF, 0xF3, 0xC4, 0xC0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Codec != "mp4a.40.34" || h.SampleRate != 24000 || h.Channels != 1 || h.Samples != 576 || h.Size != 384 {
+		t.Errorf("unexpected mpeg audio: %+v", h)
+	}
+}
+
+func TestAC3(t *testing.T) {
+	// 384kbps 48kHz 5.1
+	h, err := ParseAC3([]byte{0x0B, 0x77, 0, 0, 0x1C, 0x40, 0xE1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Codec != "ac-3" || h.Size != 1536 || h.Channels != 6 || h.Samples != 1536 || h.SampleRate != 48000 {
+		t.Errorf("unexpected ac-3: %+v", h)
+	}
+
+	// E-AC-3 of 6 blocks stereo
+	h, err = ParseAC3([]byte{0x0B, 0x77, 0x01, 0x7F, 0x34, 0x80, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Codec != "ec-3" || h.Size != 768 || h.Channels != 2 || h.Samples != 1536 || h.SampleRate != 48000 {
+		t.Errorf("unexpected e-ac-3: %+v", h)
+	}
+	// dependent substream
+	if h, _ = ParseAC3([]byte{0x0B, 0x77, 0x41, 0x7F, 0x34, 0x80, 0, 0}); h.StreamType != 1 || h.Samples != 0 {
+		t.Errorf("unexpected dependent substream: %+v", h)
+	}
+}
+
+func TestOpus(t *testing.T) {
+	// CELT 20ms stereo with start trim
+	h, err := ParseOpus([]byte{0x7F, 0xF0, 0x03, 0x00, 0x50, 0x9C, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Codec != "Opus" || h.Size != 8 || h.StartTrim != 0x50 || h.Channels != 2 || h.Samples != 960 {
+		t.Errorf("unexpected opus: %+v", h)
+	}
+	// code 3 packet of 3 frames
+	h, err = ParseOpus([]byte{0x7F, 0xE0, 0x02, 0x9B, 0x03})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Channels != 1 || h.Samples != 3*960 {
+		t.Errorf("unexpected opus: %+v", h)
+	}
+}
+
+func TestParser(t *testing.T) {
+	p := NewParser(CodecAAC)
+	b := append([]byte{0, 1, 2}, adtsFrame(100)...)
+	b = append(b, adtsFrame(80)...)
+	if d := p.Parse(b); d != 2*1024.0/48000 {
+		t.Errorf("duration is %f", d)
+	}
+	if info := p.Info(); info == nil || info.Codec != "mp4a.40.2" || info.Channels != 2 || info.FrameDuration != 1024.0/48000 {
+		t.Errorf("unexpected info: %+v", info)
+	}
+}
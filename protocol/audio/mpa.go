@@ -0,0 +1,95 @@
+package audio
+
+// MPEG audio versions
+const (
+	MPEGVersion25 = 0
+	MPEGVersion2  = 2
+	MPEGVersion1  = 3
+)
+
+var (
+	// kbps by version 1 or 2, layer I to III and bitrate index
+	_mpaBitrates = [2][3][15]int{
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		},
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		},
+	}
+	_mpaSampleRates = [3]int{44100, 48000, 32000}
+)
+
+// MPEGAudio is the header of an MPEG-1/2 audio frame
+type MPEGAudio struct {
+	Frame
+	// MPEGVersion1, MPEGVersion2 or MPEGVersion25
+	Version int
+	// 1 to 3
+	Layer   int
+	Bitrate int // kbps
+}
+
+// ParseMPEGAudio parses the header of the MPEG audio frame at the beginning of b
+func ParseMPEGAudio(b []byte) (*MPEGAudio, error) {
+	if len(b) < 2 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return nil, ErrSync
+	}
+	if len(b) < 4 {
+		return nil, ErrShort
+	}
+	h := new(MPEGAudio)
+	h.Version = int(b[1]>>3) & 0x03
+	h.Layer = 4 - int(b[1]>>1)&0x03
+	br := int(b[2] >> 4)
+	sr := int(b[2]>>2) & 0x03
+	// free format is not supported
+	if h.Version == 1 || h.Layer == 4 || br == 0 || br == 15 || sr == 3 {
+		return nil, ErrSync
+	}
+	v := 0
+	if h.Version != MPEGVersion1 {
+		v = 1
+	}
+	h.Bitrate = _mpaBitrates[v][h.Layer-1][br]
+	h.SampleRate = _mpaSampleRates[sr]
+	switch h.Version {
+	case MPEGVersion2:
+		h.SampleRate /= 2
+	case MPEGVersion25:
+		h.SampleRate /= 4
+	}
+	pad := int(b[2]>>1) & 0x01
+	h.Channels = 2
+	if b[3]>>6 == 3 {
+		h.Channels = 1
+	}
+
+	switch {
+	case h.Layer == 1:
+		h.Samples = 384
+		h.Size = (12*h.Bitrate*1000/h.SampleRate + pad) * 4
+	case h.Layer == 3 && v == 1:
+		h.Samples = 576
+		h.Size = 72*h.Bitrate*1000/h.SampleRate + pad
+	default:
+		h.Samples = 1152
+		h.Size = 144*h.Bitrate*1000/h.SampleRate + pad
+	}
+
+	switch {
+	case h.Layer == 3:
+		h.Codec = "mp4a.40.34"
+	case v == 0:
+		// object type of ISO/IEC 11172-3
+		h.Codec = "mp4a.6B"
+	default:
+		// object type of ISO/IEC 13818-3
+		h.Codec = "mp4a.69"
+	}
+	return h, nil
+}
@@ -0,0 +1,96 @@
+package audio
+
+const (
+	_opusSampleRate = 48000
+)
+
+// Opus is an Opus access unit with its control header
+type Opus struct {
+	Frame
+	// samples to discard at the start and the end of access unit
+	StartTrim  int
+	EndTrim    int
+	HeaderSize int
+	// bytes of Opus packet
+	PacketSize int
+}
+
+// ParseOpus parses the control header and the TOC of the Opus access unit at the beginning of b
+func ParseOpus(b []byte) (*Opus, error) {
+	if len(b) < 2 || b[0] != 0x7F || b[1]&0xE0 != 0xE0 {
+		return nil, ErrSync
+	}
+	h := new(Opus)
+	off := 2
+	for {
+		if off >= len(b) {
+			return nil, ErrShort
+		}
+		v := int(b[off])
+		off++
+		h.PacketSize += v
+		if v != 0xFF {
+			break
+		}
+	}
+	if b[1]&0x10 != 0 {
+		if off+2 > len(b) {
+			return nil, ErrShort
+		}
+		h.StartTrim = int(b[off]&0x1F)<<8 | int(b[off+1])
+		off += 2
+	}
+	if b[1]&0x08 != 0 {
+		if off+2 > len(b) {
+			return nil, ErrShort
+		}
+		h.EndTrim = int(b[off]&0x1F)<<8 | int(b[off+1])
+		off += 2
+	}
+	if b[1]&0x04 != 0 {
+		if off >= len(b) {
+			return nil, ErrShort
+		}
+		off += 1 + int(b[off])
+	}
+	if h.PacketSize == 0 || off >= len(b) {
+		return nil, ErrShort
+	}
+	h.HeaderSize = off
+	h.Size = off + h.PacketSize
+
+	toc := b[off]
+	h.Codec = "Opus"
+	h.SampleRate = _opusSampleRate
+	h.Channels = 1
+	if toc&0x04 != 0 {
+		h.Channels = 2
+	}
+	frames := 1
+	switch toc & 0x03 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if off+1 >= len(b) {
+			return nil, ErrShort
+		}
+		frames = int(b[off+1] & 0x3F)
+	}
+	h.Samples = frames * opusFrameSamples(toc>>3)
+	return h, nil
+}
+
+// opusFrameSamples returns samples at 48kHz of a frame by config of TOC
+func opusFrameSamples(config uint8) int {
+	switch {
+	case config < 12:
+		// SILK of 10, 20, 40 and 60ms
+		return [4]int{480, 960, 1920, 2880}[config&0x03]
+	case config < 16:
+		// hybrid of 10 and 20ms
+		return [2]int{480, 960}[config&0x01]
+	default:
+		// CELT of 2.5, 5, 10 and 20ms
+		return [4]int{120, 240, 480, 960}[config&0x03]
+	}
+}
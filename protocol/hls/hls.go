@@ -2,85 +2,504 @@ package hls
 
 import (
 	"bytes"
-	"container/list"
+	"context"
 	"fmt"
+	"math"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 const (
 	_maxTSCacheNum = 3
+	_vodName       = "vod.m3u8"
+	// parts are listed for the segments in progress and the latest ones
+	_partSegments = 2
+)
+
+var (
+	ErrFarAhead = errors.New("playlist is requested over 2 segments ahead")
 )
 
 type TSItem struct {
 	Name     string
 	SeqNum   uint32
 	Duration float64
+	Size     int
 	Data     []byte
+	// parts of a recent segment in low-latency playlist
+	Parts []Part
+}
+
+// Part is a partial segment of low-latency hls
+type Part struct {
+	Name     string
+	Duration float64
+	// part starts with a keyframe
+	Independent bool
+	Size        int
+}
+
+// PartName returns the name of part of segment seq
+func PartName(seq uint32, part int) string {
+	return fmt.Sprintf("%d.%d.ts", seq, part)
 }
 
+// StreamInfo describes a stream in the EXT-X-STREAM-INF tag of master playlist
+type StreamInfo struct {
+	// RFC 6381 codec strings of elementary streams
+	Codecs    []string
+	Width     int
+	Height    int
+	FrameRate float64
+}
+
+// TSCache lists the segments of a stream in store, the latest ones in the live playlist
+// and those in the dvr window in the dvr playlist
 type TSCache struct {
-	num  int
-	lock sync.RWMutex
-	ll   *list.List
-	lm   map[string]TSItem
+	num int
+	// duration of dvr playlist, zero for none and negative for the whole stream as an EVENT playlist
+	dvr   time.Duration
+	lock  sync.RWMutex
+	store Store
+	// segments in store from the oldest
+	items []TSItem
+	info  StreamInfo
+	// stream is ended, playlists are closed by EXT-X-ENDLIST
+	ended bool
+	// EXT-X-TARGETDURATION, the longest segment so far rounded, it never goes down
+	target int
+
+	// seconds of a part, zero for the playlists without parts
+	partTarget float64
+	// uris of renditions in EXT-X-RENDITION-REPORT of live playlist
+	reports []string
+	// parts of segment nextSeq in progress
+	parts   []Part
+	nextSeq uint32
+	// closed and replaced on every change of playlists to wake up blocking requests
+	changed chan struct{}
 }
 
 func NewTSCache() *TSCache {
+	return NewSegmentCache(NewMemoryStore(), _maxTSCacheNum, 0)
+}
+
+// NewSegmentCache creates a cache of store keeping num segments for live and dvr long for dvr
+func NewSegmentCache(store Store, num int, dvr time.Duration) *TSCache {
+	if num <= 0 {
+		num = _maxTSCacheNum
+	}
 	return &TSCache{
-		ll:  list.New(),
-		num: _maxTSCacheNum,
-		lm:  make(map[string]TSItem),
+		num:     num,
+		dvr:     dvr,
+		store:   store,
+		changed: make(chan struct{}),
 	}
 }
 
+// EnableParts turns the live and dvr playlists into low-latency ones with parts of target seconds,
+// reports are the uris of other renditions in EXT-X-RENDITION-REPORT
+func (tcCacheItem *TSCache) EnableParts(target float64, reports ...string) {
+	tcCacheItem.lock.Lock()
+	defer tcCacheItem.lock.Unlock()
+
+	tcCacheItem.partTarget = target
+	tcCacheItem.reports = reports
+}
+
+// GetPlayList returns the live playlist
 func (tcCacheItem *TSCache) GetPlayList() ([]byte, error) {
+	tcCacheItem.lock.RLock()
+	defer tcCacheItem.lock.RUnlock()
+
+	items := tcCacheItem.items
+	if len(items) > tcCacheItem.num {
+		items = items[len(items)-tcCacheItem.num:]
+	}
+	if tcCacheItem.partTarget > 0 {
+		return tcCacheItem.partPlaylist(items, "", tcCacheItem.reports), nil
+	}
+	return playlist(items, tcCacheItem.target, "", tcCacheItem.ended), nil
+}
+
+// GetDVRPlayList returns the playlist of all segments in the dvr window
+func (tcCacheItem *TSCache) GetDVRPlayList() ([]byte, error) {
+	tcCacheItem.lock.RLock()
+	defer tcCacheItem.lock.RUnlock()
+
+	if tcCacheItem.dvr == 0 {
+		return nil, errors.New("dvr is disabled")
+	}
+	typ := ""
+	if tcCacheItem.dvr < 0 {
+		typ = "EVENT"
+	}
+	if tcCacheItem.partTarget > 0 {
+		return tcCacheItem.partPlaylist(tcCacheItem.items, typ, nil), nil
+	}
+	return playlist(tcCacheItem.items, tcCacheItem.target, typ, tcCacheItem.ended), nil
+}
+
+// GetVODPlayList returns the VOD playlist written by Finish
+func (tcCacheItem *TSCache) GetVODPlayList() ([]byte, error) {
+	return tcCacheItem.store.Get(_vodName)
+}
+
+// Finish ends the stream and writes a VOD playlist of all segments in store
+func (tcCacheItem *TSCache) Finish() error {
+	tcCacheItem.lock.Lock()
+	defer tcCacheItem.lock.Unlock()
+
+	tcCacheItem.ended = true
+	tcCacheItem.notify()
+	return tcCacheItem.store.Put(_vodName, playlist(tcCacheItem.items, tcCacheItem.target, "VOD", true))
+}
+
+// Close removes all segments and playlists from store
+func (tcCacheItem *TSCache) Close() error {
+	tcCacheItem.lock.Lock()
+	defer tcCacheItem.lock.Unlock()
+
+	tcCacheItem.items = nil
+	tcCacheItem.parts = nil
+	// blocking requests return the empty playlist
+	tcCacheItem.ended = true
+	tcCacheItem.notify()
+	return tcCacheItem.store.Close()
+}
+
+// playlist returns a media playlist of items, typ is EXT-X-PLAYLIST-TYPE if it is not empty
+func playlist(items []TSItem, target int, typ string, ended bool) []byte {
 	var seq uint32
-	var getSeq bool
-	var maxDuration float64
 	m3u8body := bytes.NewBuffer(nil)
-	for e := tcCacheItem.ll.Front(); e != nil; e = e.Next() {
-		key := e.Value.(string)
-		v, ok := tcCacheItem.lm[key]
-		if ok {
-			if v.Duration > maxDuration {
-				maxDuration = v.Duration
-			}
-			if !getSeq {
-				getSeq = true
-				seq = v.SeqNum
-			}
-			_, _ = fmt.Fprintf(m3u8body, "#EXTINF:%.3f,\n%s\n", v.Duration, v.Name)
+	for i, v := range items {
+		if i == 0 {
+			seq = v.SeqNum
 		}
+		_, _ = fmt.Fprintf(m3u8body, "#EXTINF:%.3f,\n%s\n", v.Duration, v.Name)
 	}
 	w := bytes.NewBuffer(nil)
+	_, _ = fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:3\n")
+	if typ != "" {
+		_, _ = fmt.Fprintf(w, "#EXT-X-PLAYLIST-TYPE:%s\n", typ)
+	}
 	_, _ = fmt.Fprintf(w,
-		"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n\n",
-		int(maxDuration), seq)
+		"#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n\n",
+		target, seq)
+	w.Write(m3u8body.Bytes())
+	if ended {
+		w.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return w.Bytes()
+}
+
+// partPlaylist returns a low-latency playlist of items with the parts of the latest segments,
+// the parts in progress and a preload hint of the next part
+func (tcCacheItem *TSCache) partPlaylist(items []TSItem, typ string, reports []string) []byte {
+	var seq uint32
+	m3u8body := bytes.NewBuffer(nil)
+	for i, v := range items {
+		if i == 0 {
+			seq = v.SeqNum
+		}
+		writeParts(m3u8body, v.Parts)
+		_, _ = fmt.Fprintf(m3u8body, "#EXTINF:%.3f,\n%s\n", v.Duration, v.Name)
+	}
+	if len(items) == 0 {
+		seq = tcCacheItem.nextSeq
+	}
+	writeParts(m3u8body, tcCacheItem.parts)
+	if !tcCacheItem.ended {
+		_, _ = fmt.Fprintf(m3u8body, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n",
+			PartName(tcCacheItem.nextSeq, len(tcCacheItem.parts)))
+	}
+	// the last part of renditions is that of this one, they are cut by the same segmenter
+	msn, part := tcCacheItem.lastPart()
+	for _, uri := range reports {
+		_, _ = fmt.Fprintf(m3u8body, "#EXT-X-RENDITION-REPORT:URI=\"%s\",LAST-MSN=%d", uri, msn)
+		if part >= 0 {
+			_, _ = fmt.Fprintf(m3u8body, ",LAST-PART=%d", part)
+		}
+		m3u8body.WriteString("\n")
+	}
+
+	w := bytes.NewBuffer(nil)
+	_, _ = fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:6\n")
+	if typ != "" {
+		_, _ = fmt.Fprintf(w, "#EXT-X-PLAYLIST-TYPE:%s\n", typ)
+	}
+	target := tcCacheItem.target
+	if target < 1 {
+		target = 1
+	}
+	_, _ = fmt.Fprintf(w, "#EXT-X-TARGETDURATION:%d\n", target)
+	_, _ = fmt.Fprintf(w, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*tcCacheItem.partTarget)
+	_, _ = fmt.Fprintf(w, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", tcCacheItem.partTarget)
+	_, _ = fmt.Fprintf(w, "#EXT-X-MEDIA-SEQUENCE:%d\n\n", seq)
 	w.Write(m3u8body.Bytes())
+	if tcCacheItem.ended {
+		w.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return w.Bytes()
+}
+
+func writeParts(w *bytes.Buffer, parts []Part) {
+	for _, p := range parts {
+		_, _ = fmt.Fprintf(w, "#EXT-X-PART:DURATION=%.3f,URI=\"%s\"", p.Duration, p.Name)
+		if p.Independent {
+			w.WriteString(",INDEPENDENT=YES")
+		}
+		w.WriteString("\n")
+	}
+}
+
+// lastPart returns the media sequence and the index of the last part in playlist
+func (tcCacheItem *TSCache) lastPart() (uint32, int) {
+	if len(tcCacheItem.parts) > 0 || len(tcCacheItem.items) == 0 {
+		return tcCacheItem.nextSeq, len(tcCacheItem.parts) - 1
+	}
+	last := tcCacheItem.items[len(tcCacheItem.items)-1]
+	return last.SeqNum, len(last.Parts) - 1
+}
+
+// SetInfo updates the stream info in master playlist
+func (tcCacheItem *TSCache) SetInfo(info StreamInfo) {
+	tcCacheItem.lock.Lock()
+	defer tcCacheItem.lock.Unlock()
+
+	tcCacheItem.info = info
+}
+
+// GetMasterPlayList returns the master playlist of media playlist uri,
+// BANDWIDTH is the peak bit rate of segments in live playlist
+func (tcCacheItem *TSCache) GetMasterPlayList(uri string) ([]byte, error) {
+	var bandwidth float64
+	tcCacheItem.lock.RLock()
+	items := tcCacheItem.items
+	if len(items) > tcCacheItem.num {
+		items = items[len(items)-tcCacheItem.num:]
+	}
+	for _, v := range items {
+		if v.Duration <= 0 {
+			continue
+		}
+		if rate := float64(v.Size*8) / v.Duration; rate > bandwidth {
+			bandwidth = rate
+		}
+	}
+	info := tcCacheItem.info
+	tcCacheItem.lock.RUnlock()
+
+	w := bytes.NewBuffer(nil)
+	_, _ = fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-STREAM-INF:BANDWIDTH=%d", int(bandwidth))
+	if info.Width > 0 && info.Height > 0 {
+		_, _ = fmt.Fprintf(w, ",RESOLUTION=%dx%d", info.Width, info.Height)
+	}
+	if info.FrameRate > 0 {
+		_, _ = fmt.Fprintf(w, ",FRAME-RATE=%.3f", info.FrameRate)
+	}
+	if len(info.Codecs) > 0 {
+		_, _ = fmt.Fprintf(w, ",CODECS=\"%s\"", strings.Join(info.Codecs, ","))
+	}
+	_, _ = fmt.Fprintf(w, "\n%s\n", uri)
 	return w.Bytes(), nil
 }
 
-func (tcCacheItem *TSCache) SetItem(key string, seq uint32, duration float64, d []byte) {
+// SetPart puts part of segment seq in progress into store
+func (tcCacheItem *TSCache) SetPart(seq uint32, part int, duration float64, independent bool, d []byte) error {
+	name := PartName(seq, part)
+	if err := tcCacheItem.store.Put(name, d); err != nil {
+		return err
+	}
+	tcCacheItem.lock.Lock()
+	defer tcCacheItem.lock.Unlock()
+
+	if seq != tcCacheItem.nextSeq {
+		tcCacheItem.deleteParts(tcCacheItem.parts)
+		tcCacheItem.parts = nil
+		tcCacheItem.nextSeq = seq
+	}
+	tcCacheItem.parts = append(tcCacheItem.parts, Part{
+		Name:        name,
+		Duration:    duration,
+		Independent: independent,
+		Size:        len(d),
+	})
+	tcCacheItem.notify()
+	return nil
+}
+
+// SetItem puts a segment into store, segments out of both live and dvr windows are removed
+// and so are the parts of segments no longer recent
+func (tcCacheItem *TSCache) SetItem(key string, seq uint32, duration float64, d []byte) error {
+	if err := tcCacheItem.store.Put(key, d); err != nil {
+		return err
+	}
 	item := TSItem{
 		Name:     key,
 		SeqNum:   seq,
 		Duration: duration,
-		Data:     d,
+		Size:     len(d),
+	}
+	tcCacheItem.lock.Lock()
+	defer tcCacheItem.lock.Unlock()
+
+	// the parts in progress make up this segment
+	if seq == tcCacheItem.nextSeq {
+		item.Parts = tcCacheItem.parts
+	} else {
+		tcCacheItem.deleteParts(tcCacheItem.parts)
+	}
+	tcCacheItem.parts = nil
+	tcCacheItem.nextSeq = seq + 1
+	if target := int(math.Round(duration)); target > tcCacheItem.target {
+		tcCacheItem.target = target
+	}
+
+	tcCacheItem.items = append(tcCacheItem.items, item)
+	n := tcCacheItem.expired()
+	var err error
+	for i, v := range tcCacheItem.items {
+		if i >= n && i >= len(tcCacheItem.items)-_partSegments {
+			break
+		}
+		if e := tcCacheItem.deleteParts(v.Parts); e != nil {
+			err = e
+		}
+		tcCacheItem.items[i].Parts = nil
+		if i >= n {
+			continue
+		}
+		if e := tcCacheItem.store.Delete(v.Name); e != nil {
+			err = e
+		}
+	}
+	tcCacheItem.items = append(tcCacheItem.items[:0], tcCacheItem.items[n:]...)
+	tcCacheItem.notify()
+	return err
+}
+
+func (tcCacheItem *TSCache) deleteParts(parts []Part) error {
+	var err error
+	for _, p := range parts {
+		if e := tcCacheItem.store.Delete(p.Name); e != nil {
+			err = e
+		}
 	}
-	if tcCacheItem.ll.Len() == tcCacheItem.num {
-		e := tcCacheItem.ll.Front()
-		tcCacheItem.ll.Remove(e)
-		k := e.Value.(string)
-		delete(tcCacheItem.lm, k)
+	return err
+}
+
+// notify wakes up the requests blocking for a change, it is called in lock
+func (tcCacheItem *TSCache) notify() {
+	close(tcCacheItem.changed)
+	tcCacheItem.changed = make(chan struct{})
+}
+
+// Wait blocks until the playlist has part of segment msn, or segment msn itself if part is negative,
+// a request of a playlist without parts or of an ended stream returns at once
+func (tcCacheItem *TSCache) Wait(ctx context.Context, msn uint32, part int) error {
+	for {
+		tcCacheItem.lock.RLock()
+		if tcCacheItem.partTarget <= 0 || tcCacheItem.ended {
+			tcCacheItem.lock.RUnlock()
+			return nil
+		}
+		ready := tcCacheItem.ready(msn, part)
+		last, _ := tcCacheItem.lastPart()
+		changed := tcCacheItem.changed
+		tcCacheItem.lock.RUnlock()
+
+		if ready {
+			return nil
+		}
+		if msn > last+2 {
+			return ErrFarAhead
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ready tells the playlist has part of segment msn, the part after the last one of a segment
+// is the first one of the next segment
+func (tcCacheItem *TSCache) ready(msn uint32, part int) bool {
+	if n := len(tcCacheItem.items); n > 0 && tcCacheItem.items[n-1].SeqNum >= msn {
+		last := tcCacheItem.items[n-1]
+		if part < 0 || last.SeqNum > msn || part < len(last.Parts) {
+			return true
+		}
+		return tcCacheItem.nextSeq == msn+1 && len(tcCacheItem.parts) > 0
+	}
+	return part >= 0 && tcCacheItem.nextSeq == msn && part < len(tcCacheItem.parts)
+}
+
+// expired returns the number of the oldest items out of both windows
+func (tcCacheItem *TSCache) expired() int {
+	n := len(tcCacheItem.items) - tcCacheItem.num
+	if n <= 0 || tcCacheItem.dvr < 0 {
+		return 0
 	}
-	tcCacheItem.lm[key] = item
-	tcCacheItem.ll.PushBack(key)
+	if tcCacheItem.dvr == 0 {
+		return n
+	}
+	// the newest items lasting up to dvr are kept
+	var total float64
+	for i := len(tcCacheItem.items) - 1; i >= 0; i-- {
+		total += tcCacheItem.items[i].Duration
+		if total > tcCacheItem.dvr.Seconds() {
+			if i+1 < n {
+				return i + 1
+			}
+			return n
+		}
+	}
+	return 0
 }
 
 func (tcCacheItem *TSCache) GetItem(key string) (TSItem, error) {
-	item, ok := tcCacheItem.lm[key]
-	if !ok {
+	tcCacheItem.lock.RLock()
+	item, found := tcCacheItem.find(key)
+	tcCacheItem.lock.RUnlock()
+	if !found {
 		return item, fmt.Errorf("No key for cache")
 	}
+
+	d, err := tcCacheItem.store.Get(key)
+	if err != nil {
+		return item, err
+	}
+	item.Data = d
 	return item, nil
 }
+
+// find returns the segment or part of key, a part is returned as an item of its segment seq
+func (tcCacheItem *TSCache) find(key string) (TSItem, bool) {
+	for _, v := range tcCacheItem.items {
+		if v.Name == key {
+			v.Parts = nil
+			return v, true
+		}
+		if p, ok := findPart(v.Parts, key); ok {
+			return TSItem{Name: p.Name, SeqNum: v.SeqNum, Duration: p.Duration, Size: p.Size}, true
+		}
+	}
+	if p, ok := findPart(tcCacheItem.parts, key); ok {
+		return TSItem{Name: p.Name, SeqNum: tcCacheItem.nextSeq, Duration: p.Duration, Size: p.Size}, true
+	}
+	return TSItem{}, false
+}
+
+func findPart(parts []Part, key string) (Part, bool) {
+	for _, p := range parts {
+		if p.Name == key {
+			return p, true
+		}
+	}
+	return Part{}, false
+}
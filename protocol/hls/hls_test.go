@@ -1,9 +1,14 @@
 package hls
 
 import (
+	"context"
+	"fmt"
+	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestPath(t *testing.T) {
@@ -16,3 +21,190 @@ func TestPath(t *testing.T) {
 		t.Log(paths[i])
 	}
 }
+
+func TestMasterPlayList(t *testing.T) {
+	c := NewTSCache()
+	c.SetItem("0.ts", 0, 2, make([]byte, 1000))
+	c.SetItem("1.ts", 1, 2, make([]byte, 3000))
+	c.SetInfo(StreamInfo{Codecs: []string{"avc1.640028", "mp4a.40.2"}, Width: 1920, Height: 1080, FrameRate: 30})
+	b, err := c.GetMasterPlayList("index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "#EXTM3U\n#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=12000,RESOLUTION=1920x1080,FRAME-RATE=30.000,CODECS=\"avc1.640028,mp4a.40.2\"\n" +
+		"index.m3u8\n"
+	if string(b) != expected {
+		t.Errorf("unexpected master playlist:\n%s", b)
+	}
+}
+
+func TestSegmentCache(t *testing.T) {
+	store := NewMemoryStore()
+	// live window of 2 segments, dvr window of 6s
+	c := NewSegmentCache(store, 2, 6*time.Second)
+	for i := uint32(0); i < 5; i++ {
+		if err := c.SetItem(fmt.Sprintf("%d.ts", i), i, 2, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	live, _ := c.GetPlayList()
+	if !strings.Contains(string(live), "#EXT-X-MEDIA-SEQUENCE:3\n") || strings.Count(string(live), "#EXTINF") != 2 {
+		t.Errorf("unexpected live playlist:\n%s", live)
+	}
+	dvr, _ := c.GetDVRPlayList()
+	if !strings.Contains(string(dvr), "#EXT-X-MEDIA-SEQUENCE:2\n") || strings.Count(string(dvr), "#EXTINF") != 3 ||
+		strings.Contains(string(dvr), "PLAYLIST-TYPE") {
+		t.Errorf("unexpected dvr playlist:\n%s", dvr)
+	}
+	// segments out of both windows are removed from store
+	if _, err := store.Get("1.ts"); err != ErrNotFound {
+		t.Error("segment out of windows should be removed")
+	}
+	if item, err := c.GetItem("2.ts"); err != nil || item.Data[0] != 2 {
+		t.Errorf("segment in dvr window: %v", err)
+	}
+
+	if _, err := c.GetVODPlayList(); err != ErrNotFound {
+		t.Error("vod playlist before the end")
+	}
+	if err := c.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	vod, _ := c.GetVODPlayList()
+	if !strings.Contains(string(vod), "#EXT-X-PLAYLIST-TYPE:VOD\n") || !strings.HasSuffix(string(vod), "#EXT-X-ENDLIST\n") ||
+		strings.Count(string(vod), "#EXTINF") != 3 {
+		t.Errorf("unexpected vod playlist:\n%s", vod)
+	}
+	if live, _ = c.GetPlayList(); !strings.HasSuffix(string(live), "#EXT-X-ENDLIST\n") {
+		t.Error("live playlist should be ended")
+	}
+	_ = c.Close()
+	if _, err := c.GetItem("4.ts"); err == nil {
+		t.Error("segments should be removed on close")
+	}
+
+	// the whole stream as an event
+	c = NewSegmentCache(NewMemoryStore(), 2, -1)
+	for i := uint32(0); i < 5; i++ {
+		_ = c.SetItem(fmt.Sprintf("%d.ts", i), i, 2, []byte{byte(i)})
+	}
+	if dvr, _ = c.GetDVRPlayList(); !strings.Contains(string(dvr), "#EXT-X-PLAYLIST-TYPE:EVENT\n") ||
+		strings.Count(string(dvr), "#EXTINF") != 5 {
+		t.Errorf("unexpected event playlist:\n%s", dvr)
+	}
+}
+
+func TestPartPlayList(t *testing.T) {
+	store := NewMemoryStore()
+	c := NewSegmentCache(store, 3, 0)
+	c.EnableParts(0.5, "dvr.m3u8")
+	_ = c.SetPart(0, 0, 0.5, true, []byte{0})
+	live, _ := c.GetPlayList()
+	for _, tag := range []string{"#EXT-X-VERSION:6\n", "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=1.500\n",
+		"#EXT-X-PART-INF:PART-TARGET=0.500\n", "#EXT-X-PART:DURATION=0.500,URI=\"0.0.ts\",INDEPENDENT=YES\n",
+		"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"0.1.ts\"\n", "#EXT-X-RENDITION-REPORT:URI=\"dvr.m3u8\",LAST-MSN=0,LAST-PART=0\n"} {
+		if !strings.Contains(string(live), tag) {
+			t.Errorf("%q is absent in playlist:\n%s", tag, live)
+		}
+	}
+
+	// blocking reload of the hinted part
+	done := make(chan error)
+	go func() {
+		done <- c.Wait(context.Background(), 0, 1)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("wait should block, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	_ = c.SetPart(0, 1, 0.4, false, []byte{1})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Wait(context.Background(), 3, 0); err != ErrFarAhead {
+		t.Errorf("request over 2 segments ahead: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Wait(ctx, 1, -1); err != context.DeadlineExceeded {
+		t.Errorf("segment in progress: %v", err)
+	}
+
+	// parts in progress move to the segment, those of old segments are removed
+	_ = c.SetItem("0.ts", 0, 0.9, []byte{0, 1})
+	if item, err := c.GetItem("0.1.ts"); err != nil || item.SeqNum != 0 || item.Data[0] != 1 {
+		t.Errorf("part of segment: %+v %v", item, err)
+	}
+	// the part after the last one of a segment is the first one of the next segment
+	go func() {
+		done <- c.Wait(context.Background(), 0, 2)
+	}()
+	_ = c.SetPart(1, 0, 0.5, true, []byte{2})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(1); i <= 3; i++ {
+		_ = c.SetItem(fmt.Sprintf("%d.ts", i), i, 0.9, []byte{byte(i)})
+	}
+	for _, name := range []string{"0.0.ts", "1.0.ts"} {
+		if _, err := store.Get(name); err != ErrNotFound {
+			t.Errorf("part %s of old segment should be removed", name)
+		}
+	}
+	live, _ = c.GetPlayList()
+	if strings.Contains(string(live), "#EXT-X-PART:") || !strings.Contains(string(live), "URI=\"4.0.ts\"") ||
+		!strings.Contains(string(live), "LAST-MSN=3\n") {
+		t.Errorf("unexpected playlist:\n%s", live)
+	}
+
+	// an ended stream does not block
+	_ = c.Finish()
+	if err := c.Wait(context.Background(), 4, 0); err != nil {
+		t.Error(err)
+	}
+	if live, _ = c.GetPlayList(); strings.Contains(string(live), "PRELOAD-HINT") {
+		t.Errorf("ended playlist should have no hint:\n%s", live)
+	}
+}
+
+func TestTargetDuration(t *testing.T) {
+	c := NewSegmentCache(NewMemoryStore(), 2, 0)
+	_ = c.SetItem("0.ts", 0, 4.6, []byte{0})
+	// target duration stays after the longest segment leaves window
+	for i := uint32(1); i < 4; i++ {
+		_ = c.SetItem(fmt.Sprintf("%d.ts", i), i, 2, []byte{byte(i)})
+		if live, _ := c.GetPlayList(); !strings.Contains(string(live), "#EXT-X-TARGETDURATION:5\n") {
+			t.Fatalf("target duration goes down:\n%s", live)
+		}
+	}
+}
+
+func TestDiskStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "live", "test")
+	s, err := NewDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("0.ts", []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := s.Get("0.ts"); err != nil || len(b) != 3 {
+		t.Errorf("get %v %v", b, err)
+	}
+	if err := s.Put("../0.ts", nil); err == nil {
+		t.Error("item out of dir should be refused")
+	}
+	_ = s.Delete("0.ts")
+	if _, err := s.Get("0.ts"); err != ErrNotFound {
+		t.Errorf("deleted item: %v", err)
+	}
+	_ = s.Put("1.ts", []byte{1})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("dir should be removed on close")
+	}
+}
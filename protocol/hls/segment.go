@@ -0,0 +1,352 @@
+package hls
+
+import (
+	"bytes"
+
+	"github.com/beleege/gosrt/protocol/audio"
+	"github.com/beleege/gosrt/protocol/mpegts"
+	"github.com/beleege/gosrt/protocol/video"
+)
+
+// SaveFunc receives a segment of seq no lasting duration seconds
+type SaveFunc func(seq uint32, duration float64, b []byte)
+
+// PartFunc receives partial segment part of segment seq, independent tells it starts with a keyframe.
+// Parts of a segment joined are the segment, b must not be modified
+type PartFunc func(seq uint32, part int, duration float64, independent bool, b []byte)
+
+// Segmenter cuts a ts stream into segments decodable on their own, a segment starts with PAT and PMT
+// followed by a video keyframe, it is cut at the first keyframe after the target duration.
+// A stream without video is cut at the start of an audio PES, by the duration of audio frames
+// or by PCR if its frames are unknown.
+// Partial segments are cut at the start of a PES too, they last no longer than the part target if possible.
+type Segmenter struct {
+	target float64
+	save   SaveFunc
+	probe  *mpegts.Probe
+	clock  *mpegts.Clock
+	demux  *mpegts.Demuxer
+	// parsers of elementary streams by pid
+	videos map[uint16]*videoStream
+	audios map[uint16]*audioStream
+	// duration of the first audio in segment in progress, it times a stream without video
+	audioDur float64
+	buf      *bytes.Buffer
+	// the first segment is started
+	started bool
+	// pcr in seconds at the start of segment and the last one
+	first float64
+	last  float64
+	// offset in buf and pcr at the start of the video PES in progress
+	pesOff int
+	pesPCR float64
+	// seq no of the segment in progress
+	seq uint32
+
+	partTarget float64
+	onPart     PartFunc
+	// part in progress: its no, offset in buf, start in segment and if it starts with a keyframe
+	partNo  int
+	partOff int
+	partAt  float64
+	partKey bool
+	// start of the last PES in segment and the interval to the previous one, a part is cut before it overruns
+	lastAt float64
+	frame  float64
+}
+
+func NewSegmenter(target float64, save SaveFunc) *Segmenter {
+	g := new(Segmenter)
+	g.target = target
+	g.save = save
+	g.probe = mpegts.NewProbe()
+	g.clock = mpegts.NewPCRClock()
+	g.demux = mpegts.NewDemuxer(g.onPES)
+	g.videos = make(map[uint16]*videoStream)
+	g.audios = make(map[uint16]*audioStream)
+	g.buf = bytes.NewBuffer(nil)
+	g.first, g.last = -1, -1
+	return g
+}
+
+// OnPart cuts segments into partial segments of target seconds for f
+func (g *Segmenter) OnPart(target float64, f PartFunc) {
+	g.partTarget = target
+	g.onPart = f
+}
+
+// ProgramMap returns the PSI found in stream
+func (g *Segmenter) ProgramMap() *mpegts.ProgramMap {
+	return g.probe.Map
+}
+
+// Info returns the stream info found so far, codecs are of the video and every audio in PMT
+func (g *Segmenter) Info() StreamInfo {
+	var info StreamInfo
+	pid, _ := g.probe.Video()
+	if vi := g.Video(pid); vi != nil {
+		info.Codecs = append(info.Codecs, vi.Codec)
+		info.Width, info.Height = vi.Width, vi.Height
+		info.FrameRate = vi.FrameRate
+	}
+	pmt := g.probe.Map.Program()
+	if pmt == nil {
+		return info
+	}
+	for _, es := range pmt.Streams {
+		ai := g.Audio(es.PID)
+		if ai == nil || contains(info.Codecs, ai.Codec) {
+			continue
+		}
+		info.Codecs = append(info.Codecs, ai.Codec)
+	}
+	return info
+}
+
+// Video returns the info of video stream pid, nil until its SPS is found
+func (g *Segmenter) Video(pid uint16) *video.Info {
+	if v := g.videos[pid]; v != nil {
+		return v.parser.Info()
+	}
+	return nil
+}
+
+// Audio returns the info of audio stream pid, nil until its frame is found
+func (g *Segmenter) Audio(pid uint16) *audio.Info {
+	if a := g.audios[pid]; a != nil {
+		return a.parser.Info()
+	}
+	return nil
+}
+
+// Write puts a ts packet of 188 bytes
+func (g *Segmenter) Write(b []byte) {
+	_ = g.demux.Write(b)
+	start, key := g.probe.Inspect(b)
+	pcr, hasPCR := g.pcr(b)
+	if hasPCR {
+		g.last = pcr
+		if g.first < 0 {
+			g.first = pcr
+		}
+	}
+	if !g.probe.Ready() {
+		// nothing is decodable before PMT
+		return
+	}
+	if pid, _ := g.probe.Video(); pid == 0 {
+		g.writeAudio(b, pcr, hasPCR)
+		return
+	}
+
+	if start {
+		if !g.started {
+			// drop the data before the first keyframe
+			g.buf.Reset()
+		} else {
+			g.part(g.buf.Len(), g.last-g.first, false)
+		}
+		g.pesOff = g.buf.Len()
+		g.pesPCR = g.last
+	}
+	g.buf.Write(b)
+	if !key {
+		return
+	}
+	if !g.started {
+		g.started = true
+		g.restart(g.pesOff)
+		g.first = g.pesPCR
+		g.partKey = true
+		return
+	}
+	if g.pesPCR-g.first >= g.target {
+		g.part(g.pesOff, g.pesPCR-g.first, true)
+		g.cut(g.pesOff, g.pesPCR-g.first)
+		g.first = g.pesPCR
+		g.partKey = true
+	} else if g.pesOff == g.partOff {
+		g.partKey = true
+	}
+}
+
+// Flush saves the segment in progress
+func (g *Segmenter) Flush() {
+	g.demux.Flush()
+	if g.started && g.buf.Len() > 0 && (g.first >= 0 || g.audioDur > 0) {
+		g.part(g.buf.Len(), g.duration(), true)
+		g.save(g.seq, g.duration(), g.buf.Bytes())
+		g.seq++
+	}
+	g.buf = bytes.NewBuffer(nil)
+	g.started = false
+	g.audioDur = 0
+	g.resetPart()
+}
+
+// onPES parses the access units of video and audio streams
+func (g *Segmenter) onPES(p *mpegts.PES) {
+	if es := g.demux.Map.Stream(p.PID); es != nil && es.IsAudio() {
+		g.onAudio(p, es)
+		return
+	}
+	v := g.videos[p.PID]
+	if v == nil || v.typ != p.Type {
+		switch p.Type {
+		case mpegts.StreamTypeH264:
+			v = &videoStream{typ: p.Type, parser: video.NewH264Parser()}
+		case mpegts.StreamTypeH265:
+			v = &videoStream{typ: p.Type, parser: video.NewH265Parser()}
+		default:
+			return
+		}
+		g.videos[p.PID] = v
+	}
+	v.parser.Parse(p.Data)
+}
+
+func (g *Segmenter) onAudio(p *mpegts.PES, es *mpegts.ElementaryStream) {
+	c := audioCodec(es)
+	a := g.audios[p.PID]
+	if a == nil || a.codec != c {
+		if c == audio.CodecUnknown {
+			return
+		}
+		a = &audioStream{codec: c, parser: audio.NewParser(c)}
+		g.audios[p.PID] = a
+	}
+	d := a.parser.Parse(p.Data)
+	if pid, _ := g.probe.Audio(); pid == p.PID {
+		g.audioDur += d
+	}
+}
+
+// audioCodec returns the codec of audio stream es by its type and descriptors
+func audioCodec(es *mpegts.ElementaryStream) audio.Codec {
+	switch es.Type {
+	case mpegts.StreamTypeAAC:
+		return audio.CodecAAC
+	case mpegts.StreamTypeMPEG1Audio, mpegts.StreamTypeMPEG2Audio:
+		return audio.CodecMPEG
+	case mpegts.StreamTypeAC3, mpegts.StreamTypeEAC3:
+		return audio.CodecAC3
+	case mpegts.StreamTypePrivate:
+		if es.Registration() == "Opus" {
+			return audio.CodecOpus
+		}
+		if es.Descriptor(mpegts.DescriptorAC3) != nil || es.Descriptor(mpegts.DescriptorEAC3) != nil {
+			return audio.CodecAC3
+		}
+	}
+	return audio.CodecUnknown
+}
+
+// pcr returns the PCR of b in seconds on the unwrapped timeline
+func (g *Segmenter) pcr(b []byte) (float64, bool) {
+	pcr, ok := mpegts.ParsePCR(b)
+	if !ok {
+		return 0, false
+	}
+	if mpegts.Discontinuity(b) {
+		g.clock.Discontinue()
+	}
+	return float64(g.clock.Unwrap(pcr)) / mpegts.PCRClock, true
+}
+
+// writeAudio cuts a stream without video before an audio PES, or at any PCR if there is no audio
+func (g *Segmenter) writeAudio(b []byte, pcr float64, hasPCR bool) {
+	if !g.started {
+		g.started = true
+		g.buf.Reset()
+		g.buf.Write(g.probe.PSI())
+		g.resetPart()
+	}
+	cut := hasPCR
+	if pid, _ := g.probe.Audio(); pid != 0 {
+		cut = mpegts.PID(b) == pid && b[1]&0x40 != 0
+	}
+	// frames of the last PES are parsed by demuxer at the start of this one
+	if d := g.duration(); cut && d >= g.target && g.buf.Len() > 0 {
+		g.part(g.buf.Len(), d, true)
+		g.cut(g.buf.Len(), d)
+		g.first = g.last
+		g.audioDur = 0
+	} else if cut {
+		g.part(g.buf.Len(), d, false)
+	}
+	g.buf.Write(b)
+}
+
+// part emits buf from the part in progress to off as a part once the next PES would overrun the part target,
+// force emits it anyway as the last part of segment, at is the start of the PES at off in segment
+func (g *Segmenter) part(off int, at float64, force bool) {
+	if g.onPart == nil || off <= g.partOff {
+		return
+	}
+	if !force {
+		g.frame, g.lastAt = at-g.lastAt, at
+		if at-g.partAt+g.frame <= g.partTarget {
+			return
+		}
+	}
+	// every audio frame is a random access point
+	pid, _ := g.probe.Video()
+	g.onPart(g.seq, g.partNo, at-g.partAt, g.partKey || pid == 0, g.buf.Bytes()[g.partOff:off])
+	g.partNo++
+	g.partOff = off
+	g.partAt = at
+	g.partKey = false
+}
+
+// resetPart starts the first part of a new segment
+func (g *Segmenter) resetPart() {
+	g.partNo, g.partOff = 0, 0
+	g.partAt, g.lastAt, g.frame = 0, 0, 0
+	g.partKey = false
+}
+
+// duration returns the duration of segment in progress, by audio frames if they are known for a stream without video
+func (g *Segmenter) duration() float64 {
+	if pid, _ := g.probe.Video(); pid == 0 && g.audioDur > 0 {
+		return g.audioDur
+	}
+	return g.last - g.first
+}
+
+// cut saves buf before off as a segment, the rest starts the next one
+func (g *Segmenter) cut(off int, duration float64) {
+	g.save(g.seq, duration, g.buf.Bytes()[:off])
+	g.seq++
+	g.restart(off)
+}
+
+// restart begins a new buf of PSI and the data of buf from off, the next segment is likely as large as this one
+func (g *Segmenter) restart(off int) {
+	psi := g.probe.PSI()
+	rest := g.buf.Bytes()[off:]
+	b := bytes.NewBuffer(make([]byte, 0, g.buf.Len()+len(psi)))
+	b.Write(psi)
+	b.Write(rest)
+	g.buf = b
+	g.pesOff = len(psi)
+	g.resetPart()
+}
+
+type videoStream struct {
+	typ    uint8
+	parser *video.Parser
+}
+
+type audioStream struct {
+	codec  audio.Codec
+	parser *audio.Parser
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package hls
+
+import (
+	"testing"
+
+	"github.com/beleege/gosrt/protocol/mpegts"
+)
+
+const (
+	_pmtPID   = 0x1000
+	_videoPID = 0x0100
+	_audioPID = 0x0101
+)
+
+// tsPacket builds a ts packet stuffed by adaptation field, pcr is in seconds and negative for none
+func tsPacket(pid uint16, pusi bool, pcr int64, payload []byte) []byte {
+	b := make([]byte, mpegts.TSPackageSize)
+	b[0] = 0x47
+	b[1] = byte(pid>>8) & 0x1F
+	if pusi {
+		b[1] |= 0x40
+	}
+	b[2] = byte(pid)
+	b[3] = 0x30
+	af := mpegts.TSPackageSize - 5 - len(payload)
+	b[4] = byte(af)
+	if af > 0 {
+		for i := 5; i < 5+af; i++ {
+			b[i] = 0xFF
+		}
+		b[5] = 0
+		if pcr >= 0 {
+			base := pcr * 90000
+			b[5] = 0x10
+			b[6], b[7], b[8], b[9] = byte(base>>25), byte(base>>17), byte(base>>9), byte(base>>1)
+			b[10], b[11] = byte(base&1)<<7|0x7E, 0
+		}
+	}
+	copy(b[5+af:], payload)
+	return b
+}
+
+func psiPacket(pid uint16, sec []byte) []byte {
+	crc := mpegts.CRC32(sec)
+	// pointer field, section and CRC
+	payload := append([]byte{0}, sec...)
+	return tsPacket(pid, true, -1, append(payload, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc)))
+}
+
+func pat() []byte {
+	return psiPacket(mpegts.PIDPAT, []byte{0x00, 0xB0, 13, 0, 1, 0xC1, 0, 0, 0, 1, 0xE0 | _pmtPID>>8, _pmtPID & 0xFF})
+}
+
+func pmt() []byte {
+	return psiPacket(_pmtPID, []byte{0x02, 0xB0, 23, 0, 1, 0xC1, 0, 0, 0xE1, 0x00, 0xF0, 0,
+		mpegts.StreamTypeH264, 0xE1, 0x00, 0xF0, 0,
+		0x0F, 0xE1, 0x01, 0xF0, 0})
+}
+
+func pes(nal ...byte) []byte {
+	return append([]byte{0, 0, 1, 0xE0, 0, 0, 0x80, 0, 0, 0, 0, 0, 1, 9, 0xF0}, nal...)
+}
+
+func TestSegmenter(t *testing.T) {
+	type segment struct {
+		seq      uint32
+		duration float64
+		b        []byte
+	}
+	var segments []segment
+	g := NewSegmenter(5, func(seq uint32, duration float64, b []byte) {
+		segments = append(segments, segment{seq, duration, b})
+	})
+
+	g.Write(pat())
+	g.Write(pmt())
+	for sec := int64(0); sec <= 12; sec++ {
+		switch sec {
+		case 1, 4, 10:
+			g.Write(tsPacket(_videoPID, true, sec, pes(0, 0, 0, 1, 0x65)))
+		case 7:
+			// IDR follows SPS in the next packet
+			g.Write(tsPacket(_videoPID, true, sec, pes(0, 0, 0, 1, 0x67, 0, 0)))
+			g.Write(tsPacket(_videoPID, false, -1, []byte{0, 1, 0x65}))
+		default:
+			g.Write(tsPacket(_videoPID, true, sec, pes(0, 0, 0, 1, 0x41)))
+		}
+		g.Write(tsPacket(_audioPID, true, -1, []byte{0, 0, 1, 0xC0}))
+	}
+	g.Flush()
+
+	expected := []segment{{seq: 0, duration: 6}, {seq: 1, duration: 5}}
+	if len(segments) != len(expected) {
+		t.Fatalf("%d segments, expected %d", len(segments), len(expected))
+	}
+	for i, seg := range segments {
+		if seg.seq != expected[i].seq || seg.duration != expected[i].duration {
+			t.Errorf("segment %d: seq %d duration %.3f", i, seg.seq, seg.duration)
+		}
+		b := seg.b
+		if len(b)%mpegts.TSPackageSize != 0 || len(b) < 3*mpegts.TSPackageSize {
+			t.Fatalf("segment %d: %d bytes", i, len(b))
+		}
+		// PAT, PMT then the video PES of keyframe
+		if mpegts.PID(b) != mpegts.PIDPAT || mpegts.PID(b[188:]) != _pmtPID || mpegts.PID(b[376:]) != _videoPID || b[377]&0x40 == 0 {
+			t.Errorf("segment %d does not start with PAT, PMT and video", i)
+		}
+	}
+	// the first segment begins at the keyframe of second 1
+	if mpegts.PID(segments[0].b[564:]) != _audioPID {
+		t.Error("data before the first keyframe should be dropped")
+	}
+}
+
+func TestSegmenterAudio(t *testing.T) {
+	var durations []float64
+	g := NewSegmenter(5, func(seq uint32, duration float64, b []byte) {
+		durations = append(durations, duration)
+	})
+	g.Write(pat())
+	g.Write(psiPacket(_pmtPID, []byte{0x02, 0xB0, 18, 0, 1, 0xC1, 0, 0, 0xE1, 0x01, 0xF0, 0,
+		0x0F, 0xE1, 0x01, 0xF0, 0}))
+	for sec := int64(0); sec <= 12; sec++ {
+		g.Write(tsPacket(_audioPID, true, sec, []byte{0, 0, 1, 0xC0}))
+	}
+	g.Flush()
+	if len(durations) != 3 || durations[0] != 5 || durations[1] != 5 || durations[2] != 2 {
+		t.Errorf("unexpected durations %v", durations)
+	}
+}
+
+func TestSegmenterInfo(t *testing.T) {
+	g := NewSegmenter(5, func(seq uint32, duration float64, b []byte) {})
+	g.Write(pat())
+	g.Write(pmt())
+	// SPS of High@4.0 1920x1080 30fps and IDR slice
+	sps := []byte{0, 0, 0, 1, 0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0xc0, 0x44, 0x00, 0x00,
+		0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60, 0xc6, 0x58}
+	g.Write(tsPacket(_videoPID, true, 0, pes(append(sps, 0, 0, 0, 1, 0x65, 0x88, 0x80)...)))
+	if info := g.Info(); len(info.Codecs) != 0 {
+		t.Errorf("info is found before PES is complete: %+v", info)
+	}
+	b := tsPacket(_videoPID, true, 1, pes(0, 0, 0, 1, 0x41, 0x9A))
+	// continuity counter goes on, or it is taken as a duplicate
+	b[3] |= 1
+	g.Write(b)
+	info := g.Info()
+	if len(info.Codecs) != 1 || info.Codecs[0] != "avc1.640028" || info.Width != 1920 || info.Height != 1080 || info.FrameRate != 30 {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if v := g.Video(_videoPID); v == nil || v.Profile != 100 || v.Level != 40 {
+		t.Errorf("unexpected video: %+v", v)
+	}
+}
+
+func TestSegmenterAudioFrames(t *testing.T) {
+	var durations []float64
+	g := NewSegmenter(0.1, func(seq uint32, duration float64, b []byte) {
+		durations = append(durations, duration)
+	})
+	g.Write(pat())
+	g.Write(psiPacket(_pmtPID, []byte{0x02, 0xB0, 18, 0, 1, 0xC1, 0, 0, 0xE1, 0x01, 0xF0, 0,
+		mpegts.StreamTypeAAC, 0xE1, 0x01, 0xF0, 0}))
+	// AAC LC 48kHz stereo of 20 bytes, no PCR at all
+	frame := []byte{0xFF, 0xF1, 0x4C, 0x80, 20 >> 3, (20&0x07)<<5 | 0x1F, 0xFC, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	for i := 0; i < 12; i++ {
+		b := tsPacket(_audioPID, true, -1, append([]byte{0, 0, 1, 0xC0, 0, 0, 0x80, 0, 0}, frame...))
+		b[3] |= byte(i) & 0x0F
+		g.Write(b)
+	}
+	g.Flush()
+
+	d := 1024.0 / 48000
+	if len(durations) != 3 || durations[0] != 5*d || durations[1] != 5*d || durations[2] != 2*d {
+		t.Errorf("unexpected durations %v", durations)
+	}
+	if info := g.Info(); len(info.Codecs) != 1 || info.Codecs[0] != "mp4a.40.2" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if a := g.Audio(_audioPID); a == nil || a.SampleRate != 48000 || a.Channels != 2 {
+		t.Errorf("unexpected audio: %+v", a)
+	}
+}
+
+func TestSegmenterParts(t *testing.T) {
+	type part struct {
+		seq         uint32
+		no          int
+		duration    float64
+		independent bool
+	}
+	var parts []part
+	var joined, segments [][]byte
+	g := NewSegmenter(5, func(seq uint32, duration float64, b []byte) {
+		segments = append(segments, b)
+	})
+	g.OnPart(2, func(seq uint32, no int, duration float64, independent bool, b []byte) {
+		parts = append(parts, part{seq, no, duration, independent})
+		if no == 0 {
+			joined = append(joined, nil)
+		}
+		joined[len(joined)-1] = append(joined[len(joined)-1], b...)
+	})
+
+	g.Write(pat())
+	g.Write(pmt())
+	for sec := int64(1); sec <= 12; sec++ {
+		nal := byte(0x41)
+		if sec == 1 || sec == 7 || sec == 10 {
+			nal = 0x65
+		}
+		g.Write(tsPacket(_videoPID, true, sec, pes(0, 0, 0, 1, nal)))
+	}
+	g.Flush()
+
+	expected := []part{{0, 0, 2, true}, {0, 1, 2, false}, {0, 2, 2, false}, {1, 0, 2, true}, {1, 1, 2, false}, {1, 2, 1, false}}
+	if len(parts) != len(expected) {
+		t.Fatalf("unexpected parts %v", parts)
+	}
+	for i := range parts {
+		if parts[i] != expected[i] {
+			t.Errorf("part %d is %+v, expected %+v", i, parts[i], expected[i])
+		}
+	}
+	// parts of a segment make up the segment
+	for i := range segments {
+		if string(joined[i]) != string(segments[i]) {
+			t.Errorf("parts of segment %d differ from it", i)
+		}
+	}
+}
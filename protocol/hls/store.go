@@ -0,0 +1,138 @@
+package hls
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNotFound = errors.New("no such item in store")
+)
+
+// Store keeps the segments and playlists of a stream by name
+type Store interface {
+	Put(name string, b []byte) error
+	Get(name string) ([]byte, error)
+	Delete(name string) error
+	// Close removes all items of store
+	Close() error
+}
+
+// MemoryStore keeps items in memory
+type MemoryStore struct {
+	lock  sync.RWMutex
+	items map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: make(map[string][]byte)}
+}
+
+func (m *MemoryStore) Put(name string, b []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.items[name] = b
+	return nil
+}
+
+func (m *MemoryStore) Get(name string) ([]byte, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	b, ok := m.items[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return b, nil
+}
+
+func (m *MemoryStore) Delete(name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.items, name)
+	return nil
+}
+
+func (m *MemoryStore) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.items = make(map[string][]byte)
+	return nil
+}
+
+// DiskStore keeps items as files of a directory, the directory is removed on Close
+type DiskStore struct {
+	dir string
+}
+
+// NewDiskStore creates dir for a new store
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create hls store")
+	}
+	return &DiskStore{dir: dir}, nil
+}
+
+// Dir returns the directory of store
+func (d *DiskStore) Dir() string {
+	return d.dir
+}
+
+// Put writes a temporary file and renames it, a reader never sees a partial item
+func (d *DiskStore) Put(name string, b []byte) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Wrapf(err, "write %s", name)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrapf(err, "write %s", name)
+	}
+	return nil
+}
+
+func (d *DiskStore) Get(name string) ([]byte, error) {
+	path, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return b, errors.Wrapf(err, "read %s", name)
+}
+
+func (d *DiskStore) Delete(name string) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "delete %s", name)
+	}
+	return nil
+}
+
+func (d *DiskStore) Close() error {
+	return errors.Wrap(os.RemoveAll(d.dir), "remove hls store")
+}
+
+// path returns the file of name, a name out of dir is refused
+func (d *DiskStore) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", errors.Errorf("illegal item name %q", name)
+	}
+	return filepath.Join(d.dir, name), nil
+}
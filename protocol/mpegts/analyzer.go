@@ -0,0 +1,363 @@
+package mpegts
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// sync is acquired after these good sync bytes in a row and lost after these bad ones
+	_syncAcquire = 5
+	_syncLose    = 2
+	// PAT and each PMT repeat at least in this interval
+	_psiInterval = 500 * time.Millisecond
+	// an elementary stream in PMT is missing if it is absent for this long
+	_pidTimeout = 5 * time.Second
+)
+
+// Indicator is a priority 1 indicator of ETSI TR 101 290
+type Indicator int
+
+const (
+	TSSyncLoss Indicator = iota + 1
+	SyncByteError
+	PATError
+	ContinuityCountError
+	PMTError
+	PIDError
+)
+
+var _indicatorNames = [...]string{"", "TS_sync_loss", "Sync_byte_error", "PAT_error", "Continuity_count_error",
+	"PMT_error", "PID_error"}
+
+func (i Indicator) String() string {
+	if i > 0 && int(i) < len(_indicatorNames) {
+		return _indicatorNames[i]
+	}
+	return fmt.Sprintf("Indicator(%d)", int(i))
+}
+
+// Alarm is an error found by analyzer
+type Alarm struct {
+	Indicator Indicator
+	PID       uint16
+	// a continuity count error spans a loss of the transport, the stream itself may be intact
+	Loss   bool
+	Reason string
+}
+
+// AlarmFunc receives an alarm, it is called out of analyzer lock
+type AlarmFunc func(a *Alarm)
+
+// AnalyzerStats counts the errors of priority 1 indicators
+type AnalyzerStats struct {
+	Packets        uint64
+	InSync         bool
+	SyncLoss       uint64
+	SyncByteErrors uint64
+	PATErrors      uint64
+	CCErrors       uint64
+	// continuity count errors over a loss of the transport, part of CCErrors
+	CCLossErrors uint64
+	PMTErrors    uint64
+	PIDErrors    uint64
+	// the last and the longest repetition interval of PAT and PMT
+	PATInterval    time.Duration
+	MaxPATInterval time.Duration
+	PMTInterval    time.Duration
+	MaxPMTInterval time.Duration
+}
+
+type pidState struct {
+	// continuity counter of the last packet, -1 before the first one
+	cc  int
+	dup bool
+	// index of the last packet in stream
+	index uint64
+}
+
+// psiTimer tells when a table or stream is seen and when it is overdue
+type psiTimer struct {
+	seen time.Time
+	due  time.Time
+}
+
+// Analyzer checks a ts stream for the priority 1 errors of ETSI TR 101 290
+type Analyzer struct {
+	mu    sync.Mutex
+	alarm AlarmFunc
+	stats AnalyzerStats
+	Map   *ProgramMap
+	// consecutive good and bad sync bytes
+	good int
+	bad  int
+	pids map[uint16]*pidState
+	pat  psiTimer
+	// timers of PMT and elementary streams by pid
+	pmts    map[uint16]*psiTimer
+	streams map[uint16]*psiTimer
+	// packets before the last transport loss
+	lossIndex uint64
+	started   bool
+	pending   []Alarm
+}
+
+func NewAnalyzer(alarm AlarmFunc) *Analyzer {
+	a := new(Analyzer)
+	a.alarm = alarm
+	a.Map = NewProgramMap()
+	a.pids = make(map[uint16]*pidState)
+	a.pmts = make(map[uint16]*psiTimer)
+	a.streams = make(map[uint16]*psiTimer)
+	return a
+}
+
+// Stats returns a snapshot of counters
+func (a *Analyzer) Stats() *AnalyzerStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	st := a.stats
+	return &st
+}
+
+// MarkLoss tells packets were lost by transport before the next packet,
+// a continuity count error across the mark is counted as CCLossErrors
+func (a *Analyzer) MarkLoss() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.lossIndex = a.stats.Packets
+}
+
+// Write checks ts packet b arrived at now
+func (a *Analyzer) Write(b []byte, now time.Time) {
+	a.mu.Lock()
+	a.write(b, now)
+	a.check(now)
+	a.fire()
+}
+
+// Check raises the tables and streams overdue at now, call it periodically to find them absent
+// while no packet arrives
+func (a *Analyzer) Check(now time.Time) {
+	a.mu.Lock()
+	a.check(now)
+	a.fire()
+}
+
+// fire unlocks analyzer and passes the pending alarms
+func (a *Analyzer) fire() {
+	pending := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+
+	if a.alarm == nil {
+		return
+	}
+	for i := range pending {
+		a.alarm(&pending[i])
+	}
+}
+
+func (a *Analyzer) write(b []byte, now time.Time) {
+	if !a.started {
+		a.started = true
+		a.pat.due = now.Add(_psiInterval)
+	}
+	a.stats.Packets++
+	if len(b) < TSPackageSize || b[0] != _syncCode {
+		a.good = 0
+		a.bad++
+		a.stats.SyncByteErrors++
+		a.raise(SyncByteError, 0, false, "sync byte is lost")
+		if a.stats.InSync && a.bad >= _syncLose {
+			a.stats.InSync = false
+			a.stats.SyncLoss++
+			a.raise(TSSyncLoss, 0, false, fmt.Sprintf("%d bad sync bytes in a row", a.bad))
+		}
+		return
+	}
+	a.bad = 0
+	if a.good++; !a.stats.InSync && a.good >= _syncAcquire {
+		a.stats.InSync = true
+	}
+
+	pid := PID(b)
+	if pid == PIDNull {
+		return
+	}
+	a.continuity(pid, b)
+	switch {
+	case pid == PIDPAT:
+		a.onPAT(b, now)
+	case a.pmts[pid] != nil:
+		a.onPMT(pid, b, now)
+	default:
+		if t := a.streams[pid]; t != nil {
+			t.seen = now
+			t.due = now.Add(_pidTimeout)
+		}
+	}
+}
+
+// continuity checks the continuity counter of b, a packet may be duplicated once
+func (a *Analyzer) continuity(pid uint16, b []byte) {
+	st := a.pids[pid]
+	if st == nil {
+		st = &pidState{cc: -1}
+		a.pids[pid] = st
+	}
+	cc := int(b[3] & 0x0F)
+	last, lastIndex := st.cc, st.index
+	st.index = a.stats.Packets
+	if last < 0 || Discontinuity(b) {
+		st.cc, st.dup = cc, false
+		return
+	}
+	// counter is not incremented without payload
+	expected := last
+	if b[3]&0x10 != 0 {
+		if cc == last && !st.dup {
+			st.dup = true
+			return
+		}
+		expected = (last + 1) & 0x0F
+	}
+	st.cc = cc
+	if cc == expected {
+		st.dup = false
+		return
+	}
+	a.stats.CCErrors++
+	loss := lastIndex <= a.lossIndex
+	if loss {
+		a.stats.CCLossErrors++
+	}
+	reason := fmt.Sprintf("continuity counter %d, expected %d", cc, expected)
+	if cc == last {
+		reason = "packet is duplicated more than once"
+	}
+	a.raise(ContinuityCountError, pid, loss, reason)
+}
+
+func (a *Analyzer) onPAT(b []byte, now time.Time) {
+	if b[3]&0xC0 != 0 {
+		a.stats.PATErrors++
+		a.raise(PATError, PIDPAT, false, "PAT is scrambled")
+		return
+	}
+	if id, ok := tableID(b); ok {
+		if id != TableIDPAT {
+			a.stats.PATErrors++
+			a.raise(PATError, PIDPAT, false, fmt.Sprintf("table id %d on pid 0", id))
+			return
+		}
+		if !a.pat.seen.IsZero() {
+			a.stats.PATInterval = now.Sub(a.pat.seen)
+			if a.stats.PATInterval > a.stats.MaxPATInterval {
+				a.stats.MaxPATInterval = a.stats.PATInterval
+			}
+		}
+		a.pat.seen = now
+		a.pat.due = now.Add(_psiInterval)
+	}
+	if changed, _ := a.Map.Write(b); changed {
+		a.onProgram(now)
+	}
+}
+
+func (a *Analyzer) onPMT(pid uint16, b []byte, now time.Time) {
+	if b[3]&0xC0 != 0 {
+		a.stats.PMTErrors++
+		a.raise(PMTError, pid, false, "PMT is scrambled")
+		return
+	}
+	if id, ok := tableID(b); ok && id == TableIDPMT {
+		t := a.pmts[pid]
+		if !t.seen.IsZero() {
+			a.stats.PMTInterval = now.Sub(t.seen)
+			if a.stats.PMTInterval > a.stats.MaxPMTInterval {
+				a.stats.MaxPMTInterval = a.stats.PMTInterval
+			}
+		}
+		t.seen = now
+		t.due = now.Add(_psiInterval)
+	}
+	if changed, _ := a.Map.Write(b); changed {
+		a.onProgram(now)
+	}
+}
+
+// onProgram follows the pids of PMT and elementary streams, timers of the kept ones go on
+func (a *Analyzer) onProgram(now time.Time) {
+	pmts := make(map[uint16]*psiTimer)
+	if a.Map.PAT != nil {
+		for _, p := range a.Map.PAT.Programs {
+			if p.Number == 0 {
+				continue
+			}
+			t := a.pmts[p.PID]
+			if t == nil {
+				t = &psiTimer{due: now.Add(_psiInterval)}
+			}
+			pmts[p.PID] = t
+		}
+	}
+	a.pmts = pmts
+
+	streams := make(map[uint16]*psiTimer)
+	if pmt := a.Map.Program(); pmt != nil {
+		for _, es := range pmt.Streams {
+			t := a.streams[es.PID]
+			if t == nil {
+				t = &psiTimer{due: now.Add(_pidTimeout)}
+			}
+			streams[es.PID] = t
+		}
+	}
+	a.streams = streams
+}
+
+// check raises the tables and streams overdue, an error is raised again after another interval
+func (a *Analyzer) check(now time.Time) {
+	if !a.started {
+		return
+	}
+	if now.After(a.pat.due) {
+		a.pat.due = now.Add(_psiInterval)
+		a.stats.PATErrors++
+		a.raise(PATError, PIDPAT, false, fmt.Sprintf("PAT is absent over %s", _psiInterval))
+	}
+	for pid, t := range a.pmts {
+		if now.After(t.due) {
+			t.due = now.Add(_psiInterval)
+			a.stats.PMTErrors++
+			a.raise(PMTError, pid, false, fmt.Sprintf("PMT is absent over %s", _psiInterval))
+		}
+	}
+	for pid, t := range a.streams {
+		if now.After(t.due) {
+			t.due = now.Add(_pidTimeout)
+			a.stats.PIDErrors++
+			a.raise(PIDError, pid, false, fmt.Sprintf("pid in PMT is absent over %s", _pidTimeout))
+		}
+	}
+}
+
+func (a *Analyzer) raise(i Indicator, pid uint16, loss bool, reason string) {
+	a.pending = append(a.pending, Alarm{Indicator: i, PID: pid, Loss: loss, Reason: reason})
+}
+
+// tableID returns the table id of the section starting in b, false if no section starts
+func tableID(b []byte) (uint8, bool) {
+	if b[1]&0x40 == 0 {
+		return 0, false
+	}
+	payload := Payload(b)
+	if len(payload) < 1 || int(payload[0])+1 >= len(payload) {
+		return 0, false
+	}
+	return payload[1+int(payload[0])], true
+}
@@ -0,0 +1,92 @@
+package mpegts
+
+import (
+	"testing"
+	"time"
+)
+
+// ccPacket sets continuity counter cc of packet b
+func ccPacket(b []byte, cc int) []byte {
+	b[3] = b[3]&0xF0 | byte(cc)&0x0F
+	return b
+}
+
+func TestAnalyzer(t *testing.T) {
+	var alarms []Alarm
+	a := NewAnalyzer(func(al *Alarm) {
+		alarms = append(alarms, *al)
+	})
+	pmt := []byte{TableIDPMT, 0xB0, 0, 0, 1, 0xC1, 0, 0, 0xE1, 0x00, 0xF0, 0, StreamTypeH264, 0xE1, 0x00, 0xF0, 0}
+	pmt[2] = byte(len(pmt) - 3 + 4)
+	psi := func(cc int, now time.Time) {
+		a.Write(ccPacket(packets(PIDPAT, append([]byte{0}, patSection(0, 0x1000)...))[0], cc), now)
+		a.Write(ccPacket(packets(0x1000, append([]byte{0}, withCRC(pmt)...))[0], cc), now)
+	}
+	video := func(cc int, now time.Time) {
+		a.Write(ccPacket(packets(0x100, []byte{0, 0, 1, 0xE0})[0], cc), now)
+	}
+	expect := func(i Indicator, loss bool) {
+		t.Helper()
+		if len(alarms) == 0 || alarms[0].Indicator != i || alarms[0].Loss != loss {
+			t.Fatalf("expected %s of loss %v, got %+v", i, loss, alarms)
+		}
+		alarms = alarms[1:]
+	}
+
+	t0 := time.Now()
+	psi(0, t0)
+	for cc := 0; cc < 5; cc++ {
+		video(cc, t0.Add(100*time.Millisecond))
+	}
+	if st := a.Stats(); !st.InSync || len(alarms) > 0 {
+		t.Fatalf("stream should be in sync without error: %+v %+v", st, alarms)
+	}
+
+	// lost by source
+	video(6, t0.Add(100*time.Millisecond))
+	expect(ContinuityCountError, false)
+	// lost by transport
+	a.MarkLoss()
+	video(9, t0.Add(100*time.Millisecond))
+	expect(ContinuityCountError, true)
+	// a duplicate is allowed once
+	video(9, t0.Add(100*time.Millisecond))
+	video(9, t0.Add(100*time.Millisecond))
+	expect(ContinuityCountError, false)
+
+	// PAT and PMT repeat over 500ms
+	video(10, t0.Add(600*time.Millisecond))
+	expect(PATError, false)
+	expect(PMTError, false)
+	psi(1, t0.Add(700*time.Millisecond))
+	if st := a.Stats(); st.PATInterval != 700*time.Millisecond || st.MaxPMTInterval != 700*time.Millisecond {
+		t.Errorf("unexpected intervals: %+v", st)
+	}
+
+	// sync is lost after 2 bad sync bytes
+	a.Write(make([]byte, TSPackageSize), t0.Add(700*time.Millisecond))
+	a.Write(make([]byte, TSPackageSize), t0.Add(700*time.Millisecond))
+	expect(SyncByteError, false)
+	expect(SyncByteError, false)
+	expect(TSSyncLoss, false)
+
+	// video is absent over 5s
+	for i := 1; i <= 10; i++ {
+		psi(1+i, t0.Add(700*time.Millisecond+time.Duration(i)*500*time.Millisecond))
+	}
+	expect(PIDError, false)
+
+	// input stops, the tables are found absent by check
+	a.Check(t0.Add(6300 * time.Millisecond))
+	expect(PATError, false)
+	expect(PMTError, false)
+	if len(alarms) > 0 {
+		t.Errorf("unexpected alarms: %+v", alarms)
+	}
+
+	st := a.Stats()
+	if st.CCErrors != 3 || st.CCLossErrors != 1 || st.PATErrors != 2 || st.PMTErrors != 2 || st.PIDErrors != 1 ||
+		st.SyncByteErrors != 2 || st.SyncLoss != 1 || !st.InSync {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
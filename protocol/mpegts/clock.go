@@ -0,0 +1,71 @@
+package mpegts
+
+const (
+	// a jump of timestamps over 10 seconds is a discontinuity
+	_maxJumpSeconds = 10
+)
+
+// Clock unwraps the timestamps of a stream into a monotonic timeline, a discontinuity is bridged
+// so that the timeline goes on from the last value
+type Clock struct {
+	// modulus of timestamps and the largest jump taken as continuous
+	wrap    int64
+	maxJump int64
+	started bool
+	// last timestamp and its value on timeline
+	last int64
+	now  int64
+	// the next timestamp starts a new timebase
+	broken bool
+	// discontinuities met
+	Discontinuities int
+}
+
+// NewPCRClock unwraps PCR of 27MHz
+func NewPCRClock() *Clock {
+	return &Clock{wrap: PCRWrap, maxJump: _maxJumpSeconds * PCRClock}
+}
+
+// NewTimestampClock unwraps PTS and DTS of 90kHz
+func NewTimestampClock() *Clock {
+	return &Clock{wrap: TimestampWrap, maxJump: _maxJumpSeconds * TimestampClock}
+}
+
+// Unwrap returns v on the timeline, the first timestamp is kept as it is
+func (c *Clock) Unwrap(v int64) int64 {
+	v %= c.wrap
+	if !c.started {
+		c.started = true
+		c.last = v
+		c.now = v
+		return v
+	}
+	d := (v - c.last) % c.wrap
+	if d < 0 {
+		d += c.wrap
+	}
+	if d >= c.wrap/2 {
+		d -= c.wrap
+	}
+	if c.broken || d > c.maxJump || d < -c.maxJump {
+		// new timebase continues from the last value
+		c.broken = false
+		c.Discontinuities++
+		d = 0
+	}
+	c.now += d
+	c.last = v
+	return c.now
+}
+
+// Discontinue tells the next timestamp starts a new timebase, as discontinuity_indicator does
+func (c *Clock) Discontinue() {
+	if c.started {
+		c.broken = true
+	}
+}
+
+// Now returns the last value on the timeline
+func (c *Clock) Now() int64 {
+	return c.now
+}
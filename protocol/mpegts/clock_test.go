@@ -0,0 +1,69 @@
+package mpegts
+
+import (
+	"testing"
+)
+
+// pcrPacket builds a ts packet of pcr in 27MHz ticks
+func pcrPacket(pcr int64, discontinuity bool) []byte {
+	b := make([]byte, TSPackageSize)
+	b[0], b[1], b[2], b[3] = _syncCode, 0x01, 0x00, 0x20
+	b[4], b[5] = 183, 0x10
+	if discontinuity {
+		b[5] |= 0x80
+	}
+	base, ext := pcr/300, pcr%300
+	b[6], b[7], b[8], b[9] = byte(base>>25), byte(base>>17), byte(base>>9), byte(base>>1)
+	b[10], b[11] = byte(base&1)<<7|0x7E|byte(ext>>8), byte(ext)
+	return b
+}
+
+func TestParsePCR(t *testing.T) {
+	for _, pcr := range []int64{0, 299, 27000000 + 150, PCRWrap - 1, (TimestampWrap/2+1)*300 + 7} {
+		if v, ok := ParsePCR(pcrPacket(pcr, false)); !ok || v != pcr {
+			t.Errorf("parse pcr %d as %d", pcr, v)
+		}
+	}
+	if d, _ := ExtractPCR(pcrPacket(27000000/2, false)); d != 0.5 {
+		t.Errorf("pcr of half a second is %f", d)
+	}
+	b := pcrPacket(0, false)
+	b[5] = 0
+	if _, ok := ParsePCR(b); ok {
+		t.Error("packet without PCR flag")
+	}
+}
+
+func TestClock(t *testing.T) {
+	c := NewPCRClock()
+	start := PCRWrap - PCRClock
+	if v := c.Unwrap(start); v != start {
+		t.Errorf("first pcr is %d", v)
+	}
+	// over wraparound
+	if v := c.Unwrap(PCRClock); v != start+2*PCRClock {
+		t.Errorf("pcr after wraparound is %d", v)
+	}
+	// a jump of an hour continues from the last value
+	if v := c.Unwrap(PCRClock + 3600*PCRClock); v != start+2*PCRClock || c.Discontinuities != 1 {
+		t.Errorf("pcr after jump is %d", v)
+	}
+	if v := c.Unwrap(2*PCRClock + 3600*PCRClock); v != start+3*PCRClock {
+		t.Errorf("pcr after new timebase is %d", v)
+	}
+	// discontinuity_indicator starts a new timebase even for a small step
+	c.Discontinue()
+	if v := c.Unwrap(0); v != start+3*PCRClock || c.Discontinuities != 2 || c.Now() != v {
+		t.Errorf("pcr after discontinuity is %d", v)
+	}
+
+	// pts goes back and forth by B frames
+	ts := NewTimestampClock()
+	ts.Unwrap(TimestampWrap - 3000)
+	if v := ts.Unwrap(TimestampWrap - 6000); v != TimestampWrap-6000 {
+		t.Errorf("pts backward is %d", v)
+	}
+	if v := ts.Unwrap(3000); v != TimestampWrap+3000 || ts.Discontinuities != 0 {
+		t.Errorf("pts after wraparound is %d", v)
+	}
+}
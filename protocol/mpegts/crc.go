@@ -0,0 +1,27 @@
+package mpegts
+
+// _crcTable is of CRC-32/MPEG-2, polynomial 0x04C11DB7 without reflection
+var _crcTable = func() [256]uint32 {
+	var t [256]uint32
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04C11DB7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+// CRC32 returns the CRC of PSI sections, a section with its CRC appended sums up to zero
+func CRC32(b []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, v := range b {
+		crc = crc<<8 ^ _crcTable[byte(crc>>24)^v]
+	}
+	return crc
+}
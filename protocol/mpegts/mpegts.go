@@ -7,6 +7,9 @@ import (
 const (
 	_syncCode     = 0x47
 	TSPackageSize = 188
+
+	PCRClock = 27000000
+	PCRWrap  = TimestampWrap * 300
 )
 
 type Header struct {
@@ -27,36 +30,37 @@ func ParseHeader(b []byte) *Header {
 	h.PayloadUnitStart = (b[0] & 0x40) >> 6
 	h.Prio = (b[0] & 0x20) >> 5
 	b = codec.Decode16u(b, &h.PID)
-	h.PID &= 0x1F
+	h.PID &= 0x1FFF
 	h.Scra = (b[0] & 0xC0) >> 6
 	h.Adaptation = (b[0] & 0x30) >> 4
 	h.Counter = b[0] & 0x0F
 	return h
 }
 
-func ExtractPCR(b []byte) (float64, bool) {
-	if b[0] != _syncCode {
+// ParsePCR returns the PCR of ts packet b in 27MHz ticks
+func ParsePCR(b []byte) (int64, bool) {
+	if len(b) < 12 || b[0] != _syncCode {
 		return 0, false
 	}
-	pcrAdaptationFlag := (b[3] & 0x30) >> 4
-	if pcrAdaptationFlag != 2 && pcrAdaptationFlag != 3 {
+	af := (b[3] & 0x30) >> 4
+	if af != 2 && af != 3 {
 		return 0, false
 	}
-	if b[4] == 0 {
-		// adaptation_field_length is zero
+	if b[4] < 7 || b[5]&0x10 == 0 {
+		// adaptation field is too short for PCR, or it has none
 		return 0, false
 	}
-	pcrFlag := b[5] & 0x10
-	if pcrFlag == 0 {
+	// 33 bits base of 90kHz and 9 bits extension of 27MHz
+	base := int64(b[6])<<25 | int64(b[7])<<17 | int64(b[8])<<9 | int64(b[9])<<1 | int64(b[10]>>7)
+	ext := int64(b[10]&0x01)<<8 | int64(b[11])
+	return base*300 + ext, true
+}
+
+// ExtractPCR returns the PCR of ts packet b in seconds, it wraps around every 26.5 hours
+func ExtractPCR(b []byte) (float64, bool) {
+	pcr, ok := ParsePCR(b)
+	if !ok {
 		return 0, false
 	}
-	// there's a PCR
-	pcrBaseHigh := int64(b[6])<<24 | int64(b[7])<<16 | int64(b[8])<<8 | int64(b[9])
-	clock := float64(pcrBaseHigh / 45000.0)
-	if (b[10] & 0x80) != 0 {
-		clock += 1.0 / 90000
-	}
-	pcrExt := int64(b[10]&0x01)<<8 | int64(b[11])
-	clock += float64(pcrExt / 27000000.0)
-	return clock, true
+	return float64(pcr) / PCRClock, true
 }
@@ -0,0 +1,235 @@
+package mpegts
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// timestamps are 33 bits of a 90kHz clock
+	TimestampBits  = 33
+	TimestampWrap  = int64(1) << TimestampBits
+	TimestampClock = 90000
+
+	// PES of a larger size is given up, no access unit is that large
+	_maxPESSize = 8 << 20
+)
+
+var (
+	ErrPESHeader = errors.New("pes header is illegal")
+)
+
+// PES is a reassembled PES packet, an access unit of most streams
+type PES struct {
+	PID      uint16
+	Type     uint8 // stream type in PMT
+	StreamID uint8
+	// 90kHz timestamps, -1 if absent, DTS equals PTS if only PTS is present
+	PTS int64
+	DTS int64
+	// data_alignment_indicator, the data starts with an access unit
+	Aligned bool
+	// random_access_indicator of the first packet
+	RandomAccess bool
+	// a packet of PES was lost by continuity counter, data is broken
+	Broken bool
+	Data   []byte
+}
+
+// PESFunc receives a PES when it is complete, it owns the PES
+type PESFunc func(p *PES)
+
+type pesStream struct {
+	es *ElementaryStream
+	// continuity counter of the last packet, -1 before the first one
+	cc int
+	// header and data of PES in progress
+	buf []byte
+	// size of PES from its length field, zero if unbounded
+	size   int
+	ra     bool
+	broken bool
+	active bool
+}
+
+// Demuxer reassembles PES of the elementary streams in PMT
+type Demuxer struct {
+	Map     *ProgramMap
+	emit    PESFunc
+	streams map[uint16]*pesStream
+}
+
+func NewDemuxer(emit PESFunc) *Demuxer {
+	d := new(Demuxer)
+	d.Map = NewProgramMap()
+	d.emit = emit
+	d.streams = make(map[uint16]*pesStream)
+	return d
+}
+
+// Write reads ts packet b, a PES is emitted once the next one starts or its length is reached
+func (d *Demuxer) Write(b []byte) error {
+	if len(b) < TSPackageSize || b[0] != _syncCode {
+		return errors.New("not a ts packet")
+	}
+	pid := PID(b)
+	if d.Map.IsPSI(pid) {
+		changed, err := d.Map.Write(b)
+		if changed {
+			// streams may be changed by new PMT
+			d.Flush()
+			d.streams = make(map[uint16]*pesStream)
+		}
+		return err
+	}
+	st := d.streams[pid]
+	if st == nil {
+		es := d.Map.Stream(pid)
+		if es == nil {
+			return nil
+		}
+		st = &pesStream{es: es, cc: -1}
+		d.streams[pid] = st
+	}
+	if b[1]&0x80 != 0 {
+		// transport_error_indicator
+		st.broken = true
+		return nil
+	}
+
+	payload := Payload(b)
+	if payload == nil {
+		return nil
+	}
+	cc := int(b[3] & 0x0F)
+	if st.cc >= 0 && !Discontinuity(b) {
+		if cc == st.cc {
+			// duplicate packet
+			return nil
+		}
+		if cc != (st.cc+1)&0x0F {
+			st.broken = true
+		}
+	}
+	st.cc = cc
+
+	if b[1]&0x40 != 0 {
+		d.complete(pid, st)
+		st.active = true
+		st.broken = false
+		st.ra = RandomAccess(b)
+		st.buf = st.buf[:0]
+		st.size = 0
+		if len(payload) >= 6 {
+			if n := int(binary.BigEndian.Uint16(payload[4:])); n > 0 {
+				st.size = 6 + n
+			}
+		}
+	} else if !st.active {
+		// wait for the start of PES
+		return nil
+	}
+	st.buf = append(st.buf, payload...)
+	if len(st.buf) > _maxPESSize {
+		st.active = false
+		st.buf = nil
+		return errors.Errorf("pid[%d] pes is over %d bytes", pid, _maxPESSize)
+	}
+	if st.size > 0 && len(st.buf) >= st.size {
+		st.buf = st.buf[:st.size]
+		d.complete(pid, st)
+	}
+	return nil
+}
+
+// Flush emits the PES in progress of all pids
+func (d *Demuxer) Flush() {
+	for pid, st := range d.streams {
+		d.complete(pid, st)
+	}
+}
+
+func (d *Demuxer) complete(pid uint16, st *pesStream) {
+	if !st.active {
+		return
+	}
+	st.active = false
+	p, err := ParsePES(st.buf)
+	if err != nil {
+		return
+	}
+	p.PID = pid
+	p.Type = st.es.Type
+	p.RandomAccess = st.ra
+	p.Broken = st.broken
+	// data is handed over, the next PES takes a new buffer
+	st.buf = nil
+	d.emit(p)
+}
+
+// ParsePES parses a whole PES packet, data refers to b
+func ParsePES(b []byte) (*PES, error) {
+	if len(b) < 6 || b[0] != 0 || b[1] != 0 || b[2] != 1 {
+		return nil, ErrPESHeader
+	}
+	p := &PES{StreamID: b[3], PTS: -1, DTS: -1}
+	if !optionalHeader(p.StreamID) {
+		p.Data = b[6:]
+		return p, nil
+	}
+	if len(b) < 9 || b[6]&0xC0 != 0x80 {
+		return nil, ErrPESHeader
+	}
+	p.Aligned = b[6]&0x04 != 0
+	off := 9 + int(b[8])
+	if off > len(b) {
+		return nil, ErrPESHeader
+	}
+	h := b[9:off]
+	switch b[7] >> 6 {
+	case 2:
+		if len(h) < 5 {
+			return nil, ErrPESHeader
+		}
+		p.PTS = parseTimestamp(h)
+		p.DTS = p.PTS
+	case 3:
+		if len(h) < 10 {
+			return nil, ErrPESHeader
+		}
+		p.PTS = parseTimestamp(h)
+		p.DTS = parseTimestamp(h[5:])
+	}
+	p.Data = b[off:]
+	return p, nil
+}
+
+// TimestampDiff returns a - b of 33 bits timestamps, a wraparound between them is taken into account
+func TimestampDiff(a, b int64) int64 {
+	d := (a - b) & (TimestampWrap - 1)
+	if d >= TimestampWrap/2 {
+		d -= TimestampWrap
+	}
+	return d
+}
+
+func parseTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+// optionalHeader tells if PES of stream id has the optional header
+func optionalHeader(id uint8) bool {
+	switch id {
+	case 0xBC, 0xBE, 0xBF, 0xF0, 0xF1, 0xF2, 0xF8, 0xFF:
+		// program stream map, padding, private 2, ECM, EMM, DSMCC, H.222.1 type E, directory
+		return false
+	}
+	return true
+}
+
+// Discontinuity tells if the adaptation field of b sets discontinuity_indicator
+func Discontinuity(b []byte) bool {
+	af := (b[3] & 0x30) >> 4
+	return (af == 2 || af == 3) && b[4] > 0 && b[5]&0x80 != 0
+}
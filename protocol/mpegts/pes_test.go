@@ -0,0 +1,116 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+// esPackets splits payload into ts packets of pid from continuity counter cc, the last one is stuffed by adaptation field
+func esPackets(pid uint16, cc uint8, payload []byte) [][]byte {
+	var list [][]byte
+	for first := true; first || len(payload) > 0; first = false {
+		b := make([]byte, TSPackageSize)
+		b[0], b[1], b[2], b[3] = _syncCode, byte(pid>>8)&0x1F, byte(pid), 0x10|cc&0x0F
+		if first {
+			b[1] |= 0x40
+		}
+		off := 4
+		if n := TSPackageSize - 4 - len(payload); n > 0 {
+			b[3] |= 0x20
+			b[4] = byte(n - 1)
+			for i := 5; i < 4+n; i++ {
+				b[i] = 0xFF
+			}
+			if n > 1 {
+				b[5] = 0
+			}
+			off += n
+		}
+		payload = payload[copy(b[off:], payload):]
+		list = append(list, b)
+		cc++
+	}
+	return list
+}
+
+func encodeTimestamp(prefix uint8, ts int64) []byte {
+	return []byte{prefix<<4 | byte(ts>>29)&0x0E | 1, byte(ts >> 22), byte(ts>>14) | 1, byte(ts >> 7), byte(ts<<1) | 1}
+}
+
+func pesPacket(id uint8, pts, dts int64, bounded bool, data []byte) []byte {
+	h := []byte{0, 0, 1, id, 0, 0, 0x84, 0xC0, 10}
+	h = append(h, encodeTimestamp(3, pts)...)
+	h = append(h, encodeTimestamp(1, dts)...)
+	b := append(h, data...)
+	if bounded {
+		n := len(b) - 6
+		b[4], b[5] = byte(n>>8), byte(n)
+	}
+	return b
+}
+
+func demuxer(t *testing.T, emit PESFunc) *Demuxer {
+	d := NewDemuxer(emit)
+	pmt := withCRC([]byte{TableIDPMT, 0xB0, 23, 0, 1, 0xC1, 0, 0, 0xE1, 0x00, 0xF0, 0,
+		StreamTypeH264, 0xE1, 0x00, 0xF0, 0,
+		StreamTypeAAC, 0xE1, 0x01, 0xF0, 0})
+	for _, b := range append(packets(PIDPAT, append([]byte{0}, patSection(0, 0x1000)...)),
+		packets(0x1000, append([]byte{0}, pmt...))...) {
+		if err := d.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d
+}
+
+func TestDemuxer(t *testing.T) {
+	var list []*PES
+	d := demuxer(t, func(p *PES) {
+		list = append(list, p)
+	})
+
+	video := bytes.Repeat([]byte{0xAB}, 500)
+	// timestamps around the wraparound
+	pts, dts := TimestampWrap-10, TimestampWrap-3010
+	for _, b := range esPackets(0x100, 0, pesPacket(0xE0, pts, dts, false, video)) {
+		if err := d.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(list) != 0 {
+		t.Fatal("unbounded PES is emitted before the next one starts")
+	}
+	audio := []byte{1, 2, 3}
+	for _, b := range esPackets(0x101, 5, pesPacket(0xC0, 0, 0, true, audio)) {
+		_ = d.Write(b)
+	}
+	if len(list) != 1 || list[0].PID != 0x101 || list[0].Type != StreamTypeAAC || !bytes.Equal(list[0].Data, audio) {
+		t.Fatalf("bounded PES should be emitted at once: %+v", list)
+	}
+
+	next := esPackets(0x100, 3, pesPacket(0xE0, 3000, 0, false, video))
+	for _, b := range next {
+		_ = d.Write(b)
+	}
+	if len(list) != 2 {
+		t.Fatalf("%d PES emitted, expected 2", len(list))
+	}
+	p := list[1]
+	if p.PID != 0x100 || p.StreamID != 0xE0 || p.PTS != pts || p.DTS != dts || !p.Aligned || p.Broken || !bytes.Equal(p.Data, video) {
+		t.Errorf("unexpected PES: pid %d id %x pts %d dts %d aligned %v broken %v %d bytes",
+			p.PID, p.StreamID, p.PTS, p.DTS, p.Aligned, p.Broken, len(p.Data))
+	}
+	if diff := TimestampDiff(3000, pts); diff != 3010 {
+		t.Errorf("timestamp diff over wraparound is %d", diff)
+	}
+
+	// duplicate packet is ignored and a lost one breaks PES
+	lost := esPackets(0x100, 6, pesPacket(0xE0, 6000, 3000, false, video))
+	_ = d.Write(lost[0])
+	_ = d.Write(lost[0])
+	_ = d.Write(lost[2])
+	d.Flush()
+	if len(list) != 4 || list[2].Broken || !list[3].Broken {
+		t.Errorf("second video PES should be whole and the last one broken")
+	}
+}
@@ -0,0 +1,165 @@
+package mpegts
+
+// PID returns the pid of ts packet b
+func PID(b []byte) uint16 {
+	return uint16(b[1]&0x1F)<<8 | uint16(b[2])
+}
+
+// Payload returns the payload of ts packet b after the adaptation field, nil if there is none
+func Payload(b []byte) []byte {
+	if len(b) < TSPackageSize || b[0] != _syncCode {
+		return nil
+	}
+	off := 4
+	switch (b[3] & 0x30) >> 4 {
+	case 1:
+	case 3:
+		off += 1 + int(b[4])
+	default:
+		return nil
+	}
+	if off >= TSPackageSize {
+		return nil
+	}
+	return b[off:TSPackageSize]
+}
+
+// RandomAccess tells if the adaptation field of b marks a random access point
+func RandomAccess(b []byte) bool {
+	af := (b[3] & 0x30) >> 4
+	return (af == 2 || af == 3) && b[4] > 0 && b[5]&0x40 != 0
+}
+
+// Probe follows PSI of a ts stream to find its H.264 or H.265 video and its first audio, and tells the video PES starting with a keyframe
+type Probe struct {
+	Map       *ProgramMap
+	videoPID  uint16
+	videoType uint8
+	audioPID  uint16
+	audioType uint8
+	// keyframe is found in the video PES in progress
+	found bool
+	// last bytes of the previous payload, a start code may span packets
+	tail []byte
+	scan []byte
+}
+
+func NewProbe() *Probe {
+	p := new(Probe)
+	p.Map = NewProgramMap()
+	p.tail = make([]byte, 0, 3)
+	p.scan = make([]byte, 0, TSPackageSize+3)
+	return p
+}
+
+// Ready tells if PMT is found, video is known from then on
+func (p *Probe) Ready() bool {
+	return p.Map.Program() != nil
+}
+
+// Video returns the pid and stream type of video, zero if there is none of H.264 or H.265
+func (p *Probe) Video() (uint16, uint8) {
+	return p.videoPID, p.videoType
+}
+
+// Audio returns the pid and stream type of the first audio, zero if there is none
+func (p *Probe) Audio() (uint16, uint8) {
+	return p.audioPID, p.audioType
+}
+
+// PSI returns the last PAT and PMT packets, they make a segment decodable on its own
+func (p *Probe) PSI() []byte {
+	return p.Map.PSI()
+}
+
+// Inspect reads ts packet b, start tells a video PES starts in b and
+// key tells a keyframe of the video PES in progress is found in b, it is told once a PES
+func (p *Probe) Inspect(b []byte) (start, key bool) {
+	if len(b) < TSPackageSize || b[0] != _syncCode {
+		return false, false
+	}
+	pid := PID(b)
+	if p.Map.IsPSI(pid) {
+		if changed, _ := p.Map.Write(b); changed {
+			p.onProgram()
+		}
+		return false, false
+	}
+	if pid == p.videoPID && p.videoPID != 0 {
+		return p.onVideo(b, b[1]&0x40 != 0)
+	}
+	return false, false
+}
+
+func (p *Probe) onProgram() {
+	p.videoPID, p.videoType = 0, 0
+	p.audioPID, p.audioType = 0, 0
+	pmt := p.Map.Program()
+	if pmt == nil {
+		return
+	}
+	for _, es := range pmt.Streams {
+		if p.videoPID == 0 && (es.Type == StreamTypeH264 || es.Type == StreamTypeH265) {
+			p.videoPID, p.videoType = es.PID, es.Type
+		}
+		if p.audioPID == 0 && es.IsAudio() {
+			p.audioPID, p.audioType = es.PID, es.Type
+		}
+	}
+}
+
+func (p *Probe) onVideo(b []byte, pusi bool) (start, key bool) {
+	payload := Payload(b)
+	if pusi {
+		start = true
+		p.found = false
+		p.tail = p.tail[:0]
+		payload = pesData(payload)
+	}
+	if p.found {
+		return start, false
+	}
+	if RandomAccess(b) || p.keyframe(payload) {
+		p.found = true
+		return start, true
+	}
+	return start, false
+}
+
+// keyframe scans payload for the NAL unit of an IDR picture of H.264 or an IRAP picture of H.265
+func (p *Probe) keyframe(payload []byte) bool {
+	p.scan = append(append(p.scan[:0], p.tail...), payload...)
+	found := false
+	for i := 0; i+3 < len(p.scan); i++ {
+		if p.scan[i] != 0 || p.scan[i+1] != 0 || p.scan[i+2] != 1 {
+			continue
+		}
+		h := p.scan[i+3]
+		if p.videoType == StreamTypeH264 && h&0x1F == 5 {
+			found = true
+			break
+		}
+		if t := (h >> 1) & 0x3F; p.videoType == StreamTypeH265 && t >= 16 && t <= 21 {
+			found = true
+			break
+		}
+	}
+	n := len(p.scan)
+	if n > 3 {
+		n = 3
+	}
+	p.tail = append(p.tail[:0], p.scan[len(p.scan)-n:]...)
+	return found
+}
+
+// pesData returns the elementary stream data of a PES starting in payload
+func pesData(payload []byte) []byte {
+	if len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return nil
+	}
+	off := 9 + int(payload[8])
+	if off > len(payload) {
+		return nil
+	}
+	return payload[off:]
+}
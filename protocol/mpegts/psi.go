@@ -0,0 +1,505 @@
+package mpegts
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	PIDPAT  = 0x0000
+	PIDSDT  = 0x0011
+	PIDNull = 0x1FFF
+
+	TableIDPAT = 0x00
+	TableIDPMT = 0x02
+	TableIDSDT = 0x42
+
+	StreamTypeMPEG1Video = 0x01
+	StreamTypeMPEG2Video = 0x02
+	StreamTypeMPEG1Audio = 0x03
+	StreamTypeMPEG2Audio = 0x04
+	StreamTypePrivate    = 0x06
+	StreamTypeAAC        = 0x0F
+	StreamTypeMPEG4Video = 0x10
+	StreamTypeAACLATM    = 0x11
+	StreamTypeH264       = 0x1B
+	StreamTypeH265       = 0x24
+	StreamTypeAC3        = 0x81
+	StreamTypeEAC3       = 0x87
+
+	DescriptorRegistration = 0x05
+	DescriptorService      = 0x48
+	DescriptorAC3          = 0x6A
+	DescriptorEAC3         = 0x7A
+
+	// a section is at most 4096 bytes, 1024 for PAT, PMT and SDT
+	_maxSectionSize = 4096
+)
+
+var (
+	ErrSectionCRC    = errors.New("section crc mismatch")
+	ErrSectionLength = errors.New("section length is illegal")
+)
+
+// Section is a PSI section of the long form
+type Section struct {
+	TableID   uint8
+	Extension uint16 // transport stream id of PAT and SDT, program number of PMT
+	Version   uint8
+	Current   bool // applicable now, or the next one
+	Number    uint8
+	Last      uint8
+	// table data after section header and before CRC
+	Data []byte
+}
+
+// ParseSection validates and parses a whole section with CRC
+func ParseSection(b []byte) (*Section, error) {
+	if len(b) < 3 {
+		return nil, ErrSectionLength
+	}
+	n := 3 + int(binary.BigEndian.Uint16(b[1:])&0x0FFF)
+	if n > len(b) || n < 12 {
+		return nil, ErrSectionLength
+	}
+	b = b[:n]
+	if CRC32(b) != 0 {
+		return nil, ErrSectionCRC
+	}
+	s := new(Section)
+	s.TableID = b[0]
+	s.Extension = binary.BigEndian.Uint16(b[3:])
+	s.Version = (b[5] >> 1) & 0x1F
+	s.Current = b[5]&0x01 != 0
+	s.Number = b[6]
+	s.Last = b[7]
+	s.Data = b[8 : n-4]
+	return s, nil
+}
+
+type Descriptor struct {
+	Tag  uint8
+	Data []byte
+}
+
+type Program struct {
+	Number uint16
+	PID    uint16 // pid of PMT
+}
+
+type PAT struct {
+	TransportStreamID uint16
+	Version           uint8
+	NetworkPID        uint16
+	Programs          []Program
+}
+
+type ElementaryStream struct {
+	Type        uint8
+	PID         uint16
+	Descriptors []Descriptor
+}
+
+func (es *ElementaryStream) IsVideo() bool {
+	switch es.Type {
+	case StreamTypeMPEG1Video, StreamTypeMPEG2Video, StreamTypeMPEG4Video, StreamTypeH264, StreamTypeH265:
+		return true
+	}
+	return false
+}
+
+func (es *ElementaryStream) IsAudio() bool {
+	switch es.Type {
+	case StreamTypeMPEG1Audio, StreamTypeMPEG2Audio, StreamTypeAAC, StreamTypeAACLATM, StreamTypeAC3, StreamTypeEAC3:
+		return true
+	case StreamTypePrivate:
+		// DVB AC-3 and E-AC-3, Opus is registered
+		return es.Descriptor(DescriptorAC3) != nil || es.Descriptor(DescriptorEAC3) != nil || es.Registration() == "Opus"
+	}
+	return false
+}
+
+// Descriptor returns the first descriptor of tag, nil if there is none
+func (es *ElementaryStream) Descriptor(tag uint8) *Descriptor {
+	for i := range es.Descriptors {
+		if es.Descriptors[i].Tag == tag {
+			return &es.Descriptors[i]
+		}
+	}
+	return nil
+}
+
+// Registration returns format_identifier of the registration descriptor, empty if there is none
+func (es *ElementaryStream) Registration() string {
+	if d := es.Descriptor(DescriptorRegistration); d != nil && len(d.Data) >= 4 {
+		return string(d.Data[:4])
+	}
+	return ""
+}
+
+type PMT struct {
+	Program     uint16
+	Version     uint8
+	PCRPID      uint16
+	Descriptors []Descriptor
+	Streams     []ElementaryStream
+}
+
+// Video returns the first video stream, nil if there is none
+func (p *PMT) Video() *ElementaryStream {
+	for i := range p.Streams {
+		if p.Streams[i].IsVideo() {
+			return &p.Streams[i]
+		}
+	}
+	return nil
+}
+
+func (p *PMT) Audio() []*ElementaryStream {
+	list := make([]*ElementaryStream, 0, 1)
+	for i := range p.Streams {
+		if p.Streams[i].IsAudio() {
+			list = append(list, &p.Streams[i])
+		}
+	}
+	return list
+}
+
+type Service struct {
+	ID       uint16
+	Type     uint8
+	Provider string
+	Name     string
+}
+
+type SDT struct {
+	TransportStreamID uint16
+	Version           uint8
+	OriginalNetworkID uint16
+	Services          []Service
+}
+
+// ProgramMap follows PSI of a ts stream, tables are updated when a new version arrives
+type ProgramMap struct {
+	PAT *PAT
+	// PMT by program number
+	PMTs map[uint16]*PMT
+	SDT  *SDT
+
+	asm map[uint16]*assembler
+	// sections seen of the current version by table id and extension
+	versions map[uint32]*tableVersion
+	// ts packets of the section in progress and of the last complete one by pid
+	pending map[uint16][]byte
+	raw     map[uint16][]byte
+}
+
+type tableVersion struct {
+	version uint8
+	seen    map[uint8]bool
+}
+
+func NewProgramMap() *ProgramMap {
+	m := new(ProgramMap)
+	m.PMTs = make(map[uint16]*PMT)
+	m.asm = make(map[uint16]*assembler)
+	m.versions = make(map[uint32]*tableVersion)
+	m.pending = make(map[uint16][]byte)
+	m.raw = make(map[uint16][]byte)
+	return m
+}
+
+// IsPSI tells if pid carries PAT, PMT or SDT
+func (m *ProgramMap) IsPSI(pid uint16) bool {
+	if pid == PIDPAT || pid == PIDSDT {
+		return true
+	}
+	return m.PAT != nil && m.pmtPID(pid)
+}
+
+// Write reads ts packet b, changed tells a table is added or updated
+func (m *ProgramMap) Write(b []byte) (changed bool, err error) {
+	if len(b) < TSPackageSize || b[0] != _syncCode {
+		return false, nil
+	}
+	pid := PID(b)
+	if !m.IsPSI(pid) {
+		return false, nil
+	}
+	pusi := b[1]&0x40 != 0
+	if pusi {
+		m.pending[pid] = append(m.pending[pid][:0], b[:TSPackageSize]...)
+	} else if len(m.pending[pid]) > 0 {
+		m.pending[pid] = append(m.pending[pid], b[:TSPackageSize]...)
+	}
+
+	a := m.asm[pid]
+	if a == nil {
+		a = new(assembler)
+		m.asm[pid] = a
+	}
+	secs := a.feed(Payload(b), pusi)
+	for _, raw := range secs {
+		s, e := ParseSection(raw)
+		if e != nil {
+			err = errors.Wrapf(e, "pid[%d] table[%d]", pid, raw[0])
+			continue
+		}
+		if m.apply(pid, s) {
+			changed = true
+		}
+	}
+	if len(secs) > 0 && err == nil {
+		m.raw[pid] = append(m.raw[pid][:0], m.pending[pid]...)
+		m.pending[pid] = m.pending[pid][:0]
+	}
+	return changed, err
+}
+
+// Program returns the PMT of the first program, nil until it is known
+func (m *ProgramMap) Program() *PMT {
+	if m.PAT == nil || len(m.PAT.Programs) == 0 {
+		return nil
+	}
+	return m.PMTs[m.PAT.Programs[0].Number]
+}
+
+// Stream returns the elementary stream of pid in any program, nil if it is unknown
+func (m *ProgramMap) Stream(pid uint16) *ElementaryStream {
+	for _, p := range m.PMTs {
+		for i := range p.Streams {
+			if p.Streams[i].PID == pid {
+				return &p.Streams[i]
+			}
+		}
+	}
+	return nil
+}
+
+// PSI returns the ts packets of the last PAT and PMT of the first program
+func (m *ProgramMap) PSI() []byte {
+	b := make([]byte, 0, 2*TSPackageSize)
+	b = append(b, m.raw[PIDPAT]...)
+	if m.PAT != nil && len(m.PAT.Programs) > 0 {
+		b = append(b, m.raw[m.PAT.Programs[0].PID]...)
+	}
+	return b
+}
+
+func (m *ProgramMap) pmtPID(pid uint16) bool {
+	for _, p := range m.PAT.Programs {
+		if p.PID == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// apply updates table of s, returns false if s is seen or not applicable
+func (m *ProgramMap) apply(pid uint16, s *Section) bool {
+	if !s.Current {
+		return false
+	}
+	key := uint32(s.TableID)<<16 | uint32(s.Extension)
+	v := m.versions[key]
+	if v != nil && v.version == s.Version && v.seen[s.Number] {
+		return false
+	}
+	renew := v == nil || v.version != s.Version
+
+	switch {
+	case pid == PIDPAT && s.TableID == TableIDPAT:
+		if renew || m.PAT == nil {
+			m.PAT = &PAT{TransportStreamID: s.Extension, Version: s.Version}
+		}
+		m.onPAT(s)
+	case pid == PIDSDT && s.TableID == TableIDSDT:
+		if renew || m.SDT == nil {
+			m.SDT = &SDT{TransportStreamID: s.Extension, Version: s.Version}
+		}
+		m.onSDT(s)
+	case s.TableID == TableIDPMT && m.PAT != nil && m.pmtPID(pid):
+		if !m.onPMT(s) {
+			return false
+		}
+	default:
+		return false
+	}
+
+	if renew {
+		v = &tableVersion{version: s.Version, seen: make(map[uint8]bool)}
+		m.versions[key] = v
+	}
+	v.seen[s.Number] = true
+	return true
+}
+
+func (m *ProgramMap) onPAT(s *Section) {
+	for d := s.Data; len(d) >= 4; d = d[4:] {
+		number := binary.BigEndian.Uint16(d)
+		pid := binary.BigEndian.Uint16(d[2:]) & 0x1FFF
+		if number == 0 {
+			m.PAT.NetworkPID = pid
+			continue
+		}
+		m.PAT.Programs = append(m.PAT.Programs, Program{Number: number, PID: pid})
+	}
+	if s.Number != s.Last {
+		return
+	}
+	// forget the programs gone
+	for number := range m.PMTs {
+		found := false
+		for _, p := range m.PAT.Programs {
+			found = found || p.Number == number
+		}
+		if !found {
+			delete(m.PMTs, number)
+			delete(m.versions, uint32(TableIDPMT)<<16|uint32(number))
+		}
+	}
+}
+
+func (m *ProgramMap) onPMT(s *Section) bool {
+	d := s.Data
+	if len(d) < 4 {
+		return false
+	}
+	p := &PMT{Program: s.Extension, Version: s.Version}
+	p.PCRPID = binary.BigEndian.Uint16(d) & 0x1FFF
+	n := int(binary.BigEndian.Uint16(d[2:]) & 0x0FFF)
+	if 4+n > len(d) {
+		return false
+	}
+	p.Descriptors = parseDescriptors(d[4 : 4+n])
+	for d = d[4+n:]; len(d) >= 5; {
+		es := ElementaryStream{Type: d[0], PID: binary.BigEndian.Uint16(d[1:]) & 0x1FFF}
+		n = int(binary.BigEndian.Uint16(d[3:]) & 0x0FFF)
+		if 5+n > len(d) {
+			return false
+		}
+		es.Descriptors = parseDescriptors(d[5 : 5+n])
+		p.Streams = append(p.Streams, es)
+		d = d[5+n:]
+	}
+	m.PMTs[p.Program] = p
+	return true
+}
+
+func (m *ProgramMap) onSDT(s *Section) {
+	d := s.Data
+	if len(d) < 3 {
+		return
+	}
+	m.SDT.OriginalNetworkID = binary.BigEndian.Uint16(d)
+	for d = d[3:]; len(d) >= 5; {
+		sv := Service{ID: binary.BigEndian.Uint16(d)}
+		n := int(binary.BigEndian.Uint16(d[3:]) & 0x0FFF)
+		if 5+n > len(d) {
+			return
+		}
+		for _, desc := range parseDescriptors(d[5 : 5+n]) {
+			if desc.Tag != DescriptorService || len(desc.Data) < 2 {
+				continue
+			}
+			sv.Type = desc.Data[0]
+			rest := desc.Data[1:]
+			sv.Provider, rest = dvbString(rest)
+			sv.Name, _ = dvbString(rest)
+		}
+		m.SDT.Services = append(m.SDT.Services, sv)
+		d = d[5+n:]
+	}
+}
+
+func parseDescriptors(b []byte) []Descriptor {
+	var list []Descriptor
+	for len(b) >= 2 {
+		n := int(b[1])
+		if 2+n > len(b) {
+			break
+		}
+		list = append(list, Descriptor{Tag: b[0], Data: append([]byte(nil), b[2:2+n]...)})
+		b = b[2+n:]
+	}
+	return list
+}
+
+// dvbString reads a string prefixed by its length, the leading character table selector is skipped
+func dvbString(b []byte) (string, []byte) {
+	if len(b) < 1 || 1+int(b[0]) > len(b) {
+		return "", nil
+	}
+	s := b[1 : 1+int(b[0])]
+	if len(s) > 0 && s[0] < 0x20 {
+		s = s[1:]
+	}
+	return string(s), b[1+int(b[0]):]
+}
+
+// assembler joins sections spanning ts packets of a pid
+type assembler struct {
+	buf []byte
+	// a section is in progress
+	started bool
+}
+
+// feed reads payload of a packet, returns the sections completed
+func (a *assembler) feed(payload []byte, pusi bool) [][]byte {
+	var out [][]byte
+	if pusi {
+		if len(payload) < 1 {
+			return nil
+		}
+		ptr := int(payload[0])
+		payload = payload[1:]
+		if ptr > len(payload) {
+			a.reset()
+			return nil
+		}
+		// bytes before pointer end the previous section
+		if a.started {
+			a.buf = append(a.buf, payload[:ptr]...)
+			out = a.take(out)
+		}
+		a.buf = a.buf[:0]
+		a.started = true
+		payload = payload[ptr:]
+	} else if !a.started {
+		return nil
+	}
+	a.buf = append(a.buf, payload...)
+	return a.take(out)
+}
+
+// take pops the complete sections in buf
+func (a *assembler) take(out [][]byte) [][]byte {
+	for a.started && len(a.buf) >= 3 {
+		if a.buf[0] == 0xFF {
+			// stuffing to the end of packet
+			a.reset()
+			break
+		}
+		n := 3 + int(binary.BigEndian.Uint16(a.buf[1:])&0x0FFF)
+		if n > _maxSectionSize {
+			a.reset()
+			break
+		}
+		if len(a.buf) < n {
+			break
+		}
+		out = append(out, append([]byte(nil), a.buf[:n]...))
+		a.buf = append(a.buf[:0], a.buf[n:]...)
+	}
+	if len(a.buf) == 0 {
+		// the next section starts in a packet of pusi
+		a.started = false
+	}
+	return out
+}
+
+func (a *assembler) reset() {
+	a.buf = a.buf[:0]
+	a.started = false
+}
@@ -0,0 +1,135 @@
+package mpegts
+
+import (
+	"testing"
+)
+
+// withCRC appends CRC to section
+func withCRC(sec []byte) []byte {
+	crc := CRC32(sec)
+	return append(sec, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+// packets splits payload into ts packets of pid, stuffed by 0xFF
+func packets(pid uint16, payload []byte) [][]byte {
+	var list [][]byte
+	for first := true; first || len(payload) > 0; first = false {
+		b := make([]byte, TSPackageSize)
+		b[0], b[1], b[2], b[3] = _syncCode, byte(pid>>8)&0x1F, byte(pid), 0x10
+		if first {
+			b[1] |= 0x40
+		}
+		n := copy(b[4:], payload)
+		for i := 4 + n; i < TSPackageSize; i++ {
+			b[i] = 0xFF
+		}
+		payload = payload[n:]
+		list = append(list, b)
+	}
+	return list
+}
+
+func patSection(version uint8, pmtPID uint16) []byte {
+	return withCRC([]byte{TableIDPAT, 0xB0, 13, 0, 7, 0xC1 | version<<1, 0, 0, 0, 1, 0xE0 | byte(pmtPID>>8), byte(pmtPID)})
+}
+
+func TestCRC32(t *testing.T) {
+	if crc := CRC32([]byte("123456789")); crc != 0x0376E6E7 {
+		t.Errorf("crc is %08x", crc)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	h := ParseHeader([]byte{_syncCode, 0x41, 0x00, 0x10})
+	if h.PID != 0x100 || h.PayloadUnitStart != 1 {
+		t.Errorf("unexpected header: %+v", h)
+	}
+}
+
+func TestProgramMap(t *testing.T) {
+	m := NewProgramMap()
+	for _, b := range packets(PIDPAT, append([]byte{0}, patSection(0, 0x1000)...)) {
+		if changed, err := m.Write(b); !changed || err != nil {
+			t.Fatalf("PAT is not applied: %v", err)
+		}
+	}
+	if m.PAT.TransportStreamID != 7 || len(m.PAT.Programs) != 1 || m.PAT.Programs[0].PID != 0x1000 {
+		t.Fatalf("unexpected PAT: %+v", m.PAT)
+	}
+	// same version is ignored
+	if changed, _ := m.Write(packets(PIDPAT, append([]byte{0}, patSection(0, 0x1000)...))[0]); changed {
+		t.Error("PAT of the same version should be ignored")
+	}
+
+	// PMT of a long descriptor spans packets
+	desc := make([]byte, 200)
+	pmt := []byte{TableIDPMT, 0xB0, 0, 0, 1, 0xC1, 0, 0, 0xE1, 0x00, 0xF0, 0,
+		StreamTypeH264, 0xE1, 0x00, 0xF0, byte(2 + len(desc)), 0x05, byte(len(desc))}
+	pmt = append(pmt, desc...)
+	pmt = append(pmt, StreamTypeAAC, 0xE1, 0x01, 0xF0, 0)
+	pmt[2] = byte(len(pmt) - 3 + 4)
+	list := packets(0x1000, append([]byte{0}, withCRC(pmt)...))
+	if len(list) != 2 {
+		t.Fatalf("PMT should span 2 packets, got %d", len(list))
+	}
+	if changed, _ := m.Write(list[0]); changed || m.Program() != nil {
+		t.Error("PMT is not complete in the first packet")
+	}
+	if changed, err := m.Write(list[1]); !changed || err != nil {
+		t.Fatalf("PMT is not applied: %v", err)
+	}
+	p := m.Program()
+	if p.PCRPID != 0x100 || len(p.Streams) != 2 || p.Video().PID != 0x100 || len(p.Audio()) != 1 {
+		t.Fatalf("unexpected PMT: %+v", p)
+	}
+	if len(p.Streams[0].Descriptors) != 1 || len(p.Streams[0].Descriptors[0].Data) != len(desc) {
+		t.Errorf("unexpected descriptors: %+v", p.Streams[0].Descriptors)
+	}
+	if es := m.Stream(0x101); es == nil || es.Type != StreamTypeAAC {
+		t.Errorf("unexpected stream of pid 0x101: %+v", es)
+	}
+	if psi := m.PSI(); len(psi) != 3*TSPackageSize {
+		t.Errorf("PSI should be 3 packets, got %d bytes", len(psi))
+	}
+
+	// a new version moves PMT
+	m.Write(packets(PIDPAT, append([]byte{0}, patSection(1, 0x1001)...))[0])
+	if m.PAT.Version != 1 || m.PAT.Programs[0].PID != 0x1001 || !m.IsPSI(0x1001) || m.IsPSI(0x1000) {
+		t.Errorf("PAT is not updated: %+v", m.PAT)
+	}
+
+	// corrupted section
+	b := packets(PIDPAT, append([]byte{0}, patSection(2, 0x1002)...))[0]
+	b[10] ^= 0xFF
+	if _, err := m.Write(b); err == nil || m.PAT.Version != 1 {
+		t.Error("section of wrong crc should be rejected")
+	}
+}
+
+func TestSDT(t *testing.T) {
+	m := NewProgramMap()
+	desc := []byte{DescriptorService, 0, 0x01, 4, 'g', 'o', 's', 'r', 5, 0x15, 'l', 'i', 'v', 'e'}
+	desc[1] = byte(len(desc) - 2)
+	sdt := []byte{TableIDSDT, 0xF0, 0, 0, 7, 0xC1, 0, 0, 0xFF, 0x01, 0xFF,
+		0, 1, 0xFC, 0x80, byte(len(desc))}
+	sdt = append(sdt, desc...)
+	sdt[2] = byte(len(sdt) - 3 + 4)
+	if changed, err := m.Write(packets(PIDSDT, append([]byte{0}, withCRC(sdt)...))[0]); !changed || err != nil {
+		t.Fatalf("SDT is not applied: %v", err)
+	}
+	if m.SDT.OriginalNetworkID != 0xFF01 || len(m.SDT.Services) != 1 {
+		t.Fatalf("unexpected SDT: %+v", m.SDT)
+	}
+	if sv := m.SDT.Services[0]; sv.ID != 1 || sv.Type != 1 || sv.Provider != "gosr" || sv.Name != "live" {
+		t.Errorf("unexpected service: %+v", sv)
+	}
+}
+
+func TestPrivateAudio(t *testing.T) {
+	opus := ElementaryStream{Type: StreamTypePrivate, Descriptors: []Descriptor{{Tag: DescriptorRegistration, Data: []byte("Opus")}}}
+	ac3 := ElementaryStream{Type: StreamTypePrivate, Descriptors: []Descriptor{{Tag: DescriptorAC3, Data: []byte{0}}}}
+	data := ElementaryStream{Type: StreamTypePrivate}
+	if !opus.IsAudio() || opus.Registration() != "Opus" || !ac3.IsAudio() || data.IsAudio() {
+		t.Error("private audio is not told by descriptors")
+	}
+}
@@ -38,4 +38,47 @@ const (
 	HSExtTypeCongestion = 6
 	HSExtTypeFilter     = 7
 	HSExtTypeGroup      = 8
+
+	// state of key material answered in KMRSP if KMREQ is rejected
+	KMStateNoSecret  = 3
+	KMStateBadSecret = 4
+
+	SRTVersion = 0x00010403
+
+	SRTFlagTSBPDSND     = 0x00000001
+	SRTFlagTSBPDRCV     = 0x00000002
+	SRTFlagCRYPT        = 0x00000004
+	SRTFlagTLPKTDROP    = 0x00000008
+	SRTFlagNAKREPORT    = 0x00000010
+	SRTFlagREXMITFLG    = 0x00000020
+	SRTFlagSTREAM       = 0x00000040
+	SRTFlagPACKETFILTER = 0x00000080
+
+	LossRangeFlag = 0x80000000
+
+	// rejection reasons carried in handshake type of conclusion response
+	RejUnknown     = 1000
+	RejSystem      = 1001
+	RejPeer        = 1002
+	RejResource    = 1003
+	RejRogue       = 1004
+	RejBacklog     = 1005
+	RejIPE         = 1006
+	RejClose       = 1007
+	RejVersion     = 1008
+	RejRdvCookie   = 1009
+	RejBadSecret   = 1010
+	RejUnsecure    = 1011
+	RejMessageAPI  = 1012
+	RejCongestion  = 1013
+	RejFilter      = 1014
+	RejGroup       = 1015
+	RejTimeout     = 1016
+	RejPredefined  = 1000
+	RejUserDefined = 2000
+
+	KKNone = 0
+	KKEven = 1
+	KKOdd  = 2
+	KKBoth = 3
 )
@@ -0,0 +1,215 @@
+package srt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	_kmSign       = 0x2029
+	_kmHeaderSize = 16
+	_kmCipherCTR  = 2
+	_kmIterations = 2048
+	_kekSaltSize  = 8
+	_wrapIVSize   = 8
+)
+
+var _wrapIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// KeyMaterial message of KMREQ/KMRSP
+//  0                   1                   2                   3
+//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |S|  V  |   PT  |              Sign             |   Resv1   | KK|
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                              KEKI                             |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |     Cipher    |      Auth     |       SE      |     Resv2     |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |             Resv3             |     SLen/4    |     KLen/4    |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                              Salt                             |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                          Wrapped Key                          |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type KeyMaterial struct {
+	KK     uint8  // which keys are wrapped: (01b) even, (10b) odd, (11b) both
+	Cipher uint8  // encryption cipher, only AES-CTR is supported
+	Salt   []byte // salt for key derivation and IV
+	KLen   int    // length of a stream encrypting key
+	Wrap   []byte // stream encrypting keys wrapped by KEK
+}
+
+func ParseKeyMaterial(b []byte) (*KeyMaterial, error) {
+	if len(b) < _kmHeaderSize {
+		return nil, errors.Errorf("key material size[%d] is illegal", len(b))
+	}
+	if binary.BigEndian.Uint16(b[1:3]) != _kmSign {
+		return nil, errors.New("key material sign is illegal")
+	}
+	km := new(KeyMaterial)
+	km.KK = b[3] & 0x03
+	km.Cipher = b[8]
+	sLen := int(b[14]) * 4
+	km.KLen = int(b[15]) * 4
+	keys := 1
+	if km.KK == KKBoth {
+		keys = 2
+	}
+	wLen := _wrapIVSize + keys*km.KLen
+	if len(b) < _kmHeaderSize+sLen+wLen {
+		return nil, errors.Errorf("key material size[%d] is too short", len(b))
+	}
+	km.Salt = b[_kmHeaderSize : _kmHeaderSize+sLen]
+	km.Wrap = b[_kmHeaderSize+sLen : _kmHeaderSize+sLen+wLen]
+	return km, nil
+}
+
+// Decrypter derives KEK from passphrase and unwraps stream encrypting keys
+func (km *KeyMaterial) Decrypter(passphrase string) (*Decrypter, error) {
+	if km.Cipher != _kmCipherCTR {
+		return nil, errors.Errorf("cipher[%d] is not supported", km.Cipher)
+	}
+	if len(km.Salt) < _kekSaltSize {
+		return nil, errors.Errorf("salt size[%d] is illegal", len(km.Salt))
+	}
+	kek := PBKDF2([]byte(passphrase), km.Salt[len(km.Salt)-_kekSaltSize:], _kmIterations, km.KLen)
+	return km.decrypter(kek)
+}
+
+// decrypter unwraps stream encrypting keys by kek
+func (km *KeyMaterial) decrypter(kek []byte) (*Decrypter, error) {
+	keys, err := UnwrapKey(kek, km.Wrap)
+	if err != nil {
+		return nil, err
+	}
+
+	d := new(Decrypter)
+	salt := append([]byte(nil), km.Salt...)
+	for i, kk := range []uint8{KKEven, KKOdd} {
+		if km.KK&kk == 0 {
+			continue
+		}
+		key := keys[:km.KLen]
+		keys = keys[km.KLen:]
+		if d.blocks[i], err = aes.NewCipher(key); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		d.salts[i] = salt
+	}
+	return d, nil
+}
+
+// Decrypter decrypts payload of data packets with AES-CTR
+type Decrypter struct {
+	mu     sync.RWMutex
+	salts  [2][]byte
+	blocks [2]cipher.Block // even and odd key
+}
+
+// Update loads the keys of a KMREQ sent to refresh keys, a key absent in km is kept
+func (d *Decrypter) Update(km *KeyMaterial, passphrase string) error {
+	n, err := km.Decrypter(passphrase)
+	if err != nil {
+		return err
+	}
+	d.merge(n)
+	return nil
+}
+
+func (d *Decrypter) merge(n *Decrypter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := range n.blocks {
+		if n.blocks[i] != nil {
+			d.blocks[i], d.salts[i] = n.blocks[i], n.salts[i]
+		}
+	}
+}
+
+// Decrypt works in place and clears the KK flag of packet
+func (d *Decrypter) Decrypt(p *DataPacket) error {
+	if p.KK == KKNone {
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if p.KK == KKBoth || d.blocks[p.KK-1] == nil {
+		return errors.Errorf("no key for KK[%d]", p.KK)
+	}
+	salt := d.salts[p.KK-1]
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint32(iv[10:14], p.SequenceNum)
+	for i := 0; i < 14 && i < len(salt); i++ {
+		iv[i] ^= salt[i]
+	}
+	cipher.NewCTR(d.blocks[p.KK-1], iv).XORKeyStream(p.Content, p.Content)
+	p.KK = KKNone
+	return nil
+}
+
+// UnwrapKey implements AES key unwrap of RFC 3394
+func UnwrapKey(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, errors.Errorf("wrapped key size[%d] is illegal", len(wrapped))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	n := len(wrapped)/8 - 1
+	a := make([]byte, 8)
+	copy(a, wrapped[:8])
+	r := make([]byte, n*8)
+	copy(r, wrapped[8:])
+
+	b := make([]byte, aes.BlockSize)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(a)^t)
+			copy(b[8:], r[(i-1)*8:i*8])
+			block.Decrypt(b, b)
+			copy(a, b[:8])
+			copy(r[(i-1)*8:i*8], b[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(a, _wrapIV) != 1 {
+		return nil, errors.New("key unwrap integrity check fail")
+	}
+	return r, nil
+}
+
+// PBKDF2 with HMAC-SHA1 of RFC 2898
+func PBKDF2(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha1.New, password)
+	key := make([]byte, 0, keyLen+sha1.Size)
+	u := make([]byte, sha1.Size)
+	t := make([]byte, sha1.Size)
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		_ = binary.Write(prf, binary.BigEndian, block)
+		u = prf.Sum(u[:0])
+		copy(t, u)
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for k := range t {
+				t[k] ^= u[k]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
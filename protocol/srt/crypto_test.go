@@ -0,0 +1,84 @@
+package srt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 6070 test vector
+	key := PBKDF2([]byte("password"), []byte("salt"), 2, 20)
+	if hex.EncodeToString(key) != "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957" {
+		t.Errorf("unexpected key: %x", key)
+	}
+}
+
+func TestUnwrapKey(t *testing.T) {
+	// RFC 3394 test vector
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F")
+	wrapped, _ := hex.DecodeString("1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5")
+	key, err := UnwrapKey(kek, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(key) != "00112233445566778899aabbccddeeff" {
+		t.Errorf("unexpected key: %x", key)
+	}
+	wrapped[0] ^= 1
+	if _, err = UnwrapKey(kek, wrapped); err == nil {
+		t.Error("corrupted key should fail")
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	key, _ := hex.DecodeString("00112233445566778899aabbccddeeff")
+	salt := bytes.Repeat([]byte{0x5A}, 16)
+	block, _ := aes.NewCipher(key)
+	d := new(Decrypter)
+	d.blocks[0], d.salts[0] = block, salt
+
+	plain := bytes.Repeat([]byte{0x47}, 188)
+	iv := make([]byte, aes.BlockSize)
+	iv[13] = 9
+	for i := 0; i < 14; i++ {
+		iv[i] ^= salt[i]
+	}
+	content := make([]byte, len(plain))
+	cipher.NewCTR(block, iv).XORKeyStream(content, plain)
+
+	p := &DataPacket{SequenceNum: 9, KK: KKEven, Content: content}
+	if err := d.Decrypt(p); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.Content, plain) || p.KK != KKNone {
+		t.Error("decrypt fail")
+	}
+}
+
+func TestRekey(t *testing.T) {
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F")
+	wrapped, _ := hex.DecodeString("1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5")
+	salt := bytes.Repeat([]byte{0x5A}, 16)
+	d, err := (&KeyMaterial{KK: KKEven, Salt: salt, KLen: 16, Wrap: wrapped}).decrypter(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Decrypt(&DataPacket{KK: KKOdd}); err == nil {
+		t.Error("odd key is not loaded yet")
+	}
+
+	// the odd key comes in a refresh, the even one is kept
+	n, err := (&KeyMaterial{KK: KKOdd, Salt: salt, KLen: 16, Wrap: wrapped}).decrypter(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.merge(n)
+	for _, kk := range []uint8{KKEven, KKOdd} {
+		if err = d.Decrypt(&DataPacket{KK: kk, Content: make([]byte, 188)}); err != nil {
+			t.Errorf("decrypt by KK[%d]: %v", kk, err)
+		}
+	}
+}
@@ -3,7 +3,9 @@ package srt
 import (
 	"bytes"
 	"encoding/binary"
+	"github.com/beleege/gosrt/util/buffer"
 	"github.com/beleege/gosrt/util/codec"
+	"github.com/beleege/gosrt/util/seqno"
 	"time"
 )
 
@@ -67,6 +69,23 @@ type DataPacket struct {
 	R           bool   // Retransmitted Packet Flag: (0b) first, (1b) retransmitted
 	MsgNum      uint32 // The sequential number of consecutive data packets that form a message (see PP field)
 	Content     []byte // The payload of the data packet
+
+	// Buf holds Content if it is pooled, nil otherwise
+	Buf *buffer.Buffer
+}
+
+// Retain adds a holder of Content, it must be paired with Release
+func (p *DataPacket) Retain() {
+	if p.Buf != nil {
+		p.Buf.Retain()
+	}
+}
+
+// Release drops a holder of Content, Content must not be used by the caller afterwards
+func (p *DataPacket) Release() {
+	if p.Buf != nil {
+		p.Buf.Release()
+	}
 }
 
 // ControlPacket structure
@@ -115,6 +134,40 @@ func (cp *ControlPacket) Ack(no, sid, seq, rtt, rttDiff, leftMFW, pRate, bandwid
 	return buf.Bytes()
 }
 
+// NAck loss list is already encoded, a range start is marked by LossRangeFlag
+func (cp *ControlPacket) NAck(t *time.Time, sid uint32, loss []uint32) []byte {
+	buf := cp.header(t, uint32(0), sid)
+	for _, l := range loss {
+		_ = binary.Write(buf, binary.BigEndian, l)
+	}
+	return buf.Bytes()
+}
+
+func (cp *ControlPacket) AckAck(t *time.Time, no, sid uint32) []byte {
+	buf := cp.header(t, no, sid)
+	_ = binary.Write(buf, binary.BigEndian, uint32(0))
+	return buf.Bytes()
+}
+
+func (cp *ControlPacket) Keepalive(t *time.Time, sid uint32) []byte {
+	buf := cp.header(t, uint32(0), sid)
+	_ = binary.Write(buf, binary.BigEndian, uint32(0))
+	return buf.Bytes()
+}
+
+func (cp *ControlPacket) Handshake(t *time.Time, sid uint32, h *HandShakeCIF) []byte {
+	buf := cp.header(t, uint32(0), sid)
+	buf.Write(h.Bytes())
+	return buf.Bytes()
+}
+
+// KeyMaterial carries KMREQ or KMRSP of Subtype in a user defined packet to refresh keys
+func (cp *ControlPacket) KeyMaterial(t *time.Time, sid uint32, km []byte) []byte {
+	buf := cp.header(t, uint32(0), sid)
+	buf.Write(km)
+	return buf.Bytes()
+}
+
 func (cp *ControlPacket) Shutdown(t *time.Time, sid uint32) []byte {
 	buf := cp.header(t, uint32(0), sid)
 	_ = binary.Write(buf, binary.BigEndian, uint32(0))
@@ -198,6 +251,59 @@ type HSExtStreamID struct {
 	StreamID string
 }
 
+func (p *DataPacket) Bytes() []byte {
+	b := make([]byte, 16+len(p.Content))
+	p.Encode(b)
+	return b
+}
+
+// Encode writes packet into b, returns the length written
+func (p *DataPacket) Encode(b []byte) int {
+	flags := uint32(p.PP&0x03)<<30 | uint32(p.KK&0x03)<<27 | p.MsgNum&0x03FFFFFF
+	if p.O {
+		flags |= 0x20000000
+	}
+	if p.R {
+		flags |= 0x04000000
+	}
+	d := codec.Encode32u(b, p.SequenceNum&0x7FFFFFFF)
+	d = codec.Encode32u(d, flags)
+	d = codec.Encode32u(d, p.Timestamp)
+	d = codec.Encode32u(d, p.SocketID)
+	return 16 + copy(d, p.Content)
+}
+
+func (h *HandShakeCIF) Bytes() []byte {
+	b := make([]byte, 48+len(h.HSExt))
+	d := codec.Encode32u(b, h.Version)
+	d = codec.Encode16u(d, h.Encryption)
+	d = codec.Encode16u(d, h.Extension)
+	d = codec.Encode32u(d, h.InitSequenceNum)
+	d = codec.Encode32u(d, h.MTU)
+	d = codec.Encode32u(d, h.MFW)
+	d = codec.Encode32u(d, h.HType)
+	d = codec.Encode32u(d, h.SocketID)
+	d = codec.Encode32u(d, h.Cookie)
+	copy(d[:16], h.PeerIP)
+	copy(d[16:], h.HSExt)
+	return b
+}
+
+func (e *HSExtension) Bytes() []byte {
+	b := make([]byte, 4+len(e.EContent))
+	d := codec.Encode16u(b, e.EType)
+	d = codec.Encode16u(d, uint16(len(e.EContent)/4))
+	copy(d, e.EContent)
+	return b
+}
+
+// ParseDPacketBuffer parses b held by buf, the packet borrows buf and has to Retain it to keep Content
+func ParseDPacketBuffer(buf *buffer.Buffer, b []byte) *DataPacket {
+	p := ParseDPacket(b)
+	p.Buf = buf
+	return p
+}
+
 func ParseDPacket(b []byte) *DataPacket {
 	p := new(DataPacket)
 	b = codec.Decode32u(b, &p.SequenceNum)
@@ -206,7 +312,7 @@ func ParseDPacket(b []byte) *DataPacket {
 	p.KK = (b[0] & 0x18) >> 3
 	p.R = (b[0] & 0x04) > 0
 	b = codec.Decode32u(b, &p.MsgNum)
-	p.MsgNum &= 0x11FFFFFF
+	p.MsgNum &= 0x03FFFFFF
 	b = codec.Decode32u(b, &p.Timestamp)
 	b = codec.Decode32u(b, &p.SocketID)
 	p.Content = b[:]
@@ -250,3 +356,76 @@ func ParseHExtension(b []byte) *HSExtTSBPD {
 	b = codec.Decode16u(b, &h.RxDelay)
 	return h
 }
+
+func (h *HSExtTSBPD) Encode(b []byte) []byte {
+	b = codec.Encode32u(b, h.SRTVersion)
+	b = codec.Encode32u(b, h.SRTFlags)
+	b = codec.Encode16u(b, h.TxDelay)
+	b = codec.Encode16u(b, h.RxDelay)
+	return b
+}
+
+func (h *HSExtTSBPD) Has(flag uint32) bool {
+	return h.SRTFlags&flag != 0
+}
+
+// ACKCIF is the CIF of a full ack, fields missing in a short ack are zero
+type ACKCIF struct {
+	LastACK   uint32 // seq no of the next pkg expected by peer
+	RTT       uint32 // microseconds
+	RTTVar    uint32 // microseconds
+	Buffer    uint32 // pkts peer can still receive
+	PktRate   uint32 // pkts per second received by peer
+	Bandwidth uint32 // estimated link capacity in pkts per second
+	RecvRate  uint32 // bytes per second received by peer
+}
+
+// ParseACK decodes the CIF of an ack, a light ack has LastACK only
+func ParseACK(b []byte) *ACKCIF {
+	a := new(ACKCIF)
+	for _, f := range []*uint32{&a.LastACK, &a.RTT, &a.RTTVar, &a.Buffer, &a.PktRate, &a.Bandwidth, &a.RecvRate} {
+		if len(b) < 4 {
+			break
+		}
+		b = codec.Decode32u(b, f)
+	}
+	return a
+}
+
+// ParseLossList returns loss ranges in NAK CIF, a single loss has the same start and end, reversed ranges are ignored
+func ParseLossList(b []byte) [][2]uint32 {
+	ranges := make([][2]uint32, 0, len(b)/4)
+	for len(b) >= 4 {
+		var seq uint32
+		b = codec.Decode32u(b, &seq)
+		if seq&LossRangeFlag == 0 {
+			ranges = append(ranges, [2]uint32{seq, seq})
+		} else if len(b) >= 4 {
+			var end uint32
+			b = codec.Decode32u(b, &end)
+			seq, end = seq&^LossRangeFlag, end&^LossRangeFlag
+			if seqno.Compare(seq, end) <= 0 {
+				ranges = append(ranges, [2]uint32{seq, end})
+			}
+		}
+	}
+	return ranges
+}
+
+// ParseStreamID decodes Stream ID extension, which is sent as 32 bits words with reversed bytes
+func ParseStreamID(b []byte) string {
+	sid := make([]byte, len(b))
+	for i := 0; i+4 <= len(b); i += 4 {
+		sid[i], sid[i+1], sid[i+2], sid[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	return string(bytes.TrimRight(sid, "\x00"))
+}
+
+func EncodeStreamID(sid string) []byte {
+	b := make([]byte, (len(sid)+3)/4*4)
+	copy(b, sid)
+	for i := 0; i < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	return b
+}
@@ -16,3 +16,38 @@ func TestParseHCIF(t *testing.T) {
 	h := ParseHCIF(p.CIF)
 	t.Logf("%+v", h)
 }
+
+func TestStreamID(t *testing.T) {
+	b := EncodeStreamID("#!::r=live/cam")
+	if string(b[:4]) != "::!#" || len(b)%4 != 0 {
+		t.Errorf("unexpected encoded stream id: %q", b)
+	}
+	if sid := ParseStreamID(b); sid != "#!::r=live/cam" {
+		t.Errorf("unexpected stream id: %q", sid)
+	}
+}
+
+func TestParseLossList(t *testing.T) {
+	b := []byte{
+		0x00, 0x00, 0x00, 0x05,
+		0x80, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x09,
+		// reversed
+		0x80, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x07,
+		// end with the range flag
+		0xFF, 0xFF, 0xFF, 0xFE, 0x80, 0x00, 0x00, 0x01,
+	}
+	ranges := ParseLossList(b)
+	if len(ranges) != 3 || ranges[0] != [2]uint32{5, 5} || ranges[1] != [2]uint32{7, 9} || ranges[2] != [2]uint32{0x7FFFFFFE, 1} {
+		t.Errorf("unexpected loss ranges: %v", ranges)
+	}
+}
+
+func TestDataPacket(t *testing.T) {
+	p := &DataPacket{SequenceNum: 7, PP: 3, R: true, MsgNum: 9, Content: []byte{0x47}}
+	p.Timestamp = 100
+	p.SocketID = 5
+	d := ParseDPacket(p.Bytes())
+	if d.SequenceNum != 7 || d.PP != 3 || !d.R || d.MsgNum != 9 || d.Timestamp != 100 || d.SocketID != 5 || len(d.Content) != 1 {
+		t.Errorf("unexpected data packet: %+v", d)
+	}
+}
@@ -0,0 +1,73 @@
+package video
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrShort = errors.New("bitstream is too short")
+)
+
+// bitReader reads a RBSP in bits, the first error is kept and later reads return zero
+type bitReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func newBitReader(b []byte) *bitReader {
+	return &bitReader{b: b}
+}
+
+// u reads n bits up to 32 as an unsigned integer
+func (r *bitReader) u(n int) uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos+n > len(r.b)*8 {
+		r.err = ErrShort
+		return 0
+	}
+	var v uint32
+	for i := 0; i < n; i++ {
+		v = v<<1 | uint32(r.b[r.pos>>3]>>(7-uint(r.pos&7))&1)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) flag() bool {
+	return r.u(1) == 1
+}
+
+func (r *bitReader) skip(n int) {
+	for n > 32 {
+		r.u(32)
+		n -= 32
+	}
+	r.u(n)
+}
+
+// ue reads an unsigned Exp-Golomb code
+func (r *bitReader) ue() uint32 {
+	zeros := 0
+	for r.u(1) == 0 {
+		if r.err != nil {
+			return 0
+		}
+		if zeros++; zeros > 31 {
+			r.err = errors.New("exp-golomb code is too long")
+			return 0
+		}
+	}
+	return 1<<uint(zeros) - 1 + r.u(zeros)
+}
+
+// se reads a signed Exp-Golomb code
+func (r *bitReader) se() int32 {
+	k := r.ue()
+	if k&1 == 1 {
+		return int32((k + 1) / 2)
+	}
+	return -int32(k / 2)
+}
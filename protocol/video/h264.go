@@ -0,0 +1,225 @@
+package video
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	H264NALSlice = 1
+	H264NALIDR   = 5
+	H264NALSEI   = 6
+	H264NALSPS   = 7
+	H264NALPPS   = 8
+	H264NALAUD   = 9
+)
+
+// H264SPS is the sequence parameter set of H.264
+type H264SPS struct {
+	ID              uint32
+	ProfileIDC      uint8
+	Constraints     uint8
+	LevelIDC        uint8
+	ChromaFormatIDC uint32
+	Width           int
+	Height          int
+	// frames are coded as fields or MBAFF if it is false
+	FrameMBSOnly bool
+	FrameRate    float64
+}
+
+// Codec returns the RFC 6381 codec string, avc1.PPCCLL
+func (s *H264SPS) Codec() string {
+	return fmt.Sprintf("avc1.%02x%02x%02x", s.ProfileIDC, s.Constraints, s.LevelIDC)
+}
+
+// ParseH264SPS parses SPS of nal with header
+func ParseH264SPS(nal []byte) (*H264SPS, error) {
+	if len(nal) < 4 || nal[0]&0x1F != H264NALSPS {
+		return nil, errors.New("not a H.264 SPS")
+	}
+	r := newBitReader(RBSP(nal[1:]))
+	s := new(H264SPS)
+	s.ProfileIDC = uint8(r.u(8))
+	s.Constraints = uint8(r.u(8))
+	s.LevelIDC = uint8(r.u(8))
+	s.ID = r.ue()
+	s.ChromaFormatIDC = 1
+	switch s.ProfileIDC {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		s.ChromaFormatIDC = r.ue()
+		separate := false
+		if s.ChromaFormatIDC == 3 {
+			separate = r.flag()
+		}
+		if separate {
+			// colour planes are coded as monochrome pictures
+			s.ChromaFormatIDC = 0
+		}
+		// bit depth of luma and chroma, qpprime_y_zero_transform_bypass_flag
+		r.ue()
+		r.ue()
+		r.skip(1)
+		if r.flag() {
+			n := 8
+			if s.ChromaFormatIDC == 3 {
+				n = 12
+			}
+			for i := 0; i < n; i++ {
+				if !r.flag() {
+					continue
+				}
+				if i < 6 {
+					scalingList(r, 16)
+				} else {
+					scalingList(r, 64)
+				}
+			}
+		}
+	}
+	// log2_max_frame_num_minus4
+	r.ue()
+	switch r.ue() {
+	case 0:
+		// log2_max_pic_order_cnt_lsb_minus4
+		r.ue()
+	case 1:
+		r.skip(1)
+		r.se()
+		r.se()
+		for n := r.ue(); n > 0 && r.err == nil; n-- {
+			r.se()
+		}
+	}
+	// max_num_ref_frames, gaps_in_frame_num_value_allowed_flag
+	r.ue()
+	r.skip(1)
+	w := int(r.ue()) + 1
+	h := int(r.ue()) + 1
+	s.FrameMBSOnly = r.flag()
+	if !s.FrameMBSOnly {
+		// mb_adaptive_frame_field_flag
+		r.skip(1)
+	}
+	// direct_8x8_inference_flag
+	r.skip(1)
+	fields := 1
+	if !s.FrameMBSOnly {
+		fields = 2
+	}
+	s.Width = w * 16
+	s.Height = fields * h * 16
+	if r.flag() {
+		left, right, top, bottom := int(r.ue()), int(r.ue()), int(r.ue()), int(r.ue())
+		cropX, cropY := 1, fields
+		switch s.ChromaFormatIDC {
+		case 1:
+			cropX, cropY = 2, 2*fields
+		case 2:
+			cropX = 2
+		}
+		s.Width -= cropX * (left + right)
+		s.Height -= cropY * (top + bottom)
+	}
+	if r.flag() {
+		s.FrameRate = h264Timing(r)
+	}
+	if r.err != nil {
+		return nil, errors.Wrap(r.err, "parse H.264 SPS")
+	}
+	return s, nil
+}
+
+// h264Timing reads VUI to timing info, returns frame rate or zero if it is absent
+func h264Timing(r *bitReader) float64 {
+	if r.flag() {
+		// aspect_ratio_idc of Extended_SAR
+		if r.u(8) == 255 {
+			r.skip(32)
+		}
+	}
+	if r.flag() {
+		// overscan_appropriate_flag
+		r.skip(1)
+	}
+	if r.flag() {
+		r.skip(4)
+		if r.flag() {
+			r.skip(24)
+		}
+	}
+	if r.flag() {
+		r.ue()
+		r.ue()
+	}
+	if !r.flag() {
+		return 0
+	}
+	units := r.u(32)
+	scale := r.u(32)
+	if r.err != nil || units == 0 {
+		return 0
+	}
+	// a frame is two ticks of field
+	return float64(scale) / float64(2*units)
+}
+
+func scalingList(r *bitReader, size int) {
+	last, next := int32(8), int32(8)
+	for j := 0; j < size && r.err == nil; j++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// H264SliceType returns the frame type of slice nal
+func H264SliceType(nal []byte) FrameType {
+	if len(nal) < 2 {
+		return FrameUnknown
+	}
+	r := newBitReader(RBSP(nal[1:]))
+	// first_mb_in_slice
+	r.ue()
+	t := r.ue()
+	if r.err != nil {
+		return FrameUnknown
+	}
+	switch t % 5 {
+	case 0, 3:
+		return FrameP
+	case 1:
+		return FrameB
+	default:
+		return FrameI
+	}
+}
+
+func (p *Parser) parseH264(nal []byte) (Frame, bool) {
+	if len(nal) < 1 {
+		return Frame{}, false
+	}
+	switch nal[0] & 0x1F {
+	case H264NALSPS:
+		if s, err := ParseH264SPS(nal); err == nil {
+			p.info = &Info{
+				Codec:      s.Codec(),
+				Profile:    s.ProfileIDC,
+				Level:      s.LevelIDC,
+				Width:      s.Width,
+				Height:     s.Height,
+				FrameRate:  s.FrameRate,
+				Interlaced: !s.FrameMBSOnly,
+			}
+		}
+	case H264NALIDR:
+		return Frame{Key: true, Type: H264SliceType(nal)}, true
+	case H264NALSlice:
+		return Frame{Type: H264SliceType(nal)}, true
+	}
+	return Frame{}, false
+}
@@ -0,0 +1,420 @@
+package video
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	H265NALBLAWLP   = 16
+	H265NALIDRWRADL = 19
+	H265NALIDRNLP   = 20
+	H265NALCRA      = 21
+	H265NALVPS      = 32
+	H265NALSPS      = 33
+	H265NALPPS      = 34
+	H265NALAUD      = 35
+)
+
+// H265ProfileTierLevel is the general profile, tier and level of H.265
+type H265ProfileTierLevel struct {
+	ProfileSpace uint8
+	Tier         uint8
+	ProfileIDC   uint8
+	Compat       uint32
+	// progressive_source_flag first, 48 bits
+	Constraints [6]byte
+	LevelIDC    uint8
+}
+
+// Interlaced tells general_interlaced_source_flag
+func (p *H265ProfileTierLevel) Interlaced() bool {
+	return p.Constraints[0]&0x40 != 0
+}
+
+// H265VPS is the video parameter set of H.265
+type H265VPS struct {
+	ID        uint8
+	PTL       H265ProfileTierLevel
+	FrameRate float64
+}
+
+// H265SPS is the sequence parameter set of H.265
+type H265SPS struct {
+	ID              uint32
+	VPSID           uint8
+	PTL             H265ProfileTierLevel
+	ChromaFormatIDC uint32
+	Width           int
+	Height          int
+	// pictures are fields by VUI
+	FieldSeq  bool
+	FrameRate float64
+}
+
+// Codec returns the RFC 6381 codec string, as hvc1.1.6.L93.B0
+func (s *H265SPS) Codec() string {
+	p := &s.PTL
+	var b strings.Builder
+	b.WriteString("hvc1.")
+	if p.ProfileSpace > 0 {
+		b.WriteByte('A' + p.ProfileSpace - 1)
+	}
+	// compatibility flags in reverse bit order
+	var compat uint32
+	for i := uint(0); i < 32; i++ {
+		compat |= (p.Compat >> i & 1) << (31 - i)
+	}
+	tier := 'L'
+	if p.Tier == 1 {
+		tier = 'H'
+	}
+	fmt.Fprintf(&b, "%d.%x.%c%d", p.ProfileIDC, compat, tier, p.LevelIDC)
+	// trailing zero bytes of constraints are omitted
+	n := len(p.Constraints)
+	for n > 0 && p.Constraints[n-1] == 0 {
+		n--
+	}
+	for _, c := range p.Constraints[:n] {
+		fmt.Fprintf(&b, ".%X", c)
+	}
+	return b.String()
+}
+
+func parsePTL(r *bitReader, ptl *H265ProfileTierLevel, maxSubLayersMinus1 int) {
+	ptl.ProfileSpace = uint8(r.u(2))
+	ptl.Tier = uint8(r.u(1))
+	ptl.ProfileIDC = uint8(r.u(5))
+	ptl.Compat = r.u(32)
+	for i := range ptl.Constraints {
+		ptl.Constraints[i] = uint8(r.u(8))
+	}
+	ptl.LevelIDC = uint8(r.u(8))
+
+	profile := make([]bool, maxSubLayersMinus1)
+	level := make([]bool, maxSubLayersMinus1)
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		profile[i] = r.flag()
+		level[i] = r.flag()
+	}
+	if maxSubLayersMinus1 > 0 {
+		r.skip(2 * (8 - maxSubLayersMinus1))
+	}
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		if profile[i] {
+			r.skip(88)
+		}
+		if level[i] {
+			r.skip(8)
+		}
+	}
+}
+
+// ParseH265VPS parses VPS of nal with header
+func ParseH265VPS(nal []byte) (*H265VPS, error) {
+	if len(nal) < 3 || (nal[0]>>1)&0x3F != H265NALVPS {
+		return nil, errors.New("not a H.265 VPS")
+	}
+	r := newBitReader(RBSP(nal[2:]))
+	v := new(H265VPS)
+	v.ID = uint8(r.u(4))
+	// base layer flags and vps_max_layers_minus1
+	r.skip(8)
+	sub := int(r.u(3))
+	// temporal id nesting and reserved 0xffff
+	r.skip(17)
+	parsePTL(r, &v.PTL, sub)
+	first := sub
+	if r.flag() {
+		first = 0
+	}
+	for i := first; i <= sub && r.err == nil; i++ {
+		r.ue()
+		r.ue()
+		r.ue()
+	}
+	maxLayerID := int(r.u(6))
+	for n := r.ue(); n > 0 && r.err == nil; n-- {
+		r.skip(maxLayerID + 1)
+	}
+	if r.flag() {
+		units := r.u(32)
+		scale := r.u(32)
+		if units > 0 {
+			v.FrameRate = float64(scale) / float64(units)
+		}
+	}
+	if r.err != nil {
+		return nil, errors.Wrap(r.err, "parse H.265 VPS")
+	}
+	return v, nil
+}
+
+// ParseH265SPS parses SPS of nal with header
+func ParseH265SPS(nal []byte) (*H265SPS, error) {
+	if len(nal) < 3 || (nal[0]>>1)&0x3F != H265NALSPS {
+		return nil, errors.New("not a H.265 SPS")
+	}
+	r := newBitReader(RBSP(nal[2:]))
+	s := new(H265SPS)
+	s.VPSID = uint8(r.u(4))
+	sub := int(r.u(3))
+	r.skip(1)
+	parsePTL(r, &s.PTL, sub)
+	s.ID = r.ue()
+	s.ChromaFormatIDC = r.ue()
+	if s.ChromaFormatIDC == 3 && r.flag() {
+		// separate colour planes
+		s.ChromaFormatIDC = 0
+	}
+	s.Width = int(r.ue())
+	s.Height = int(r.ue())
+	if r.flag() {
+		left, right, top, bottom := int(r.ue()), int(r.ue()), int(r.ue()), int(r.ue())
+		subW, subH := 1, 1
+		switch s.ChromaFormatIDC {
+		case 1:
+			subW, subH = 2, 2
+		case 2:
+			subW = 2
+		}
+		s.Width -= subW * (left + right)
+		s.Height -= subH * (top + bottom)
+	}
+	// bit depth of luma and chroma
+	r.ue()
+	r.ue()
+	pocBits := int(r.ue()) + 4
+	first := sub
+	if r.flag() {
+		first = 0
+	}
+	for i := first; i <= sub && r.err == nil; i++ {
+		r.ue()
+		r.ue()
+		r.ue()
+	}
+	// coding block and transform sizes and depths
+	for i := 0; i < 6; i++ {
+		r.ue()
+	}
+	if r.flag() && r.flag() {
+		scalingListData(r)
+	}
+	// amp and sample_adaptive_offset
+	r.skip(2)
+	if r.flag() {
+		// pcm sample bit depths and sizes, loop filter
+		r.skip(8)
+		r.ue()
+		r.ue()
+		r.skip(1)
+	}
+	n := int(r.ue())
+	if n > 64 {
+		return nil, errors.New("parse H.265 SPS: too many short term ref pic sets")
+	}
+	deltas := make([]int, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		deltas[i] = stRefPicSet(r, i, n, deltas)
+	}
+	if r.flag() {
+		for n := r.ue(); n > 0 && r.err == nil; n-- {
+			r.skip(pocBits + 1)
+		}
+	}
+	// temporal mvp and strong intra smoothing
+	r.skip(2)
+	if r.flag() {
+		s.FieldSeq, s.FrameRate = h265Timing(r)
+	}
+	if r.err != nil {
+		return nil, errors.Wrap(r.err, "parse H.265 SPS")
+	}
+	return s, nil
+}
+
+func scalingListData(r *bitReader) {
+	for size := 0; size < 4; size++ {
+		step := 1
+		if size == 3 {
+			step = 3
+		}
+		for matrix := 0; matrix < 6; matrix += step {
+			if !r.flag() {
+				// scaling_list_pred_matrix_id_delta
+				r.ue()
+				continue
+			}
+			n := 1 << uint(4+size<<1)
+			if n > 64 {
+				n = 64
+			}
+			if size > 1 {
+				r.se()
+			}
+			for i := 0; i < n && r.err == nil; i++ {
+				r.se()
+			}
+		}
+	}
+}
+
+// stRefPicSet reads st_ref_pic_set of idx, returns its count of delta pocs
+func stRefPicSet(r *bitReader, idx, num int, deltas []int) int {
+	if idx != 0 && r.flag() {
+		// predicted from a previous set
+		ref := idx - 1
+		if idx == num {
+			ref = idx - int(r.ue()) - 1
+		}
+		// delta_rps_sign and abs_delta_rps_minus1
+		r.skip(1)
+		r.ue()
+		if ref < 0 || ref >= len(deltas) {
+			r.err = errors.New("illegal ref pic set")
+			return 0
+		}
+		count := 0
+		for j := 0; j <= deltas[ref] && r.err == nil; j++ {
+			used := r.flag()
+			if used || r.flag() {
+				count++
+			}
+		}
+		return count
+	}
+	neg := int(r.ue())
+	pos := int(r.ue())
+	for i := 0; i < neg+pos && r.err == nil; i++ {
+		r.ue()
+		r.skip(1)
+	}
+	return neg + pos
+}
+
+// h265Timing reads VUI to timing info, returns field_seq_flag and frame rate
+func h265Timing(r *bitReader) (bool, float64) {
+	if r.flag() {
+		if r.u(8) == 255 {
+			r.skip(32)
+		}
+	}
+	if r.flag() {
+		r.skip(1)
+	}
+	if r.flag() {
+		r.skip(4)
+		if r.flag() {
+			r.skip(24)
+		}
+	}
+	if r.flag() {
+		r.ue()
+		r.ue()
+	}
+	// neutral_chroma_indication_flag
+	r.skip(1)
+	fieldSeq := r.flag()
+	// frame_field_info_present_flag
+	r.skip(1)
+	if r.flag() {
+		for i := 0; i < 4; i++ {
+			r.ue()
+		}
+	}
+	if !r.flag() {
+		return fieldSeq, 0
+	}
+	units := r.u(32)
+	scale := r.u(32)
+	if r.err != nil || units == 0 {
+		return fieldSeq, 0
+	}
+	return fieldSeq, float64(scale) / float64(units)
+}
+
+// H265SliceType returns the frame type of the first slice segment of a picture,
+// extra is num_extra_slice_header_bits of its PPS
+func H265SliceType(nal []byte, extra func(pps uint32) (uint8, bool)) FrameType {
+	if len(nal) < 3 {
+		return FrameUnknown
+	}
+	t := (nal[0] >> 1) & 0x3F
+	r := newBitReader(RBSP(nal[2:]))
+	if !r.flag() {
+		// dependent or later slice segment needs picture size to parse
+		return FrameUnknown
+	}
+	if t >= H265NALBLAWLP && t <= 23 {
+		// no_output_of_prior_pics_flag
+		r.skip(1)
+	}
+	n, ok := extra(r.ue())
+	if !ok {
+		return FrameUnknown
+	}
+	r.skip(int(n))
+	st := r.ue()
+	if r.err != nil {
+		return FrameUnknown
+	}
+	switch st {
+	case 0:
+		return FrameB
+	case 1:
+		return FrameP
+	case 2:
+		return FrameI
+	}
+	return FrameUnknown
+}
+
+func (p *Parser) parseH265(nal []byte) (Frame, bool) {
+	if len(nal) < 2 {
+		return Frame{}, false
+	}
+	t := (nal[0] >> 1) & 0x3F
+	switch {
+	case t == H265NALVPS:
+		if v, err := ParseH265VPS(nal); err == nil {
+			p.vpsRate = v.FrameRate
+		}
+	case t == H265NALSPS:
+		if s, err := ParseH265SPS(nal); err == nil {
+			rate := s.FrameRate
+			if rate == 0 {
+				rate = p.vpsRate
+			}
+			p.info = &Info{
+				Codec:      s.Codec(),
+				Profile:    s.PTL.ProfileIDC,
+				Level:      s.PTL.LevelIDC,
+				Width:      s.Width,
+				Height:     s.Height,
+				FrameRate:  rate,
+				Interlaced: s.FieldSeq || s.PTL.Interlaced(),
+			}
+		}
+	case t == H265NALPPS:
+		r := newBitReader(RBSP(nal[2:]))
+		id := r.ue()
+		// sps id, dependent_slice_segments_enabled_flag and output_flag_present_flag
+		r.ue()
+		r.skip(2)
+		n := uint8(r.u(3))
+		if r.err == nil {
+			p.pps[id] = n
+		}
+	case t < 32:
+		// VCL
+		ft := H265SliceType(nal, func(id uint32) (uint8, bool) {
+			n, ok := p.pps[id]
+			return n, ok
+		})
+		return Frame{Key: t >= H265NALBLAWLP && t <= H265NALCRA, Type: ft}, true
+	}
+	return Frame{}, false
+}
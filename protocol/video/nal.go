@@ -0,0 +1,142 @@
+package video
+
+import (
+	"fmt"
+)
+
+// FrameType is the slice type of a picture
+type FrameType int
+
+const (
+	FrameUnknown FrameType = iota
+	FrameI
+	FrameP
+	FrameB
+)
+
+var _frameTypeNames = [...]string{"unknown", "I", "P", "B"}
+
+func (t FrameType) String() string {
+	if int(t) < len(_frameTypeNames) {
+		return _frameTypeNames[t]
+	}
+	return fmt.Sprintf("FrameType(%d)", int(t))
+}
+
+// Frame describes the picture of an access unit
+type Frame struct {
+	// IDR picture of H.264 or IRAP picture of H.265, decoding can start from it
+	Key  bool
+	Type FrameType
+}
+
+// Info describes a video stream from its parameter sets
+type Info struct {
+	// RFC 6381 codec string as in the HLS CODECS attribute
+	Codec      string
+	Profile    uint8
+	Level      uint8
+	Width      int
+	Height     int
+	FrameRate  float64 // zero if timing info is absent
+	Interlaced bool
+}
+
+// SplitNALUnits returns the NAL units of Annex B byte stream b without start codes
+func SplitNALUnits(b []byte) [][]byte {
+	var list [][]byte
+	start := -1
+	for i := 0; i+2 < len(b); {
+		if b[i] != 0 || b[i+1] != 0 || b[i+2] != 1 {
+			i++
+			continue
+		}
+		if start >= 0 {
+			end := i
+			// zero of a 4 bytes start code and trailing zeros belong to no NAL unit
+			for end > start && b[end-1] == 0 {
+				end--
+			}
+			if end > start {
+				list = append(list, b[start:end])
+			}
+		}
+		i += 3
+		start = i
+	}
+	if start >= 0 && start < len(b) {
+		list = append(list, b[start:])
+	}
+	return list
+}
+
+// RBSP returns nal with emulation prevention bytes removed, nal is not copied if it has none
+func RBSP(nal []byte) []byte {
+	var out []byte
+	zeros, last := 0, 0
+	for i, v := range nal {
+		if zeros >= 2 && v == 3 {
+			if out == nil {
+				out = make([]byte, 0, len(nal))
+			}
+			out = append(out, nal[last:i]...)
+			last = i + 1
+			zeros = 0
+			continue
+		}
+		if v == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	if out == nil {
+		return nal
+	}
+	return append(out, nal[last:]...)
+}
+
+// Parser follows parameter sets of an H.264 or H.265 stream and tells the frames of its access units
+type Parser struct {
+	hevc bool
+	info *Info
+	// num_extra_slice_header_bits of H.265 PPS by id
+	pps map[uint32]uint8
+	// frame rate in H.265 VPS
+	vpsRate float64
+}
+
+func NewH264Parser() *Parser {
+	return &Parser{}
+}
+
+func NewH265Parser() *Parser {
+	return &Parser{hevc: true, pps: make(map[uint32]uint8)}
+}
+
+// Info returns the stream info from the last SPS, nil until it is found
+func (p *Parser) Info() *Info {
+	return p.info
+}
+
+// Parse reads access unit au of Annex B byte stream, returns the frame of its first slice
+func (p *Parser) Parse(au []byte) Frame {
+	var f Frame
+	found := false
+	for _, nal := range SplitNALUnits(au) {
+		var (
+			vf  Frame
+			vcl bool
+		)
+		if p.hevc {
+			vf, vcl = p.parseH265(nal)
+		} else {
+			vf, vcl = p.parseH264(nal)
+		}
+		if vcl && !found {
+			f = vf
+			found = true
+		}
+	}
+	return f
+}
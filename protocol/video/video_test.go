@@ -0,0 +1,101 @@
+package video
+
+import (
+	"bytes"
+	"testing"
+)
+
+var (
+	// x264 High@4.0 1920x1080 30fps
+	_h264SPS = []byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0xc0, 0x44, 0x00, 0x00, 0x03,
+		0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60, 0xc6, 0x58}
+	// x265 Main@3.1 1280x720 29.97fps
+	_h265SPS = []byte{0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+		0x00, 0x5d, 0xa0, 0x02, 0x80, 0x80, 0x2d, 0x16, 0x59, 0x59, 0xa4, 0x93, 0x2b, 0xc0, 0x5a, 0x70, 0x80, 0x00,
+		0x01, 0xf4, 0x80, 0x00, 0x3a, 0x98, 0x04}
+)
+
+// annexB joins nal units with start codes
+func annexB(nals ...[]byte) []byte {
+	var b []byte
+	for _, nal := range nals {
+		b = append(b, 0, 0, 0, 1)
+		b = append(b, nal...)
+	}
+	return b
+}
+
+func TestSplitNALUnits(t *testing.T) {
+	b := []byte{0, 0, 0, 1, 0x09, 0xF0, 0, 0, 1, 0x65, 0x88, 0, 0, 0, 0, 1, 0x41}
+	list := SplitNALUnits(b)
+	if len(list) != 3 || !bytes.Equal(list[0], []byte{0x09, 0xF0}) || !bytes.Equal(list[1], []byte{0x65, 0x88}) ||
+		!bytes.Equal(list[2], []byte{0x41}) {
+		t.Errorf("unexpected nal units: %x", list)
+	}
+}
+
+func TestRBSP(t *testing.T) {
+	if b := RBSP([]byte{1, 0, 0, 3, 1, 0, 0, 3, 0, 3}); !bytes.Equal(b, []byte{1, 0, 0, 1, 0, 0, 0, 3}) {
+		t.Errorf("unexpected rbsp: %x", b)
+	}
+	nal := []byte{1, 0, 0, 1}
+	if b := RBSP(nal); &b[0] != &nal[0] {
+		t.Error("nal without emulation prevention should not be copied")
+	}
+}
+
+func TestH264(t *testing.T) {
+	s, err := ParseH264SPS(_h264SPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Width != 1920 || s.Height != 1080 || s.FrameRate != 30 || !s.FrameMBSOnly || s.Codec() != "avc1.640028" {
+		t.Errorf("unexpected sps: %+v %s", s, s.Codec())
+	}
+
+	p := NewH264Parser()
+	if f := p.Parse(annexB([]byte{0x09, 0xF0}, _h264SPS, []byte{0x68, 0xEB}, []byte{0x65, 0x88, 0x80})); !f.Key || f.Type != FrameI {
+		t.Errorf("unexpected IDR frame: %+v", f)
+	}
+	if info := p.Info(); info == nil || info.Codec != "avc1.640028" || info.Width != 1920 || info.Interlaced {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if f := p.Parse(annexB([]byte{0x41, 0x9A})); f.Key || f.Type != FrameP {
+		t.Errorf("unexpected P frame: %+v", f)
+	}
+	if f := p.Parse(annexB([]byte{0x01, 0x9E})); f.Key || f.Type != FrameB {
+		t.Errorf("unexpected B frame: %+v", f)
+	}
+}
+
+func TestH265(t *testing.T) {
+	s, err := ParseH265SPS(_h265SPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Width != 1280 || s.Height != 720 || s.PTL.ProfileIDC != 1 || s.PTL.LevelIDC != 93 || s.Codec() != "hvc1.1.6.L93.90" {
+		t.Errorf("unexpected sps: %+v %s", s, s.Codec())
+	}
+	if s.FrameRate < 29.97 || s.FrameRate > 29.98 {
+		t.Errorf("unexpected frame rate: %f", s.FrameRate)
+	}
+
+	p := NewH265Parser()
+	// slice type is unknown before PPS
+	if f := p.Parse(annexB(_h265SPS, []byte{0x26, 0x01, 0xAC})); !f.Key || f.Type != FrameUnknown {
+		t.Errorf("unexpected IDR frame: %+v", f)
+	}
+	pps := []byte{0x44, 0x01, 0xC1, 0x72}
+	if f := p.Parse(annexB(pps, []byte{0x26, 0x01, 0xAC})); !f.Key || f.Type != FrameI {
+		t.Errorf("unexpected IDR frame: %+v", f)
+	}
+	if info := p.Info(); info == nil || info.Width != 1280 || info.Height != 720 || info.Interlaced {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if f := p.Parse(annexB([]byte{0x02, 0x01, 0xD0})); f.Key || f.Type != FrameP {
+		t.Errorf("unexpected P frame: %+v", f)
+	}
+	if f := p.Parse(annexB([]byte{0x00, 0x01, 0xE0})); f.Key || f.Type != FrameB {
+		t.Errorf("unexpected B frame: %+v", f)
+	}
+}
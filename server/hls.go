@@ -1,24 +1,30 @@
 package server
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"net"
 	"net/http"
 	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/beleege/gosrt/config"
-	"github.com/beleege/gosrt/core/selector"
+	"github.com/beleege/gosrt/core/session"
 	"github.com/beleege/gosrt/protocol/hls"
 	"github.com/beleege/gosrt/protocol/mpegts"
 	"github.com/beleege/gosrt/protocol/srt"
 	"github.com/beleege/gosrt/util/log"
+	"github.com/pkg/errors"
 )
 
 const (
-	_maxTSDuration = 5.0
+	_playlistName = "index.m3u8"
+	_masterName   = "master.m3u8"
+	_dvrName      = "dvr.m3u8"
+	_vodName      = "vod.m3u8"
+	// ts analyzer looks for the tables and streams absent while no data arrives
+	_checkPeriod = 100 * time.Millisecond
 )
 
 var (
@@ -27,39 +33,195 @@ var (
 	<allow-access-from domain="*" />
 	<allow-http-request-headers-from domain="*" headers="*"/>
 </cross-domain-policy>`
-	_tsCache = hls.NewTSCache()
 )
 
-func SetupHLSServer() {
-	log.Infof("######################## HLS Server start at port:%d #####################", config.GetHLSPort())
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", config.GetHLSPort()))
-	if err != nil {
+func (s *Server) serveHLS() {
+	log.Infof("######################## HLS Server start at %s #####################", s.listener.Addr())
+	if err := s.http.Serve(s.listener); err != nil && err != http.ErrServerClosed {
 		log.Errorf("hls server fail: %s", err.Error())
-		panic(err)
 	}
-	go start(listener)
-	// just get on session for test
-	list := selector.GetAllSession()
-	for len(list) == 0 {
-		// wait session build
-		time.Sleep(100 * time.Millisecond)
-		list = selector.GetAllSession()
+}
+
+// stream is a published srt stream with its own segments
+type stream struct {
+	name  string
+	s     *session.SRTSession
+	cache *hls.TSCache
+	ts    *mpegts.Analyzer
+	// fires the cleanup of an ended stream after retention
+	timer *time.Timer
+}
+
+// onSession publishes stream of every publishing session once it is connected, the stream id is known then
+func (s *Server) onSession(n *session.Notice) {
+	if n.Change != session.Added {
+		return
 	}
+	n.Session.Subscribe(func(e *session.Event) {
+		if e.To != session.SConnect {
+			return
+		}
+		if m := streamMode(e.Session.StreamID); m != "" && m != "publish" {
+			log.Infof("stream[%s] of mode %s is not published", e.Session.StreamID, m)
+			return
+		}
+		st := s.publish(e.Session)
+		s.writers.Add(1)
+		go func() {
+			defer s.writers.Done()
+			s.onData(st, e.Session.RecWin.ListenBatch())
+			// publisher left, segments are flushed
+			s.finish(st)
+		}()
+	})
+}
 
-	if len(list) > 0 {
-		for i := range list {
-			go onData(list[i].RecWin.ListenBatch())
+// publish creates the stream of session, a stream of the same name is taken over
+func (s *Server) publish(ss *session.SRTSession) *stream {
+	st := new(stream)
+	st.name = streamName(ss)
+	st.s = ss
+	st.cache = hls.NewSegmentCache(s.newStore(st.name, ss.Key()), s.conf.HLS.Window, s.conf.HLSDVR())
+	if s.conf.HLS.PartTarget > 0 {
+		var reports []string
+		if s.conf.HLSDVR() != 0 {
+			reports = append(reports, _dvrName)
 		}
+		st.cache.EnableParts(s.conf.HLS.PartTarget, reports...)
 	}
+	st.ts = mpegts.NewAnalyzer(func(a *mpegts.Alarm) {
+		s.onAlarm(st, a)
+	})
+
+	s.smu.Lock()
+	s.streams[st.name] = st
+	s.smu.Unlock()
+	log.Infof("stream[%s] publish playlist /%s/index.m3u8", ss.StreamID, st.name)
+	return st
 }
 
-func start(listener net.Listener) {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", handle)
-	_ = http.Serve(listener, mux)
+// newStore returns the segment store of stream, every publish of name has its own directory on disk
+func (s *Server) newStore(name, key string) hls.Store {
+	if s.conf.HLS.Store == "disk" {
+		store, err := hls.NewDiskStore(filepath.Join(s.conf.HLS.Dir, filepath.FromSlash(name), key))
+		if err == nil {
+			return store
+		}
+		log.Errorf("stream[%s] keeps segments in memory: %s", name, err.Error())
+	}
+	return hls.NewMemoryStore()
 }
 
-func handle(w http.ResponseWriter, r *http.Request) {
+// finish closes the playlists of an ended stream with a VOD playlist, it is served until retention passes
+func (s *Server) finish(st *stream) {
+	if err := st.cache.Finish(); err != nil {
+		log.Errorf("stream[%s] write vod playlist: %s", st.name, err.Error())
+	}
+	retention := s.conf.HLSRetention()
+	if retention <= 0 {
+		s.expire(st)
+		return
+	}
+	s.smu.Lock()
+	st.timer = time.AfterFunc(retention, func() {
+		s.expire(st)
+	})
+	s.smu.Unlock()
+	log.Infof("stream[%s] ended, vod playlist /%s/%s is kept for %s", st.name, st.name, _vodName, retention)
+}
+
+// expire removes stream and its segments
+func (s *Server) expire(st *stream) {
+	s.smu.Lock()
+	if st.timer != nil {
+		st.timer.Stop()
+	}
+	if s.streams[st.name] == st {
+		delete(s.streams, st.name)
+		log.Infof("stream[%s] expire playlist", st.name)
+	}
+	s.smu.Unlock()
+
+	if err := st.cache.Close(); err != nil {
+		log.Errorf("stream[%s] clean segments: %s", st.name, err.Error())
+	}
+}
+
+func (s *Server) stream(name string) *stream {
+	s.smu.RLock()
+	defer s.smu.RUnlock()
+
+	return s.streams[name]
+}
+
+// streamName returns the resource name of stream id in the access control syntax "#!::r=name,m=publish",
+// other stream ids are taken as a whole, a session without stream id is named by its socket id
+func streamName(ss *session.SRTSession) string {
+	name := ss.StreamID
+	if strings.HasPrefix(name, "#!::") {
+		for _, kv := range strings.Split(name[4:], ",") {
+			if strings.HasPrefix(kv, "r=") {
+				name = kv[2:]
+				break
+			}
+		}
+	}
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" || strings.HasPrefix(name, "#!::") {
+		name = ss.Key()
+	}
+	return name
+}
+
+// streamMode returns the mode of stream id in the access control syntax, empty if it is not given
+func streamMode(sid string) string {
+	if !strings.HasPrefix(sid, "#!::") {
+		return ""
+	}
+	for _, kv := range strings.Split(sid[4:], ",") {
+		if strings.HasPrefix(kv, "m=") {
+			return kv[2:]
+		}
+	}
+	return ""
+}
+
+// stopHLS waits for in-progress segments flushed and closes the http listener
+func (s *Server) stopHLS(ctx context.Context) error {
+	flushed := make(chan struct{})
+	go func() {
+		s.writers.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		log.Errorf("hls segments are not flushed before deadline")
+	}
+
+	// ended streams kept for retention are cleaned now
+	s.smu.RLock()
+	list := make([]*stream, 0, len(s.streams))
+	for _, st := range s.streams {
+		list = append(list, st)
+	}
+	s.smu.RUnlock()
+	for _, st := range list {
+		s.expire(st)
+	}
+
+	if err := s.http.Shutdown(ctx); err != nil {
+		if err != ctx.Err() {
+			return errors.Wrap(err, "hls server shutdown")
+		}
+		// players still holding connections are cut off
+		log.Infof("hls server closes the connections left after deadline")
+		_ = s.http.Close()
+	}
+	return nil
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("request url is %s", r.URL.Path)
 	if path.Base(r.URL.Path) == "crossdomain.xml" {
 		w.Header().Set("Content-Type", "application/xml")
@@ -67,71 +229,187 @@ func handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//p := strings.TrimLeft(r.URL.Path, "/")
+	// /<stream>/master.m3u8, /<stream>/index.m3u8, /<stream>/<n>.ts or /<stream>/<n>.<part>.ts
+	name, file := path.Split(strings.TrimPrefix(path.Clean(r.URL.Path), "/"))
+	st := s.stream(strings.TrimSuffix(name, "/"))
+	if st == nil {
+		http.NotFound(w, r)
+		return
+	}
 
-	switch path.Ext(r.URL.Path) {
-	case ".m3u8":
-		//key := strings.Split(p, path.Ext(p))[0]
-		playlist, err := _tsCache.GetPlayList()
-		if err != nil {
-			log.Debugf("get playlist error: %s", err.Error())
-			http.Error(w, err.Error(), http.StatusBadRequest)
+	switch {
+	case file == _masterName:
+		playlist, err := st.cache.GetMasterPlayList(_playlistName)
+		writePlaylist(w, playlist, err)
+	case file == _playlistName:
+		if !s.block(w, r, st) {
 			return
 		}
-		log.Debugf("************ playlist: \n%s", string(playlist))
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Content-Type", "application/x-mpegURL")
-		w.Header().Set("Content-Length", strconv.Itoa(len(playlist)))
-		_, _ = w.Write(playlist)
-	case ".ts":
-		//paths := strings.SplitN(p, "/", 3)
-		//key := paths[0] + "/" + paths[1]
-		item, err := _tsCache.GetItem(r.URL.Path)
-		log.Debugf("************ request ts with path: %s", r.URL.Path)
+		playlist, err := st.cache.GetPlayList()
+		writePlaylist(w, playlist, err)
+	case file == _dvrName:
+		if !s.block(w, r, st) {
+			return
+		}
+		playlist, err := st.cache.GetDVRPlayList()
+		writePlaylist(w, playlist, err)
+	case file == _vodName:
+		playlist, err := st.cache.GetVODPlayList()
+		writePlaylist(w, playlist, err)
+	case path.Ext(file) == ".ts":
+		// a preload hint is requested before its part is cut
+		var seq uint32
+		var part int
+		if n, _ := fmt.Sscanf(file, "%d.%d.ts", &seq, &part); n == 2 && hls.PartName(seq, part) == file {
+			if !s.wait(w, r, st, seq, part) {
+				return
+			}
+		}
+		item, err := st.cache.GetItem(file)
 		if err != nil {
 			log.Debugf("get ts item error: %s", err.Error())
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.NotFound(w, r)
 			return
 		}
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Content-Type", "video/mp2ts")
 		w.Header().Set("Content-Length", strconv.Itoa(len(item.Data)))
 		_, _ = w.Write(item.Data)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// block holds a playlist request of _HLS_msn and _HLS_part until the playlist has the segment or part,
+// it tells if the playlist is to be written
+func (s *Server) block(w http.ResponseWriter, r *http.Request, st *stream) bool {
+	q := r.URL.Query()
+	if q.Get("_HLS_msn") == "" {
+		if q.Get("_HLS_part") != "" {
+			http.Error(w, "_HLS_part without _HLS_msn", http.StatusBadRequest)
+			return false
+		}
+		return true
+	}
+	msn, err := strconv.ParseUint(q.Get("_HLS_msn"), 10, 32)
+	if err != nil {
+		http.Error(w, "illegal _HLS_msn", http.StatusBadRequest)
+		return false
+	}
+	part := -1
+	if v := q.Get("_HLS_part"); v != "" {
+		if part, err = strconv.Atoi(v); err != nil || part < 0 {
+			http.Error(w, "illegal _HLS_part", http.StatusBadRequest)
+			return false
+		}
+	}
+	return s.wait(w, r, st, uint32(msn), part)
+}
+
+// wait blocks until part of segment msn is ready for up to 3 target durations
+func (s *Server) wait(w http.ResponseWriter, r *http.Request, st *stream, msn uint32, part int) bool {
+	timeout := time.Duration(3 * s.conf.HLS.TargetDuration * float64(time.Second))
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	switch err := st.cache.Wait(ctx, msn, part); err {
+	case nil:
+		return true
+	case hls.ErrFarAhead:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case context.DeadlineExceeded:
+		http.Error(w, "segment is not ready", http.StatusServiceUnavailable)
 	}
+	// the request is canceled by player
+	return false
 }
 
-func onData(ch chan []*srt.DataPacket) {
-	var tsBuf *bytes.Buffer
-	first := -1.0
-	for data := range ch {
+func writePlaylist(w http.ResponseWriter, playlist []byte, err error) {
+	if err == hls.ErrNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Debugf("get playlist error: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	w.Header().Set("Content-Length", strconv.Itoa(len(playlist)))
+	_, _ = w.Write(playlist)
+}
+
+// onData checks every batch of stream and cuts it into segments at keyframes
+func (s *Server) onData(st *stream, ch chan []*srt.DataPacket) {
+	g := hls.NewSegmenter(s.conf.HLS.TargetDuration, st.save)
+	if s.conf.HLS.PartTarget > 0 {
+		g.OnPart(s.conf.HLS.PartTarget, st.savePart)
+	}
+	dropped := st.s.RecWin.Counters().Dropped
+	ticker := time.NewTicker(_checkPeriod)
+	defer ticker.Stop()
+	for {
+		var data []*srt.DataPacket
+		select {
+		case t := <-ticker.C:
+			st.ts.Check(t)
+			continue
+		case batch, ok := <-ch:
+			if !ok {
+				// stream is closed, flush the segment in progress
+				g.Flush()
+				return
+			}
+			data = batch
+		}
+		now := time.Now()
+		// pkgs given up by srt leave a gap in ts before this batch
+		if d := st.s.RecWin.Counters().Dropped; d != dropped {
+			dropped = d
+			st.ts.MarkLoss()
+		}
 		for i := range data {
-			if len(data[i].Content) > 0 {
-				buf := bytes.NewBuffer(data[i].Content)
-				for b := buf.Next(mpegts.TSPackageSize); len(b) == mpegts.TSPackageSize; b = buf.Next(mpegts.TSPackageSize) {
-					if d, ok := mpegts.ExtractPCR(b); ok {
-						log.Infof("get ts pcr is %f", d)
-						if first < 0 {
-							first = d
-						} else if d-first > _maxTSDuration && tsBuf != nil {
-							// build ts item FIXME replace 'test'
-							tt := time.Now().Unix()
-							key := fmt.Sprintf("/%s/%d.ts", "test", tt)
-							log.Infof("############# save item with key: %s", key)
-							_tsCache.SetItem(key, data[i].SequenceNum, d-first, tsBuf.Bytes())
-							first = d
-							tsBuf = bytes.NewBuffer(nil)
-						}
-					}
-					if tsBuf == nil {
-						tsBuf = bytes.NewBuffer(nil)
-					}
-					tsBuf.Write(b)
-				}
+			c := data[i].Content
+			for off := 0; off+mpegts.TSPackageSize <= len(c); off += mpegts.TSPackageSize {
+				st.ts.Write(c[off:off+mpegts.TSPackageSize], now)
+				g.Write(c[off : off+mpegts.TSPackageSize])
 			}
-			// reduce gc press
-			//selector.Recycle(data[i].Content)
+			// segment keeps a copy, give the buffer back
+			data[i].Release()
 			data[i].Content = nil
 		}
+		// codecs and resolution may change with parameter sets
+		st.cache.SetInfo(g.Info())
+	}
+}
+
+// onAlarm logs a ts error of stream and passes it to the alarm hooks
+func (s *Server) onAlarm(st *stream, a *mpegts.Alarm) {
+	cause := "source"
+	if a.Loss {
+		cause = "srt loss"
+	}
+	log.Infof("stream[%s] %s on pid %d by %s: %s", st.name, a.Indicator, a.PID, cause, a.Reason)
+	for _, h := range s.alarms {
+		h(st.name, a)
+	}
+}
+
+func (st *stream) save(seq uint32, duration float64, b []byte) {
+	name := fmt.Sprintf("%d.ts", seq)
+	log.Debugf("stream[%s] save segment %s of %.3fs", st.name, name, duration)
+	if err := st.cache.SetItem(name, seq, duration, b); err != nil {
+		log.Errorf("stream[%s] save segment %s: %s", st.name, name, err.Error())
+	}
+}
+
+func (st *stream) savePart(seq uint32, part int, duration float64, independent bool, b []byte) {
+	// segment buffer goes on, part keeps a copy
+	d := make([]byte, len(b))
+	copy(d, b)
+	if err := st.cache.SetPart(seq, part, duration, independent, d); err != nil {
+		log.Errorf("stream[%s] save part %d of segment %d: %s", st.name, part, seq, err.Error())
 	}
 }
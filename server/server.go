@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/beleege/gosrt/config"
+	"github.com/beleege/gosrt/core/handler"
+	"github.com/beleege/gosrt/core/session"
+	"github.com/beleege/gosrt/protocol/mpegts"
+	"github.com/beleege/gosrt/util/log"
+	"github.com/pkg/errors"
+)
+
+// AlarmHook receives a TR 101 290 error found in published stream
+type AlarmHook func(stream string, a *mpegts.Alarm)
+
+// Server is an srt listener with its hls output, instances share nothing but the logger
+type Server struct {
+	conf     *config.Config
+	shards   []*shard
+	listener net.Listener
+	chain    *handler.Chain
+	alarms   []AlarmHook
+	// published streams by name
+	smu     sync.RWMutex
+	streams map[string]*stream
+	http    *http.Server
+	// running stream writers
+	writers sync.WaitGroup
+	// running udp read loops
+	readers sync.WaitGroup
+	// closed when server is stopped
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+// New creates a server of conf, nil conf takes the default values
+func New(conf *config.Config) *Server {
+	if conf == nil {
+		conf = config.Default()
+	}
+	s := new(Server)
+	s.conf = conf
+	s.streams = make(map[string]*stream)
+	s.chain = handler.DefaultChain()
+	s.done = make(chan struct{})
+	return s
+}
+
+// Start binds listeners and serves in background, server is stopped when ctx is done
+func (s *Server) Start(ctx context.Context) error {
+	shards, err := s.listenUDP()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", s.conf.HLSAddr())
+	if err != nil {
+		for _, sh := range shards {
+			sh.close()
+		}
+		return errors.WithStack(err)
+	}
+	s.shards = shards
+	s.listener = listener
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handle)
+	s.http = &http.Server{Handler: mux}
+
+	s.serveUDP()
+	go s.serveHLS()
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = s.Stop()
+		case <-s.done:
+		}
+	}()
+	return nil
+}
+
+// Stop notifies peers, flushes outputs and closes listeners within the shutdown timeout
+func (s *Server) Stop() error {
+	s.once.Do(func() {
+		defer close(s.done)
+		if s.shards == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.conf.ShutdownTimeout())
+		defer cancel()
+
+		if err := s.stopUDP(ctx); err != nil {
+			log.Errorf("%s", err.Error())
+			s.err = err
+		}
+		if err := s.stopHLS(ctx); err != nil {
+			log.Errorf("%s", err.Error())
+			s.err = err
+		}
+	})
+	return s.err
+}
+
+// Chain returns the handler stages of server, register own stages before Start
+func (s *Server) Chain() *handler.Chain {
+	return s.chain
+}
+
+// OnAlarm registers a hook for the ts errors of published streams, register hooks before Start
+func (s *Server) OnAlarm(h AlarmHook) {
+	s.alarms = append(s.alarms, h)
+}
+
+// Done is closed when server is stopped
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Server) UDPAddr() net.Addr {
+	return s.shards[0].conn.LocalAddr()
+}
+
+func (s *Server) HLSAddr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Server) Sessions() []*session.SRTSession {
+	list := make([]*session.SRTSession, 0)
+	for _, sh := range s.shards {
+		list = append(list, sh.sel.Sessions()...)
+	}
+	return list
+}
+
+// TSStats returns the TR 101 290 errors of the ts received for stream, nil if it is not published
+func (s *Server) TSStats(stream string) *mpegts.AnalyzerStats {
+	if st := s.stream(stream); st != nil {
+		return st.ts.Stats()
+	}
+	return nil
+}
@@ -0,0 +1,367 @@
+package server
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beleege/gosrt/config"
+	"github.com/beleege/gosrt/protocol/mpegts"
+	"github.com/beleege/gosrt/srt"
+)
+
+func newTestConfig(rx uint16) *config.Config {
+	c := config.Default()
+	c.UDP.Port = 0
+	c.HLS.Server.Port = 0
+	c.SRT.Latency.RX = rx
+	c.Shutdown.Timeout = 1
+	return c
+}
+
+func TestServers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servers := []*Server{New(newTestConfig(200)), New(newTestConfig(300))}
+	for _, srv := range servers {
+		if err := srv.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	players := []*player{newPlayer(servers[0]), newPlayer(servers[1])}
+	callers := make([]*srt.Conn, len(servers))
+	for i, srv := range servers {
+		c, err := srt.Dial("udp", srv.UDPAddr().String(), &srt.Config{StreamID: "#!::r=live/test,m=publish"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		callers[i] = c
+
+		sessions := srv.Sessions()
+		if len(sessions) != 1 {
+			t.Fatalf("server %d should serve 1 session, got %d", i, len(sessions))
+		}
+		if l := sessions[0].Latency; l != srv.conf.SRT.Latency.RX {
+			t.Errorf("server %d negotiated latency %d, expected %d", i, l, srv.conf.SRT.Latency.RX)
+		}
+
+		if code := players[i].waitStatus(t, "/live/test/index.m3u8", http.StatusOK); code != http.StatusOK {
+			t.Errorf("server %d playlist status %d", i, code)
+		}
+		if code := players[i].waitStatus(t, "/live/test/master.m3u8", http.StatusOK); code != http.StatusOK {
+			t.Errorf("server %d master playlist status %d", i, code)
+		}
+	}
+
+	// stop the first one only
+	if err := players[0].stop(); err != nil {
+		t.Fatal(err)
+	}
+	_ = callers[0].SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := callers[0].Read(make([]byte, 1500)); err != io.EOF {
+		t.Errorf("peer should be shutdown, got %v", err)
+	}
+	if len(servers[1].Sessions()) != 1 {
+		t.Error("second server should not be affected")
+	}
+
+	// cancel stops the rest
+	players[1].client.CloseIdleConnections()
+	cancel()
+	select {
+	case <-servers[1].Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("server should be stopped after cancel")
+	}
+}
+
+func TestSockets(t *testing.T) {
+	conf := newTestConfig(120)
+	conf.UDP.Sockets = 4
+	srv := New(conf)
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 8
+	callers := make([]*srt.Conn, n)
+	for i := range callers {
+		c, err := srt.Dial("udp", srv.UDPAddr().String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		callers[i] = c
+	}
+	if l := len(srv.Sessions()); l != n {
+		t.Fatalf("server should serve %d sessions, got %d", n, l)
+	}
+
+	if err := srv.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range callers {
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := c.Read(make([]byte, 1500)); err != io.EOF {
+			t.Errorf("caller %d should be shutdown, got %v", i, err)
+		}
+	}
+}
+
+func TestStreams(t *testing.T) {
+	conf := newTestConfig(120)
+	conf.HLS.Retention = 0
+	srv := New(conf)
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pl := newPlayer(srv)
+	defer pl.stop()
+
+	names := []string{"#!::r=live/a,m=publish", "live/b"}
+	callers := make([]*srt.Conn, len(names))
+	for i, name := range names {
+		c, err := srt.Dial("udp", srv.UDPAddr().String(), &srt.Config{StreamID: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		callers[i] = c
+	}
+	for _, p := range []string{"/live/a/index.m3u8", "/live/b/index.m3u8"} {
+		if code := pl.waitStatus(t, p, http.StatusOK); code != http.StatusOK {
+			t.Errorf("%s status %d", p, code)
+		}
+	}
+	if code := pl.waitStatus(t, "/live/c/index.m3u8", http.StatusNotFound); code != http.StatusNotFound {
+		t.Errorf("unknown stream status %d", code)
+	}
+
+	// a player in request mode does not take over the stream
+	c, err := srt.Dial("udp", srv.UDPAddr().String(), &srt.Config{StreamID: "#!::r=live/b,m=request"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for deadline := time.Now().Add(100 * time.Millisecond); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if st := srv.stream("live/b"); st == nil || st.s.StreamID != "live/b" {
+			t.Fatal("stream is taken over by a request")
+		}
+	}
+
+	// playlist expires with its publisher
+	_ = callers[0].Close()
+	if code := pl.waitStatus(t, "/live/a/index.m3u8", http.StatusNotFound); code != http.StatusNotFound {
+		t.Errorf("expired stream status %d", code)
+	}
+	if code := pl.waitStatus(t, "/live/b/index.m3u8", http.StatusOK); code != http.StatusOK {
+		t.Errorf("other stream status %d", code)
+	}
+}
+
+func TestAlarms(t *testing.T) {
+	srv := New(newTestConfig(120))
+	alarms := make(chan *mpegts.Alarm, 16)
+	srv.OnAlarm(func(stream string, a *mpegts.Alarm) {
+		if stream == "live/bad" {
+			alarms <- a
+		}
+	})
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pl := newPlayer(srv)
+	defer pl.stop()
+
+	c, err := srt.Dial("udp", srv.UDPAddr().String(), &srt.Config{StreamID: "live/bad"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if code := pl.waitStatus(t, "/live/bad/index.m3u8", http.StatusOK); code != http.StatusOK {
+		t.Fatalf("playlist status %d", code)
+	}
+	// a packet without sync byte
+	if _, err := c.Write(make([]byte, mpegts.TSPackageSize)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case a := <-alarms:
+		if a.Indicator != mpegts.SyncByteError {
+			t.Errorf("unexpected alarm: %+v", a)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no alarm of sync byte")
+	}
+	if st := srv.TSStats("live/bad"); st == nil || st.SyncByteErrors != 1 {
+		t.Errorf("unexpected ts stats: %+v", st)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	conf := newTestConfig(120)
+	conf.HLS.Store = "disk"
+	conf.HLS.Dir = t.TempDir()
+	conf.HLS.DVR = -1
+	conf.HLS.Retention = 60
+	srv := New(conf)
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pl := newPlayer(srv)
+	defer pl.stop()
+
+	c, err := srt.Dial("udp", srv.UDPAddr().String(), &srt.Config{StreamID: "live/dvr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/live/dvr/index.m3u8", "/live/dvr/dvr.m3u8"} {
+		if code := pl.waitStatus(t, p, http.StatusOK); code != http.StatusOK {
+			t.Fatalf("%s status %d", p, code)
+		}
+	}
+	if code := pl.waitStatus(t, "/live/dvr/vod.m3u8", http.StatusNotFound); code != http.StatusNotFound {
+		t.Errorf("vod playlist of live stream status %d", code)
+	}
+
+	// stream ends as a vod for retention
+	_ = c.Close()
+	if code := pl.waitStatus(t, "/live/dvr/vod.m3u8", http.StatusOK); code != http.StatusOK {
+		t.Fatalf("vod playlist status %d", code)
+	}
+	b := pl.get(t, "/live/dvr/index.m3u8")
+	if !strings.HasSuffix(string(b), "#EXT-X-ENDLIST\n") {
+		t.Errorf("live playlist should be ended:\n%s", b)
+	}
+	files, _ := filepath.Glob(filepath.Join(conf.HLS.Dir, "live", "dvr", "*", "vod.m3u8"))
+	if len(files) != 1 {
+		t.Fatalf("vod playlist should be on disk, got %v", files)
+	}
+
+	// stop cleans up
+	if err := pl.stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Dir(files[0])); !os.IsNotExist(err) {
+		t.Error("segments of stream should be removed")
+	}
+}
+
+func TestEndedStream(t *testing.T) {
+	srv := New(newTestConfig(120))
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pl := newPlayer(srv)
+	defer pl.stop()
+
+	c, err := srt.Dial("udp", srv.UDPAddr().String(), &srt.Config{StreamID: "live/ended"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := pl.waitStatus(t, "/live/ended/index.m3u8", http.StatusOK); code != http.StatusOK {
+		t.Fatalf("live playlist status %d", code)
+	}
+
+	// the default retention keeps the vod playlist of the ended stream
+	_ = c.Close()
+	if code := pl.waitStatus(t, "/live/ended/vod.m3u8", http.StatusOK); code != http.StatusOK {
+		t.Fatalf("vod playlist status %d", code)
+	}
+	b := pl.get(t, "/live/ended/vod.m3u8")
+	if !strings.Contains(string(b), "#EXT-X-PLAYLIST-TYPE:VOD") || !strings.HasSuffix(string(b), "#EXT-X-ENDLIST\n") {
+		t.Errorf("unexpected vod playlist:\n%s", b)
+	}
+}
+
+func TestLowLatency(t *testing.T) {
+	conf := newTestConfig(120)
+	conf.HLS.TargetDuration = 0.1
+	conf.HLS.PartTarget = 0.3
+	srv := New(conf)
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pl := newPlayer(srv)
+	defer pl.stop()
+
+	c, err := srt.Dial("udp", srv.UDPAddr().String(), &srt.Config{StreamID: "live/ll"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if code := pl.waitStatus(t, "/live/ll/index.m3u8", http.StatusOK); code != http.StatusOK {
+		t.Fatalf("playlist status %d", code)
+	}
+	b := pl.get(t, "/live/ll/index.m3u8")
+	if !strings.Contains(string(b), "#EXT-X-PART-INF:PART-TARGET=0.300\n") ||
+		!strings.Contains(string(b), "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"0.0.ts\"\n") {
+		t.Errorf("unexpected low-latency playlist:\n%s", b)
+	}
+
+	for p, code := range map[string]int{
+		// no data comes in 3 target durations
+		"/live/ll/index.m3u8?_HLS_msn=0&_HLS_part=0": http.StatusServiceUnavailable,
+		"/live/ll/0.0.ts":                 http.StatusServiceUnavailable,
+		"/live/ll/index.m3u8?_HLS_msn=3":  http.StatusBadRequest,
+		"/live/ll/index.m3u8?_HLS_part=0": http.StatusBadRequest,
+	} {
+		if got := pl.waitStatus(t, p, code); got != code {
+			t.Errorf("%s status %d, expected %d", p, got, code)
+		}
+	}
+}
+
+// player requests hls of a server on its own connections, they are closed before the server stops
+type player struct {
+	srv    *Server
+	client *http.Client
+}
+
+func newPlayer(srv *Server) *player {
+	return &player{srv: srv, client: &http.Client{Transport: new(http.Transport)}}
+}
+
+// get returns the body of p
+func (pl *player) get(t *testing.T, p string) []byte {
+	t.Helper()
+	rsp, err := pl.client.Get("http://" + pl.srv.HLSAddr().String() + p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	b, _ := ioutil.ReadAll(rsp.Body)
+	return b
+}
+
+// waitStatus gets p from hls server until it responds code or a second passes, returns the last status
+func (pl *player) waitStatus(t *testing.T, p string, code int) int {
+	deadline := time.Now().Add(time.Second)
+	for {
+		rsp, err := pl.client.Get("http://" + pl.srv.HLSAddr().String() + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(ioutil.Discard, rsp.Body)
+		_ = rsp.Body.Close()
+		if rsp.StatusCode == code || time.Now().After(deadline) {
+			return rsp.StatusCode
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// stop closes the idle connections and stops the server
+func (pl *player) stop() error {
+	pl.client.CloseIdleConnections()
+	return pl.srv.Stop()
+}
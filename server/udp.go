@@ -1,32 +1,104 @@
 package server
 
 import (
+	"context"
 	"net"
 
-	"github.com/beleege/gosrt/config"
 	"github.com/beleege/gosrt/core/handler"
 	"github.com/beleege/gosrt/core/selector"
+	"github.com/beleege/gosrt/core/session"
+	"github.com/beleege/gosrt/util/batch"
 	"github.com/beleege/gosrt/util/log"
+	"github.com/beleege/gosrt/util/pool"
+	"github.com/beleege/gosrt/util/reuseport"
 	"github.com/pkg/errors"
 )
 
-func SetupUDPServer() {
-	addr, err := net.ResolveUDPAddr("udp", config.GetUDPAddr())
+// shard is a listening socket with its own read loop, sessions and workers,
+// kernel steers datagrams of a peer to the same socket so shards share no session
+type shard struct {
+	conn       net.PacketConn
+	sel        *selector.Selector
+	dispatcher *handler.Dispatcher
+}
+
+func (sh *shard) close() {
+	_ = sh.conn.Close()
+	sh.dispatcher.Close()
+}
+
+func (s *Server) listenUDP() ([]*shard, error) {
+	conns, err := reuseport.ListenUDP("udp", s.conf.UDPAddr(), s.conf.UDP.Sockets)
 	if err != nil {
-		panic(errors.WithStack(err))
+		return nil, err
 	}
-	log.Infof("udp server start at %s", config.GetUDPAddr())
+	shards := make([]*shard, 0, len(conns))
+	for _, c := range conns {
+		d, err := handler.NewChainDispatcher(s.chain, &pool.Options{
+			Workers:   s.conf.PoolSize,
+			QueueSize: s.conf.QueueSize,
+			Policy:    pool.ParsePolicy(s.conf.QueuePolicy),
+		})
+		if err != nil {
+			for _, sh := range shards {
+				sh.close()
+			}
+			for _, c := range conns[len(shards):] {
+				_ = c.Close()
+			}
+			return nil, err
+		}
+		sh := new(shard)
+		// sessions write through batch conn as well
+		sh.conn = batch.New(c)
+		sh.dispatcher = d
+		sh.sel = selector.New(sh.conn, d, session.NewOptions(s.conf))
+		sh.sel.Registry().Subscribe(s.onSession)
+		shards = append(shards, sh)
+	}
+	log.Infof("udp server start at %s on %d sockets", conns[0].LocalAddr(), len(conns))
+	return shards, nil
+}
 
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		panic(errors.WithStack(err))
+func (s *Server) serveUDP() {
+	for _, sh := range s.shards {
+		s.readers.Add(1)
+		go sh.dispatcher.Run()
+		go func(sh *shard) {
+			defer s.readers.Done()
+			defer func() {
+				_ = sh.conn.Close()
+			}()
+			sh.sel.Select()
+		}(sh)
 	}
-	defer func() {
-		_ = conn.Close()
-		log.Infof("udp server shutdown")
-	}()
+}
 
-	go handler.Task()
+// stopUDP stops accepting handshakes, sends shutdown to connected peers and closes the listener
+func (s *Server) stopUDP(ctx context.Context) error {
+	for _, sh := range s.shards {
+		sh.sel.Drain()
+	}
+	for _, ss := range s.Sessions() {
+		if ss.State() == session.SConnect {
+			handler.CloseConnect(ss)
+		}
+		ss.Shutdown("server shutdown")
+	}
+	for _, sh := range s.shards {
+		_ = sh.conn.Close()
+	}
 
-	selector.Select(conn)
+	stopped := make(chan struct{})
+	go func() {
+		s.readers.Wait()
+		log.Infof("udp server shutdown")
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "udp server shutdown")
+	}
 }
@@ -0,0 +1,52 @@
+package srt
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	packet "github.com/beleege/gosrt/protocol/srt"
+)
+
+type RejectReason uint32
+
+const (
+	RejectUnknown     RejectReason = packet.RejUnknown
+	RejectPeer        RejectReason = packet.RejPeer
+	RejectResource    RejectReason = packet.RejResource
+	RejectBacklog     RejectReason = packet.RejBacklog
+	RejectVersion     RejectReason = packet.RejVersion
+	RejectBadSecret   RejectReason = packet.RejBadSecret
+	RejectUnsecure    RejectReason = packet.RejUnsecure
+	RejectBadRequest  RejectReason = packet.RejPredefined + 400
+	RejectForbidden   RejectReason = packet.RejPredefined + 403
+	RejectNotFound    RejectReason = packet.RejPredefined + 404
+	RejectConflict    RejectReason = packet.RejPredefined + 409
+	RejectUserDefined RejectReason = packet.RejUserDefined
+)
+
+// ConnRequest describes a caller during handshake
+type ConnRequest struct {
+	StreamID   string
+	RemoteAddr net.Addr
+	Version    uint32 // SRT version of caller, 0x010403 for 1.4.3
+	Encrypted  bool
+}
+
+// Decision of AcceptFunc, the zero value accepts caller with listener defaults
+type Decision struct {
+	Reject     RejectReason  // rejects caller with the reason if not zero
+	Passphrase string        // caller must encrypt the stream with it if set
+	Latency    time.Duration // overrides the latency of Config
+}
+
+type AcceptFunc func(req *ConnRequest) Decision
+
+// RejectError is returned by Dial when listener rejects the connection
+type RejectError struct {
+	Reason RejectReason
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("connection rejected with reason[%d]", e.Reason)
+}
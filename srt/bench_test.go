@@ -0,0 +1,61 @@
+package srt
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkConn sends messages from caller to listener through loopback
+func BenchmarkConn(b *testing.B) {
+	l, err := Listen("udp", "127.0.0.1:0", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan *Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	caller, err := Dial("udp", l.Addr().String(), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer caller.Close()
+	conn := <-accepted
+	defer conn.Close()
+
+	var got int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1500)
+		for atomic.LoadInt64(&got) < int64(b.N) {
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := conn.Read(buf); err != nil {
+				return
+			}
+			atomic.AddInt64(&got, 1)
+		}
+	}()
+
+	msg := make([]byte, 1316)
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = caller.Write(msg); err != nil {
+			b.Fatal(err)
+		}
+		// loopback drops a burst beyond socket buffer
+		for int64(i)-atomic.LoadInt64(&got) > 64 {
+			time.Sleep(10 * time.Microsecond)
+		}
+	}
+	<-done
+	b.StopTimer()
+	if got != int64(b.N) {
+		b.Errorf("read %d messages, expected %d", got, b.N)
+	}
+}
@@ -0,0 +1,81 @@
+package srt
+
+import (
+	"time"
+
+	"github.com/beleege/gosrt/core/session"
+)
+
+const (
+	_defaultLatency        = 120 * time.Millisecond
+	_defaultPayloadSize    = 1316
+	_maxPayloadSize        = 1384
+	_defaultConnectTimeout = 3 * time.Second
+	_defaultIdleTimeout    = 5 * time.Second
+	_defaultPoolSize       = 10
+	_defaultBacklog        = 128
+)
+
+// Config of Listener and Conn, zero values are replaced by defaults
+type Config struct {
+	Latency        time.Duration // TSBPD latency, the larger one of both sides is used
+	StreamID       string        // sent by Dial to identify the stream
+	PayloadSize    int           // max bytes sent in a data packet
+	ConnectTimeout time.Duration // handshake timeout of Dial and of callers of Listener
+	IdleTimeout    time.Duration // connection is closed if peer sends nothing for this long
+	PoolSize       int           // workers handling sessions of Listener
+	Backlog        int           // connected callers waiting for Accept, more are shut down
+	AcceptFunc     AcceptFunc    // decides callers of Listener before handshake is done
+}
+
+func (c *Config) withDefaults() *Config {
+	conf := new(Config)
+	if c != nil {
+		*conf = *c
+	}
+	if conf.Latency <= 0 {
+		conf.Latency = _defaultLatency
+	}
+	if conf.PayloadSize <= 0 {
+		conf.PayloadSize = _defaultPayloadSize
+	} else if conf.PayloadSize > _maxPayloadSize {
+		conf.PayloadSize = _maxPayloadSize
+	}
+	if conf.ConnectTimeout <= 0 {
+		conf.ConnectTimeout = _defaultConnectTimeout
+	}
+	if conf.IdleTimeout <= 0 {
+		conf.IdleTimeout = _defaultIdleTimeout
+	}
+	if conf.PoolSize <= 0 {
+		conf.PoolSize = _defaultPoolSize
+	}
+	if conf.Backlog <= 0 {
+		conf.Backlog = _defaultBacklog
+	}
+	return conf
+}
+
+func (c *Config) options() *session.Options {
+	latency := uint16(c.Latency / time.Millisecond)
+	opts := &session.Options{
+		RxLatency: latency,
+		TxLatency: latency,
+		TLPktDrop: true,
+		NAKReport: true,
+
+		HandshakeTimeout: c.ConnectTimeout,
+		IdleTimeout:      c.IdleTimeout,
+	}
+	if f := c.AcceptFunc; f != nil {
+		opts.Admit = func(req *session.ConnRequest) *session.Admission {
+			d := f(&ConnRequest{StreamID: req.StreamID, RemoteAddr: req.Peer, Version: req.SRTVersion, Encrypted: req.Encrypted})
+			return &session.Admission{
+				Reject:     uint32(d.Reject),
+				Passphrase: d.Passphrase,
+				Latency:    uint16(d.Latency / time.Millisecond),
+			}
+		}
+	}
+	return opts
+}
@@ -0,0 +1,225 @@
+package srt
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/beleege/gosrt/core/handler"
+	"github.com/beleege/gosrt/core/session"
+	packet "github.com/beleege/gosrt/protocol/srt"
+)
+
+const (
+	_keepalivePeriod = time.Second
+)
+
+// Conn is an established SRT connection in live mode, each Read returns at most one message
+type Conn struct {
+	s           *session.SRTSession
+	payloadSize int
+	// release resources owned by conn, the socket of caller
+	closer func() error
+
+	rmu sync.Mutex
+	// pkgs not read yet, the first one is read from off
+	pending []*packet.DataPacket
+	off     int
+	rd      *deadline
+	wd      *deadline
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConn(s *session.SRTSession, c *Config, closer func() error) *Conn {
+	conn := new(Conn)
+	conn.s = s
+	conn.payloadSize = c.PayloadSize
+	conn.closer = closer
+	conn.rd = newDeadline()
+	conn.wd = newDeadline()
+	conn.closed = make(chan struct{})
+	go conn.keepalive()
+	return conn
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for len(c.pending) == 0 {
+		expired, changed, stop := c.rd.wait()
+		select {
+		case batch, ok := <-c.s.RecWin.ListenBatch():
+			if !ok {
+				stop()
+				return 0, io.EOF
+			}
+			c.pending = append(c.pending, batch...)
+		case <-c.s.Done():
+			stop()
+			return 0, io.EOF
+		case <-c.closed:
+			stop()
+			return 0, errClosed
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		case <-changed:
+		}
+		stop()
+	}
+
+	p := c.pending[0]
+	n := copy(b, p.Content[c.off:])
+	if c.off += n; c.off == len(p.Content) {
+		p.Release()
+		c.pending[0] = nil
+		c.pending = c.pending[1:]
+		c.off = 0
+	}
+	return n, nil
+}
+
+// Write splits b into messages of payload size
+func (c *Conn) Write(b []byte) (int, error) {
+	select {
+	case <-c.s.Done():
+		return 0, io.ErrClosedPipe
+	case <-c.closed:
+		return 0, errClosed
+	default:
+	}
+	if c.wd.exceeded() {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	n := 0
+	for len(b) > 0 {
+		size := len(b)
+		if size > c.payloadSize {
+			size = c.payloadSize
+		}
+		if err := c.s.SendData(b[:size]); err != nil {
+			return n, err
+		}
+		n += size
+		b = b[size:]
+	}
+	return n, nil
+}
+
+func (c *Conn) Close() error {
+	err := errClosed
+	c.once.Do(func() {
+		handler.CloseConnect(c.s)
+		c.s.Shutdown("closed by local")
+		close(c.closed)
+		// wait for Read to return before giving up the unread pkgs
+		c.rmu.Lock()
+		for _, p := range c.pending {
+			p.Release()
+		}
+		c.pending = nil
+		c.rmu.Unlock()
+		err = nil
+		if c.closer != nil {
+			err = c.closer()
+		}
+	})
+	return err
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.s.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.s.RemoteAddr()
+}
+
+func (c *Conn) StreamID() string {
+	return c.s.StreamID
+}
+
+// Stats is a snapshot of connection counters like srt_bstats of libsrt
+type Stats = session.Stats
+
+// Stats returns the cumulative and interval counters, clear starts a new interval
+func (c *Conn) Stats(clear bool) *Stats {
+	return c.s.Stats(clear)
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.rd.set(t)
+	c.wd.set(t)
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.rd.set(t)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.wd.set(t)
+	return nil
+}
+
+func (c *Conn) keepalive() {
+	ticker := time.NewTicker(_keepalivePeriod)
+	defer ticker.Stop()
+
+	cp := new(packet.ControlPacket)
+	cp.CType = packet.CTKeepalive
+	for {
+		select {
+		case <-ticker.C:
+			_, _ = c.s.Write(cp.Keepalive(&c.s.OpenTime, c.s.ThatSID))
+		case <-c.s.Done():
+			return
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+type deadline struct {
+	mu      sync.Mutex
+	t       time.Time
+	changed chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{changed: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.t = t
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+func (d *deadline) exceeded() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return !d.t.IsZero() && !time.Now().Before(d.t)
+}
+
+// wait returns a channel fired at deadline and a channel closed once deadline is changed
+func (d *deadline) wait() (<-chan time.Time, <-chan struct{}, func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.t.IsZero() {
+		return nil, d.changed, func() {}
+	}
+	timer := time.NewTimer(time.Until(d.t))
+	return timer.C, d.changed, func() { timer.Stop() }
+}
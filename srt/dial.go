@@ -0,0 +1,178 @@
+package srt
+
+import (
+	"net"
+	"time"
+
+	"github.com/beleege/gosrt/core/handler"
+	"github.com/beleege/gosrt/core/selector"
+	"github.com/beleege/gosrt/core/session"
+	packet "github.com/beleege/gosrt/protocol/srt"
+	"github.com/beleege/gosrt/util/math"
+	"github.com/beleege/gosrt/util/seqno"
+	"github.com/pkg/errors"
+)
+
+const (
+	_udtDgram     = 2
+	_mtu          = 1500
+	_mfw          = 8192
+	_resendPeriod = 250 * time.Millisecond
+	_mtuLimit     = 1400
+)
+
+// Dial connects to a SRT listener as caller
+func Dial(network, addr string, c *Config) (*Conn, error) {
+	c = c.withDefaults()
+	raddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	s := session.NewSRTSession(conn, raddr, c.options())
+	s.StreamID = c.StreamID
+	if err = handshake(conn, s, time.Now().Add(c.ConnectTimeout)); err != nil {
+		s.Shutdown("dial fail")
+		_ = conn.Close()
+		return nil, err
+	}
+
+	d := handler.NewDispatcher(1)
+	sel := selector.New(conn, d, nil)
+	sel.Add(s)
+	go d.Run()
+	go sel.Select()
+	return newConn(s, c, conn.Close), nil
+}
+
+// handshake runs induction and conclusion phases of HSv5 as caller
+func handshake(conn *net.UDPConn, s *session.SRTSession, deadline time.Time) error {
+	s.SendNo = seqno.Random()
+	cif := new(packet.HandShakeCIF)
+	cif.Version = packet.HSv4
+	cif.Extension = _udtDgram
+	cif.InitSequenceNum = s.SendNo
+	cif.MTU = _mtu
+	cif.MFW = _mfw
+	cif.HType = packet.HSTypeInduction
+	cif.SocketID = s.ThisSID
+	cif.PeerIP = peerIP(s.RemoteAddr())
+
+	if err := s.Transit(session.SOpen, "induction"); err != nil {
+		return err
+	}
+	rsp, err := exchange(conn, s, cif, deadline)
+	if err != nil {
+		return err
+	}
+	if rsp.Version != packet.HSv5 || rsp.Extension != packet.HSv5Magic {
+		return errors.Errorf("peer handshake version[%d] is not supported", rsp.Version)
+	}
+	s.Cookie = rsp.Cookie
+	if err = s.Transit(session.SSetCookie, "cookie received"); err != nil {
+		return err
+	}
+
+	req := new(packet.HSExtTSBPD)
+	req.SRTVersion = packet.SRTVersion
+	req.SRTFlags = packet.SRTFlagTSBPDSND | packet.SRTFlagTSBPDRCV | packet.SRTFlagREXMITFLG
+	if s.Opts.TLPktDrop {
+		req.SRTFlags |= packet.SRTFlagTLPKTDROP
+	}
+	if s.Opts.NAKReport {
+		req.SRTFlags |= packet.SRTFlagNAKREPORT
+	}
+	req.TxDelay = s.Opts.RxLatency
+	req.RxDelay = s.Opts.TxLatency
+	ext := &packet.HSExtension{EType: packet.HSExtTypeHSReq, EContent: make([]byte, 12)}
+	req.Encode(ext.EContent)
+
+	cif.Version = packet.HSv5
+	cif.Extension = packet.HSFlagHSREQ
+	cif.HType = packet.HSTypeConclusion
+	cif.Cookie = s.Cookie
+	cif.HSExt = ext.Bytes()
+	if len(s.StreamID) > 0 {
+		cif.Extension |= packet.HSFlagCONFIG
+		sid := &packet.HSExtension{EType: packet.HSExtTypeSID, EContent: packet.EncodeStreamID(s.StreamID)}
+		cif.HSExt = append(cif.HSExt, sid.Bytes()...)
+	}
+
+	if err = s.Transit(session.SRepeat, "conclusion"); err != nil {
+		return err
+	}
+	if rsp, err = exchange(conn, s, cif, deadline); err != nil {
+		return err
+	}
+	if rsp.HType != packet.HSTypeConclusion {
+		s.Shutdown("rejected")
+		return &RejectError{Reason: RejectReason(rsp.HType)}
+	}
+	s.ThatSID = rsp.SocketID
+	s.TSBPD = nil
+	s.ParseHSExtension(rsp.HSExt)
+	if s.TSBPD == nil {
+		return errors.New("no HSRSP in conclusion response")
+	}
+	// peer's sender delay is the delay we receive with
+	s.Agree(s.TSBPD.SRTFlags&req.SRTFlags, math.MaxUInt16(s.TSBPD.RxDelay, s.Opts.RxLatency))
+	return s.Connect()
+}
+
+// exchange sends handshake until a response from peer arrives
+func exchange(conn *net.UDPConn, s *session.SRTSession, cif *packet.HandShakeCIF, deadline time.Time) (*packet.HandShakeCIF, error) {
+	cp := new(packet.ControlPacket)
+	cp.CType = packet.CTHandShake
+	req := cp.Handshake(&s.OpenTime, 0, cif)
+	buf := make([]byte, _mtuLimit)
+	defer func() {
+		_ = conn.SetReadDeadline(time.Time{})
+	}()
+
+	for time.Now().Before(deadline) {
+		if _, err := s.Write(req); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		wait := time.Now().Add(_resendPeriod)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		_ = conn.SetReadDeadline(wait)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return nil, errors.WithStack(err)
+			}
+			if from.String() != s.GetPeer() || n < 16 || buf[0]>>7 != packet.PTypeControl {
+				continue
+			}
+			p := packet.ParseCPacket(buf[:n])
+			if p.CType != packet.CTHandShake || len(p.CIF) < 48 {
+				continue
+			}
+			b := make([]byte, len(p.CIF))
+			copy(b, p.CIF)
+			return packet.ParseHCIF(b), nil
+		}
+	}
+	return nil, errors.New("handshake timeout")
+}
+
+func peerIP(addr net.Addr) []byte {
+	b := make([]byte, 16)
+	if a, ok := addr.(*net.UDPAddr); ok {
+		if ip := a.IP.To4(); ip != nil {
+			b[0], b[1], b[2], b[3] = ip[3], ip[2], ip[1], ip[0]
+		} else {
+			copy(b, a.IP.To16())
+		}
+	}
+	return b
+}
@@ -0,0 +1,119 @@
+package srt
+
+import (
+	"net"
+	"sync"
+
+	"github.com/beleege/gosrt/core/handler"
+	"github.com/beleege/gosrt/core/selector"
+	"github.com/beleege/gosrt/core/session"
+	"github.com/beleege/gosrt/util/batch"
+	"github.com/beleege/gosrt/util/log"
+	"github.com/pkg/errors"
+)
+
+var errClosed = errors.New("use of closed srt connection")
+
+// Listener accepts SRT callers on a UDP socket
+type Listener struct {
+	conn *batch.Conn
+	conf *Config
+	sel  *selector.Selector
+	// connected callers not accepted yet
+	accept chan *session.SRTSession
+	closed chan struct{}
+	once   sync.Once
+	// read loop and workers
+	wg sync.WaitGroup
+}
+
+func Listen(network, addr string, c *Config) (*Listener, error) {
+	c = c.withDefaults()
+	udpAddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	conn, err := net.ListenUDP(network, udpAddr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	l := new(Listener)
+	l.conn = batch.New(conn)
+	l.conf = c
+	l.accept = make(chan *session.SRTSession, c.Backlog)
+	l.closed = make(chan struct{})
+
+	d := handler.NewDispatcher(c.PoolSize)
+	l.sel = selector.New(l.conn, d, c.options())
+	l.sel.OnEvent = l.onEvent
+	l.wg.Add(2)
+	go func() {
+		defer l.wg.Done()
+		d.Run()
+	}()
+	go func() {
+		defer l.wg.Done()
+		l.sel.Select()
+	}()
+	return l, nil
+}
+
+// onEvent queues connected caller for Accept, it runs on the worker of session and never blocks
+func (l *Listener) onEvent(e *session.Event) {
+	if e.To != session.SConnect {
+		return
+	}
+	select {
+	case <-l.closed:
+		handler.CloseConnect(e.Session)
+		e.Session.Shutdown("listener closed")
+		return
+	default:
+	}
+	select {
+	case l.accept <- e.Session:
+	default:
+		log.Infof("session[%s] is not accepted, backlog is full", e.Session.GetPeer())
+		handler.CloseConnect(e.Session)
+		e.Session.Shutdown("backlog full")
+	}
+}
+
+// Accept waits for the next caller which has finished handshake
+func (l *Listener) Accept() (*Conn, error) {
+	select {
+	case <-l.closed:
+		// callers left in backlog are shutdown by Close
+		return nil, errClosed
+	default:
+	}
+	select {
+	case s := <-l.accept:
+		return newConn(s, l.conf, nil), nil
+	case <-l.closed:
+		return nil, errClosed
+	}
+}
+
+// Close stops accepting handshakes, sends shutdown to connected peers and waits for the read loop and workers to exit
+func (l *Listener) Close() error {
+	err := errClosed
+	l.once.Do(func() {
+		close(l.closed)
+		l.sel.Drain()
+		for _, s := range l.sel.Sessions() {
+			if s.State() == session.SConnect {
+				handler.CloseConnect(s)
+			}
+			s.Shutdown("listener closed")
+		}
+		err = l.conn.Close()
+		l.wg.Wait()
+	})
+	return err
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
@@ -0,0 +1,225 @@
+package srt
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	packet "github.com/beleege/gosrt/protocol/srt"
+)
+
+func TestDialListen(t *testing.T) {
+	l, err := Listen("udp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan *Conn)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- c
+	}()
+
+	caller, err := Dial("udp", l.Addr().String(), &Config{StreamID: "#!::r=live/test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer caller.Close()
+
+	var conn *Conn
+	select {
+	case conn = <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("accept timeout")
+	}
+	if conn.StreamID() != "#!::r=live/test" {
+		t.Errorf("unexpected stream id: %q", conn.StreamID())
+	}
+
+	msgs := []string{"first", "second", "third"}
+	for _, m := range msgs {
+		if _, err = caller.Write([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1500)
+	for _, m := range msgs {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != m {
+			t.Errorf("read %q, want %q", buf[:n], m)
+		}
+	}
+
+	size := uint64(len("first") + len("second") + len("third"))
+	if st := caller.Stats(false); st.Total.PktSentUnique != 3 || st.Total.ByteSent != size {
+		t.Errorf("unexpected caller stats: %+v", st.Total)
+	}
+	if st := conn.Stats(true); st.Total.PktRecv != 3 || st.Interval.ByteRecv != size || st.Latency == 0 {
+		t.Errorf("unexpected listener stats: %+v", st)
+	}
+	if st := conn.Stats(false); st.Interval.PktRecv != 0 || st.Total.PktRecv != 3 {
+		t.Errorf("interval is not cleared: %+v", st)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err = conn.Read(buf); !os.IsTimeout(err) {
+		t.Errorf("read should timeout, got %v", err)
+	}
+}
+
+func TestAcceptFunc(t *testing.T) {
+	requests := make(chan *ConnRequest, 3)
+	l, err := Listen("udp", "127.0.0.1:0", &Config{AcceptFunc: func(req *ConnRequest) Decision {
+		requests <- req
+		switch req.StreamID {
+		case "forbidden":
+			return Decision{Reject: RejectForbidden}
+		case "secret":
+			return Decision{Passphrase: "0123456789"}
+		}
+		return Decision{Latency: 200 * time.Millisecond}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			if _, err := l.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+
+	cases := []struct {
+		sid    string
+		reason RejectReason
+	}{
+		{"forbidden", RejectForbidden},
+		{"secret", RejectUnsecure},
+		{"live", 0},
+	}
+	for _, c := range cases {
+		conn, err := Dial("udp", l.Addr().String(), &Config{StreamID: c.sid})
+		if c.reason == 0 {
+			if err != nil {
+				t.Fatalf("dial %s fail: %v", c.sid, err)
+			}
+			if conn.s.Latency != 200 {
+				t.Errorf("unexpected latency: %d", conn.s.Latency)
+			}
+			_ = conn.Close()
+		} else if re, ok := err.(*RejectError); !ok || re.Reason != c.reason {
+			t.Errorf("dial %s should be rejected with %d, got %v", c.sid, c.reason, err)
+		}
+
+		req := <-requests
+		if req.StreamID != c.sid || req.Version != packet.SRTVersion || req.Encrypted {
+			t.Errorf("unexpected request: %+v", req)
+		}
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	l, err := Listen("udp", "127.0.0.1:0", &Config{ConnectTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// caller stalls after induction
+	cif := &packet.HandShakeCIF{Version: packet.HSv4, Extension: _udtDgram, HType: packet.HSTypeInduction, SocketID: 1,
+		PeerIP: make([]byte, 16)}
+	cp := &packet.ControlPacket{CType: packet.CTHandShake}
+	start := time.Now()
+	if _, err = conn.WriteTo(cp.Handshake(&start, 0, cif), l.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err = conn.ReadFrom(make([]byte, 1500)); err != nil {
+		t.Fatalf("no induction response: %v", err)
+	}
+	sessions := l.sel.Sessions()
+	if len(sessions) != 1 {
+		t.Fatalf("%d sessions", len(sessions))
+	}
+	select {
+	case <-sessions[0].Done():
+	case <-time.After(time.Second):
+		t.Fatalf("session in %s should be shutdown after handshake timeout", sessions[0].State())
+	}
+}
+
+func TestBacklog(t *testing.T) {
+	l, err := Listen("udp", "127.0.0.1:0", &Config{Backlog: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// nobody accepts, the second caller is shut down
+	callers := make([]*Conn, 2)
+	for i := range callers {
+		if callers[i], err = Dial("udp", l.Addr().String(), nil); err != nil {
+			t.Fatal(err)
+		}
+		defer callers[i].Close()
+	}
+	_ = callers[1].SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = callers[1].Read(make([]byte, 1500)); err != io.EOF {
+		t.Errorf("caller over backlog should be shutdown, got %v", err)
+	}
+	if _, err = l.Accept(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListenerClose(t *testing.T) {
+	l, err := Listen("udp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller, err := Dial("udp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer caller.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if l := len(l.sel.Sessions()); l != 0 {
+		t.Errorf("%d sessions are left", l)
+	}
+	buf := make([]byte, 1500)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(buf); err != io.EOF {
+		t.Errorf("accepted conn should be shutdown, got %v", err)
+	}
+	_ = caller.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = caller.Read(buf); err != io.EOF {
+		t.Errorf("caller should be shutdown, got %v", err)
+	}
+	if _, err = l.Accept(); err != errClosed {
+		t.Errorf("accept after close should fail, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package batch
+
+import (
+	"net"
+	"sync"
+
+	"github.com/beleege/gosrt/util/buffer"
+	"github.com/beleege/gosrt/util/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// MaxDatagram is the largest datagram read or written
+	MaxDatagram = buffer.Size
+	// Size is the count of datagrams in a batch
+	Size       = 64
+	_queueSize = 1024
+)
+
+var errClosed = errors.New("batch conn is closed")
+
+// Message is a datagram with its peer, the datagram is Buf.B[:N]
+type Message struct {
+	Buf  *buffer.Buffer
+	N    int
+	Addr net.Addr
+}
+
+// rw moves datagrams of a batch in one syscall where the platform allows
+type rw interface {
+	readBatch(ms []Message) (int, error)
+	writeBatch(ms []Message) (int, error)
+}
+
+// Conn is a PacketConn reading and writing datagrams in batches, writes are queued and sent by a writer
+type Conn struct {
+	net.PacketConn
+	rw rw
+	// outgoing datagrams
+	queue chan Message
+	// guards queue against close
+	mu      sync.RWMutex
+	closed  bool
+	flushed chan struct{}
+}
+
+func New(c net.PacketConn) *Conn {
+	bc := new(Conn)
+	bc.PacketConn = c
+	bc.rw = newRW(c)
+	if bc.rw == nil {
+		bc.rw = &fallback{c}
+	}
+	bc.queue = make(chan Message, _queueSize)
+	bc.flushed = make(chan struct{})
+
+	go bc.write()
+	return bc
+}
+
+// ReadBatch reads at least one datagram into ms. A nil Buf is taken from pool,
+// caller may keep Buf of a read message and clear the message, then it releases Buf when done
+func (c *Conn) ReadBatch(ms []Message) (int, error) {
+	if len(ms) > Size {
+		ms = ms[:Size]
+	}
+	for i := range ms {
+		if ms[i].Buf == nil {
+			ms[i].Buf = buffer.Get()
+		}
+	}
+	return c.rw.readBatch(ms)
+}
+
+// WriteTo queues a copy of b, it is sent with the others queued at the same time
+func (c *Conn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if len(b) > MaxDatagram {
+		return 0, errors.Errorf("datagram size[%d] is illegal", len(b))
+	}
+	buf := buffer.Get()
+	n := copy(buf.B, b)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		buf.Release()
+		return 0, errClosed
+	}
+	c.queue <- Message{Buf: buf, N: n, Addr: addr}
+	return n, nil
+}
+
+// Close sends the queued datagrams and closes the underlying conn
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.queue)
+	c.mu.Unlock()
+
+	<-c.flushed
+	return c.PacketConn.Close()
+}
+
+func (c *Conn) write() {
+	defer close(c.flushed)
+
+	ms := make([]Message, 0, Size)
+	for m := range c.queue {
+		ms = append(ms[:0], m)
+		// take what is queued already, never wait for more
+	more:
+		for len(ms) < Size {
+			select {
+			case m, ok := <-c.queue:
+				if !ok {
+					break more
+				}
+				ms = append(ms, m)
+			default:
+				break more
+			}
+		}
+		c.flush(ms)
+	}
+}
+
+func (c *Conn) flush(ms []Message) {
+	for len(ms) > 0 {
+		n, err := c.rw.writeBatch(ms)
+		if err != nil {
+			log.Errorf("batch write fail: %s", err.Error())
+			n = 1
+		}
+		if n == 0 {
+			// nothing was sent, give up the batch rather than spin
+			n = len(ms)
+		}
+		for i := 0; i < n; i++ {
+			ms[i].Buf.Release()
+			ms[i] = Message{}
+		}
+		ms = ms[n:]
+	}
+}
+
+// fallback moves one datagram per syscall
+type fallback struct {
+	c net.PacketConn
+}
+
+func (f *fallback) readBatch(ms []Message) (int, error) {
+	n, addr, err := f.c.ReadFrom(ms[0].Buf.B)
+	if err != nil {
+		return 0, err
+	}
+	ms[0].N = n
+	ms[0].Addr = addr
+	return 1, nil
+}
+
+func (f *fallback) writeBatch(ms []Message) (int, error) {
+	for i := range ms {
+		if _, err := f.c.WriteTo(ms[i].Buf.B[:ms[i].N], ms[i].Addr); err != nil {
+			return i, err
+		}
+	}
+	return len(ms), nil
+}
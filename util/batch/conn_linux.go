@@ -0,0 +1,71 @@
+//go:build linux
+// +build linux
+
+package batch
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// xconn is ReadBatch/WriteBatch of ipv4 and ipv6 PacketConn, both use recvmmsg/sendmmsg on linux
+type xconn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+type mmsg struct {
+	x xconn
+	// reused headers of read and write batch
+	rxms []ipv4.Message
+	wxms []ipv4.Message
+}
+
+func newRW(c net.PacketConn) rw {
+	uc, ok := c.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	m := new(mmsg)
+	if a, ok := uc.LocalAddr().(*net.UDPAddr); ok && a.IP.To4() != nil {
+		m.x = ipv4.NewPacketConn(uc)
+	} else {
+		m.x = ipv6.NewPacketConn(uc)
+	}
+	m.rxms = headers()
+	m.wxms = headers()
+	return m
+}
+
+// warn: readBatch and writeBatch run on different goroutines, they must not share headers
+func (m *mmsg) readBatch(ms []Message) (int, error) {
+	xms := m.rxms[:len(ms)]
+	for i := range xms {
+		xms[i].Buffers[0] = ms[i].Buf.B
+	}
+	n, err := m.x.ReadBatch(xms, 0)
+	for i := 0; i < n; i++ {
+		ms[i].N = xms[i].N
+		ms[i].Addr = xms[i].Addr
+	}
+	return n, err
+}
+
+func (m *mmsg) writeBatch(ms []Message) (int, error) {
+	xms := m.wxms[:len(ms)]
+	for i := range ms {
+		xms[i].Buffers[0] = ms[i].Buf.B[:ms[i].N]
+		xms[i].Addr = ms[i].Addr
+	}
+	return m.x.WriteBatch(xms, 0)
+}
+
+func headers() []ipv4.Message {
+	xms := make([]ipv4.Message, Size)
+	for i := range xms {
+		xms[i].Buffers = make([][]byte, 1)
+	}
+	return xms
+}
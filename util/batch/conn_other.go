@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package batch
+
+import (
+	"net"
+)
+
+// newRW returns nil, batch syscalls are only used on linux
+func newRW(c net.PacketConn) rw {
+	return nil
+}
@@ -0,0 +1,174 @@
+package batch
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// plain hides UDPConn so that the fallback is used
+type plain struct {
+	net.PacketConn
+}
+
+func listen(tb testing.TB) *net.UDPConn {
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	_ = c.SetReadBuffer(4 << 20)
+	_ = c.SetWriteBuffer(4 << 20)
+	return c
+}
+
+func roundTrip(t *testing.T, wrap func(c net.PacketConn) net.PacketConn) {
+	src, dst := New(wrap(listen(t))), New(wrap(listen(t)))
+	defer dst.Close()
+
+	const count = 200
+	for i := 0; i < count; i++ {
+		if _, err := src.WriteTo(bytes.Repeat([]byte{byte(i)}, 1+i%100), dst.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// close flushes the queued datagrams
+	_ = src.Close()
+	if _, err := src.WriteTo([]byte{0}, dst.LocalAddr()); err == nil {
+		t.Error("write after close should fail")
+	}
+
+	ms := make([]Message, Size)
+	_ = dst.SetReadDeadline(time.Now().Add(time.Second))
+	for got := 0; got < count; {
+		n, err := dst.ReadBatch(ms)
+		if err != nil {
+			t.Fatalf("read %d datagrams: %s", got, err)
+		}
+		for i := 0; i < n; i++ {
+			m := ms[i]
+			if m.N != 1+got%100 || m.Buf.B[0] != byte(got) || m.Addr.String() != src.LocalAddr().String() {
+				t.Fatalf("datagram %d: unexpected %d bytes from %s", got, m.N, m.Addr)
+			}
+			m.Buf.Release()
+			ms[i].Buf = nil
+			got++
+		}
+	}
+}
+
+func TestConn(t *testing.T) {
+	roundTrip(t, func(c net.PacketConn) net.PacketConn {
+		return c
+	})
+}
+
+func TestFallback(t *testing.T) {
+	roundTrip(t, func(c net.PacketConn) net.PacketConn {
+		return &plain{c}
+	})
+}
+
+// sink receives nothing, datagrams sent to it are dropped by kernel
+func sink(b *testing.B) (net.Addr, func()) {
+	c := listen(b)
+	return c.LocalAddr(), func() { _ = c.Close() }
+}
+
+func BenchmarkWriteTo(b *testing.B) {
+	addr, stop := sink(b)
+	defer stop()
+	c := listen(b)
+	defer c.Close()
+
+	p := make([]byte, 1316)
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+	// sessions write concurrently
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = c.WriteTo(p, addr)
+		}
+	})
+}
+
+func BenchmarkBatchWrite(b *testing.B) {
+	addr, stop := sink(b)
+	defer stop()
+	c := New(listen(b))
+
+	p := make([]byte, 1316)
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = c.WriteTo(p, addr)
+		}
+	})
+	_ = c.Close()
+}
+
+// fill sends n datagrams to dst out of timer, they wait in the socket buffer
+func fill(b *testing.B, src net.PacketConn, dst net.Addr, n int) {
+	b.StopTimer()
+	p := make([]byte, 1316)
+	for i := 0; i < n; i++ {
+		_, _ = src.WriteTo(p, dst)
+	}
+	b.StartTimer()
+}
+
+const _fillSize = 1024
+
+// _handoff keeps read buffer escaping as it does in read loop
+var _handoff []byte
+
+func BenchmarkReadFrom(b *testing.B) {
+	c, src := listen(b), listen(b)
+	defer c.Close()
+	defer src.Close()
+
+	b.SetBytes(1316)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%_fillSize == 0 {
+			fill(b, src, c.LocalAddr(), _fillSize)
+		}
+		// a buffer per datagram handed to worker, as the read loop does
+		buf := make([]byte, MaxDatagram)
+		if _, _, err := c.ReadFrom(buf); err != nil {
+			b.Fatal(err)
+		}
+		_handoff = buf
+	}
+}
+
+func BenchmarkReadBatch(b *testing.B) {
+	c, src := New(listen(b)), listen(b)
+	defer c.Close()
+	defer src.Close()
+
+	ms := make([]Message, Size)
+	b.SetBytes(1316)
+	b.ResetTimer()
+	for i := 0; i < b.N; {
+		if i%_fillSize == 0 {
+			fill(b, src, c.LocalAddr(), _fillSize)
+		}
+		// never read over the filled datagrams
+		max := _fillSize - i%_fillSize
+		if max > Size {
+			max = Size
+		}
+		n, err := c.ReadBatch(ms[:max])
+		if err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < n; j++ {
+			// handed to worker, which releases it when done
+			ms[j].Buf.Release()
+			ms[j].Buf = nil
+		}
+		i += n
+	}
+}
@@ -0,0 +1,50 @@
+package buffer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// Size is the capacity of pooled buffer, enough for a datagram
+	Size = 1500
+)
+
+var _pool = sync.Pool{New: func() interface{} {
+	return &Buffer{B: make([]byte, Size)}
+}}
+
+// Buffer is a reference counted byte slice, it goes back to pool when the last holder releases it
+type Buffer struct {
+	B    []byte
+	refs int32
+}
+
+// Get returns a buffer of Size bytes held once by the caller
+func Get() *Buffer {
+	b := _pool.Get().(*Buffer)
+	b.B = b.B[:Size]
+	b.refs = 1
+	return b
+}
+
+// Retain adds a holder, the holder must call Release when it is done
+func (b *Buffer) Retain() *Buffer {
+	atomic.AddInt32(&b.refs, 1)
+	return b
+}
+
+// Release drops a holder, b must not be used by the caller afterwards
+func (b *Buffer) Release() {
+	switch n := atomic.AddInt32(&b.refs, -1); {
+	case n == 0:
+		_pool.Put(b)
+	case n < 0:
+		panic("buffer: release of a free buffer")
+	}
+}
+
+// Refs returns the count of holders
+func (b *Buffer) Refs() int32 {
+	return atomic.LoadInt32(&b.refs)
+}
@@ -0,0 +1,46 @@
+package buffer
+
+import (
+	"testing"
+)
+
+func TestBuffer(t *testing.T) {
+	b := Get()
+	if len(b.B) != Size || b.Refs() != 1 {
+		t.Fatalf("unexpected new buffer: %d bytes, %d refs", len(b.B), b.Refs())
+	}
+	b.Retain().Retain()
+	b.Release()
+	b.Release()
+	if b.Refs() != 1 {
+		t.Errorf("buffer should be held once, got %d", b.Refs())
+	}
+	b.Release()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("release of a free buffer should panic")
+		}
+	}()
+	b.Release()
+}
+
+func BenchmarkGet(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := Get()
+		buf.B[0] = byte(i)
+		buf.Release()
+	}
+}
+
+func BenchmarkMake(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := make([]byte, Size)
+		buf[0] = byte(i)
+		_sink = buf
+	}
+}
+
+var _sink []byte
@@ -3,14 +3,15 @@ package log
 import (
 	"os"
 
-	"github.com/beleege/gosrt/config"
 	logger "github.com/sirupsen/logrus"
 )
 
-var l *logger.Logger
+// l works before InitLog, for embedding as a library
+var l = logger.New()
 
-func InitLog() {
-	level, err := logger.ParseLevel(config.GetLogLevel())
+// InitLog logs to stdout from level, it panics if level is unknown
+func InitLog(level string) {
+	lv, err := logger.ParseLevel(level)
 	if err != nil {
 		panic(err)
 	}
@@ -19,7 +20,7 @@ func InitLog() {
 		Out:       os.Stdout,
 		Formatter: &formatter,
 		Hooks:     make(logger.LevelHooks),
-		Level:     level,
+		Level:     lv,
 	}
 }
 
@@ -1,12 +1,28 @@
 package pool
 
 import (
+	"hash/fnv"
+	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/beleege/gosrt/util/log"
 	"github.com/pkg/errors"
 )
 
+const (
+	_defaultWorkers   = 10
+	_defaultQueueSize = 1024
+	// a removed key refuses jobs this long, late jobs of a gone session do not bring it back
+	_removedTTL = time.Minute
+)
+
+var (
+	ErrQueueFull = errors.New("queue is full")
+	ErrClosed    = errors.New("pool is closed")
+	ErrRemoved   = errors.New("key is removed")
+)
+
 type Task func(args ...interface{}) error
 
 type Job interface {
@@ -14,81 +30,252 @@ type Job interface {
 	GetTask() Task
 }
 
+// Releaser is a Job holding resources, Release is called instead of running it if the job is skipped
+type Releaser interface {
+	Job
+	Release()
+}
+
+// Runner is a Job running itself, it saves building a Task per job
+type Runner interface {
+	Job
+	Run() error
+}
+
+// Policy decides what Execute does when the queue of a worker is full
+type Policy int
+
+const (
+	// Block waits for room, pushing back on the caller
+	Block Policy = iota
+	// Drop gives up the job and returns ErrQueueFull
+	Drop
+)
+
+// ParsePolicy parses "block" or "drop", others are Block
+func ParsePolicy(s string) Policy {
+	if s == "drop" {
+		return Drop
+	}
+	return Block
+}
+
+type Options struct {
+	// workers handling jobs, jobs of a key always go to the same one
+	Workers int
+	// jobs queued per worker
+	QueueSize int
+	Policy    Policy
+}
+
+func DefaultOptions() *Options {
+	return &Options{Workers: _defaultWorkers, QueueSize: _defaultQueueSize, Policy: Block}
+}
+
+// entry is a queued job, it is skipped if its key is removed after queued
+type entry struct {
+	job Job
+	gen uint64
+}
+
 type worker struct {
-	id    string
-	queue chan Job
+	queue chan entry
 }
 
-func (w *worker) submit(j Job) {
-	if j == nil {
-		return
+func (w *worker) start(p *Pool) {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case e := <-w.queue:
+			w.handle(p, e)
+		case <-p.done:
+			// finish the queued jobs
+			for {
+				select {
+				case e := <-w.queue:
+					w.handle(p, e)
+				default:
+					return
+				}
+			}
+		}
 	}
+}
 
-	w.queue <- j
+func (w *worker) handle(p *Pool, e entry) {
+	if p.alive(e.job.GetID(), e.gen) {
+		run(e.job)
+		return
+	}
+	if r, ok := e.job.(Releaser); ok {
+		r.Release()
+	}
 }
 
-func (w *worker) start() error {
-	for j := range w.queue {
-		if err := j.GetTask()(); err != nil {
-			log.Errorf("job[%s] execute fail: %s", j.GetID(), err.Error())
-			return err
+// run executes job, a panic of job is recovered so that worker keeps going
+func run(j Job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("job[%s] panic: %v\n%s", j.GetID(), r, debug.Stack())
 		}
+	}()
+	var err error
+	if r, ok := j.(Runner); ok {
+		err = r.Run()
+	} else {
+		err = j.GetTask()()
+	}
+	if err != nil {
+		log.Errorf("job[%s] execute fail: %s", j.GetID(), err.Error())
 	}
-	return nil
 }
 
+// Pool serializes jobs of a key on a fixed number of sharded workers
 type Pool struct {
-	size int
-	used int
-	m    *sync.Map
+	workers []*worker
+	policy  Policy
+	wg      sync.WaitGroup
+	// guards keys
+	mu   sync.RWMutex
+	keys map[string]uint64
+	gen  uint64
+	// removal time of keys
+	removed map[string]time.Time
+	// closed when pool is cleared
+	done chan struct{}
+	once sync.Once
 }
 
-func NewFixedSizePool(s int) *Pool {
+func New(opts *Options) *Pool {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
 	p := new(Pool)
-	p.size = s
-	p.m = &sync.Map{}
+	n := opts.Workers
+	if n <= 0 {
+		n = _defaultWorkers
+	}
+	size := opts.QueueSize
+	if size <= 0 {
+		size = _defaultQueueSize
+	}
+	p.policy = opts.Policy
+	p.keys = make(map[string]uint64)
+	p.removed = make(map[string]time.Time)
+	p.done = make(chan struct{})
+	p.workers = make([]*worker, n)
+	for i := range p.workers {
+		w := new(worker)
+		w.queue = make(chan entry, size)
+		p.workers[i] = w
+		p.wg.Add(1)
+		go w.start(p)
+	}
 	return p
 }
 
+func NewFixedSizePool(s int) *Pool {
+	opts := DefaultOptions()
+	opts.Workers = s
+	return New(opts)
+}
+
 func (p *Pool) Execute(j Job) error {
 	key := j.GetID()
 	if len(key) == 0 {
 		return errors.New("key is empty")
 	}
+	select {
+	case <-p.done:
+		return ErrClosed
+	default:
+	}
 
-	var w *worker
-	v, ok := p.m.Load(key)
+	gen, ok := p.register(key)
 	if !ok {
-		if p.used+1 > p.size {
-			return errors.Errorf("overcome capacity[%d]", p.size)
+		return ErrRemoved
+	}
+	w := p.workers[shard(key, len(p.workers))]
+	e := entry{job: j, gen: gen}
+	if p.policy == Drop {
+		select {
+		case w.queue <- e:
+			return nil
+		default:
+			return ErrQueueFull
 		}
-		p.used++
-
-		w = new(worker)
-		w.id = j.GetID()
-		w.queue = make(chan Job)
-		p.m.Store(key, w)
-		go w.start()
-	} else {
-		w = v.(*worker)
 	}
-	w.submit(j)
-
-	return nil
+	select {
+	case w.queue <- e:
+		return nil
+	case <-p.done:
+		return ErrClosed
+	}
 }
 
+// Remove releases key, jobs of key still queued are skipped and new ones are refused
 func (p *Pool) Remove(key string) {
-	v, ok := p.m.LoadAndDelete(key)
-	if ok {
-		p.used--
-		close(v.(*worker).queue)
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.keys, key)
+	for k, t := range p.removed {
+		if now.Sub(t) > _removedTTL {
+			delete(p.removed, k)
+		}
 	}
+	p.removed[key] = now
 }
 
+// Len returns the count of keys alive
+func (p *Pool) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.keys)
+}
+
+// Clear stops workers after the queued jobs are done
 func (p *Pool) Clear() {
-	p.m.Range(func(k, v interface{}) bool {
-		close(v.(*worker).queue)
-		return true
+	p.once.Do(func() {
+		close(p.done)
 	})
-	p.m = nil
+	p.wg.Wait()
+}
+
+// register returns the generation of key, false if key is removed
+func (p *Pool) register(key string) (uint64, bool) {
+	p.mu.RLock()
+	gen, ok := p.keys[key]
+	p.mu.RUnlock()
+	if ok {
+		return gen, true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok = p.removed[key]; ok {
+		return 0, false
+	}
+	if gen, ok = p.keys[key]; !ok {
+		p.gen++
+		gen = p.gen
+		p.keys[key] = gen
+	}
+	return gen, true
+}
+
+func (p *Pool) alive(key string, gen uint64) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.keys[key] == gen
+}
+
+func shard(key string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
 }
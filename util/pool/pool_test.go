@@ -0,0 +1,128 @@
+package pool
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type job struct {
+	id string
+	f  func() error
+}
+
+func (j *job) GetID() string {
+	return j.id
+}
+
+func (j *job) GetTask() Task {
+	return func(args ...interface{}) error {
+		return j.f()
+	}
+}
+
+func TestOrder(t *testing.T) {
+	p := New(&Options{Workers: 4, QueueSize: 16})
+	var mu sync.Mutex
+	got := make(map[string][]int)
+	// far more keys than workers
+	for i := 0; i < 100; i++ {
+		for k := 0; k < 50; k++ {
+			id, n := strconv.Itoa(k), i
+			err := p.Execute(&job{id: id, f: func() error {
+				mu.Lock()
+				got[id] = append(got[id], n)
+				mu.Unlock()
+				return nil
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	p.Clear()
+
+	for k, list := range got {
+		if len(list) != 100 {
+			t.Fatalf("key %s handled %d jobs", k, len(list))
+		}
+		for i, n := range list {
+			if n != i {
+				t.Fatalf("key %s handled job %d at %d", k, n, i)
+			}
+		}
+	}
+	if err := p.Execute(&job{id: "0", f: func() error { return nil }}); err != ErrClosed {
+		t.Errorf("closed pool should refuse job, got %v", err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	p := New(&Options{Workers: 1, QueueSize: 4})
+	defer p.Clear()
+
+	done := make(chan struct{})
+	_ = p.Execute(&job{id: "a", f: func() error { panic("boom") }})
+	_ = p.Execute(&job{id: "a", f: func() error { return ErrQueueFull }})
+	_ = p.Execute(&job{id: "a", f: func() error { close(done); return nil }})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("worker should survive panic and error")
+	}
+}
+
+func TestDropAndRemove(t *testing.T) {
+	p := New(&Options{Workers: 1, QueueSize: 2, Policy: Drop})
+	defer p.Clear()
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	_ = p.Execute(&job{id: "a", f: func() error { close(started); <-block; return nil }})
+	<-started
+
+	ran := make(chan string, 4)
+	skipped := &releaser{job: job{id: "a", f: func() error { ran <- "a"; return nil }}}
+	if err := p.Execute(skipped); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Execute(&job{id: "b", f: func() error { ran <- "b"; return nil }}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Execute(&job{id: "b", f: func() error { return nil }}); err != ErrQueueFull {
+		t.Errorf("full queue should drop job, got %v", err)
+	}
+	if p.Len() != 2 {
+		t.Errorf("pool should hold 2 keys, got %d", p.Len())
+	}
+
+	// queued job of a removed key is skipped
+	p.Remove("a")
+	close(block)
+	select {
+	case id := <-ran:
+		if id != "b" {
+			t.Errorf("job of removed key %s is handled", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("job is not handled")
+	}
+	if !skipped.released {
+		t.Error("skipped job should be released")
+	}
+	// a late job does not bring the removed key back
+	if err := p.Execute(&job{id: "a", f: func() error { return nil }}); err != ErrRemoved || p.Len() != 1 {
+		t.Errorf("removed key should refuse job, got %v", err)
+	}
+}
+
+// releaser records if it is released instead of run
+type releaser struct {
+	job
+	released bool
+}
+
+func (r *releaser) Release() {
+	r.released = true
+}
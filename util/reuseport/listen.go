@@ -0,0 +1,42 @@
+package reuseport
+
+import (
+	"context"
+	"net"
+
+	"github.com/beleege/gosrt/util/log"
+	"github.com/pkg/errors"
+)
+
+// ListenUDP binds n sockets to addr, kernel spreads peers over them by the hash of address and port,
+// so that datagrams of a peer always reach the same socket
+func ListenUDP(network, addr string, n int) ([]*net.UDPConn, error) {
+	if n <= 1 {
+		n = 1
+	} else if !supported {
+		log.Errorf("SO_REUSEPORT is not supported, listen on 1 socket")
+		n = 1
+	}
+
+	lc := net.ListenConfig{}
+	if n > 1 {
+		lc.Control = control
+	}
+	conns := make([]*net.UDPConn, 0, n)
+	for i := 0; i < n; i++ {
+		pc, err := lc.ListenPacket(context.Background(), network, addr)
+		if err != nil {
+			for _, c := range conns {
+				_ = c.Close()
+			}
+			return nil, errors.WithStack(err)
+		}
+		c := pc.(*net.UDPConn)
+		if i == 0 {
+			// port 0 is resolved by the first bind, the others join it
+			addr = c.LocalAddr().String()
+		}
+		conns = append(conns, c)
+	}
+	return conns, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package reuseport
+
+import (
+	"syscall"
+)
+
+const supported = false
+
+func control(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package reuseport
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenUDP(t *testing.T) {
+	const n = 4
+	conns, err := ListenUDP("udp", "127.0.0.1:0", n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}()
+	if supported && len(conns) != n {
+		t.Fatalf("expected %d sockets, got %d", n, len(conns))
+	}
+	addr := conns[0].LocalAddr().String()
+	for _, c := range conns {
+		if c.LocalAddr().String() != addr {
+			t.Fatalf("socket is bound to %s, expected %s", c.LocalAddr(), addr)
+		}
+	}
+
+	// every peer sends some datagrams, all of them reach one socket
+	type arrival struct {
+		socket int
+		peer   string
+	}
+	arrivals := make(chan arrival, 256)
+	for i, c := range conns {
+		go func(i int, c *net.UDPConn) {
+			buf := make([]byte, 16)
+			for {
+				_, from, err := c.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				arrivals <- arrival{i, from.String()}
+			}
+		}(i, c)
+	}
+	const peers, count = 16, 4
+	for i := 0; i < peers; i++ {
+		p, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < count; j++ {
+			_, _ = p.Write([]byte{byte(j)})
+		}
+		defer p.Close()
+	}
+
+	owner := make(map[string]int)
+	for got := 0; got < peers*count; got++ {
+		select {
+		case a := <-arrivals:
+			if o, ok := owner[a.peer]; ok && o != a.socket {
+				t.Errorf("peer %s reaches socket %d and %d", a.peer, o, a.socket)
+			}
+			owner[a.peer] = a.socket
+		case <-time.After(time.Second):
+			t.Fatalf("only %d datagrams arrive", got)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package seqno
 
 import (
-	"math"
 	"math/rand"
 )
 
@@ -10,28 +9,34 @@ const (
 	_maxSequenceNo = 0x7FFFFFFF
 )
 
-func Compare(n1, n2 uint32) uint32 {
-	if math.Abs(float64(n1-n2)) < _maxOffset {
-		return n1 - n2
+// Compare returns a negative value if n1 is before n2 in the 31 bits sequence space,
+// zero if they are equal and a positive value if n1 is after n2
+func Compare(n1, n2 uint32) int32 {
+	if abs(int64(n1)-int64(n2)) < _maxOffset {
+		return int32(n1 - n2)
 	}
-	return n2 - n1
+	if n1 < n2 {
+		return int32(n1 - n2 + _maxSequenceNo + 1)
+	}
+	return int32(n1 - n2 - _maxSequenceNo - 1)
 }
 
+// Length returns the count of sequence numbers from n1 to n2 inclusive
 func Length(n1, n2 uint32) uint32 {
-	if n1 < n2 {
+	if n1 <= n2 {
 		return n2 - n1 + 1
 	}
 	return n2 - n1 + _maxSequenceNo + 2
 }
 
-func SeqOffset(n1, n2 uint32) uint32 {
-	if math.Abs(float64(n1-n2)) < _maxOffset {
-		return n2 - n1
-	}
-	if n1 < n2 {
-		return n2 - n1 - _maxSequenceNo - 1
-	}
-	return n2 - n1 + _maxSequenceNo + 1
+// SeqOffset returns the distance from n1 to n2, negative if n2 is before n1
+func SeqOffset(n1, n2 uint32) int32 {
+	return Compare(n2, n1)
+}
+
+// Add moves n forward by offset, or backward if offset is negative
+func Add(n uint32, offset int32) uint32 {
+	return uint32(int64(n)+int64(offset)) & _maxSequenceNo
 }
 
 func Increment(n uint32) uint32 {
@@ -51,3 +56,10 @@ func Decrement(n uint32) uint32 {
 func Random() uint32 {
 	return (rand.Uint32() % _maxOffset) + 1
 }
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
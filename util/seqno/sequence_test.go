@@ -0,0 +1,36 @@
+package seqno
+
+import (
+	"testing"
+)
+
+func TestSeqOffset(t *testing.T) {
+	cases := []struct {
+		n1, n2 uint32
+		offset int32
+	}{
+		{100, 105, 5},
+		{105, 100, -5},
+		{_maxSequenceNo, 0, 1},
+		{0, _maxSequenceNo, -1},
+		{_maxSequenceNo - 2, 3, 6},
+		{3, _maxSequenceNo - 2, -6},
+	}
+	for _, c := range cases {
+		if o := SeqOffset(c.n1, c.n2); o != c.offset {
+			t.Errorf("offset from %d to %d is %d, want %d", c.n1, c.n2, o, c.offset)
+		}
+		if n := Add(c.n1, c.offset); n != c.n2 {
+			t.Errorf("%d add %d is %d, want %d", c.n1, c.offset, n, c.n2)
+		}
+	}
+}
+
+func TestLength(t *testing.T) {
+	if l := Length(7, 7); l != 1 {
+		t.Errorf("length is %d, want 1", l)
+	}
+	if l := Length(_maxSequenceNo, 1); l != 3 {
+		t.Errorf("length is %d, want 3", l)
+	}
+}
@@ -0,0 +1,130 @@
+package window
+
+import (
+	"sync"
+
+	"github.com/beleege/gosrt/protocol/srt"
+	"github.com/beleege/gosrt/util/buffer"
+	"github.com/beleege/gosrt/util/seqno"
+)
+
+const (
+	_maxMsgNo = 0x03FFFFFF
+)
+
+// SendBuffer keeps sent pkgs until they are acknowledged, so that lost ones can be retransmitted
+type SendBuffer struct {
+	mu sync.Mutex
+	// ring buffer
+	ring []*srt.DataPacket
+	// ring index of the oldest pkg not acknowledged
+	head int
+	// seq no of the oldest pkg not acknowledged
+	first uint32
+	// pkgs held in buffer
+	count int
+	// seq no for next pkg
+	next uint32
+	// msg no for next pkg
+	msgNo uint32
+	// pkgs given up before acknowledged
+	dropped uint64
+}
+
+func NewSendBuffer(size int, isn uint32) *SendBuffer {
+	b := new(SendBuffer)
+	b.ring = make([]*srt.DataPacket, size)
+	b.first = isn
+	b.next = isn
+	b.msgNo = 1
+	return b
+}
+
+// Next builds a pkg of payload in buf with the next seq no and keeps it, the oldest pkg is given up if buffer is full.
+// The hold of buf is released once pkg is acknowledged or given up, pkg is read only once returned
+func (b *SendBuffer) Next(buf *buffer.Buffer, payload []byte, ts, sid uint32) *srt.DataPacket {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.count == len(b.ring) {
+		b.release(1)
+		b.dropped++
+	}
+	p := new(srt.DataPacket)
+	p.SequenceNum = b.next
+	p.MsgNum = b.msgNo
+	// live mode sends a whole message in one pkg
+	p.PP = 3
+	p.Content = payload
+	p.Buf = buf
+	p.Timestamp = ts
+	p.SocketID = sid
+
+	b.ring[(b.head+b.count)%len(b.ring)] = p
+	b.count++
+	b.next = seqno.Increment(b.next)
+	b.msgNo = b.msgNo%_maxMsgNo + 1
+	return p
+}
+
+// Ack releases pkgs before seq
+func (b *SendBuffer) Ack(seq uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if n := int(seqno.SeqOffset(b.first, seq)); n > 0 {
+		b.release(n)
+	}
+}
+
+// Get returns the pkg of seq retained, caller releases it when done
+func (b *SendBuffer) Get(seq uint32) *srt.DataPacket {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	off := int(seqno.SeqOffset(b.first, seq))
+	if off < 0 || off >= b.count {
+		return nil
+	}
+	p := b.ring[(b.head+off)%len(b.ring)]
+	p.Retain()
+	return p
+}
+
+// Bounds returns the seq no of the oldest pkg not acknowledged and the seq no for next pkg
+func (b *SendBuffer) Bounds() (uint32, uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.first, b.next
+}
+
+func (b *SendBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.count
+}
+
+// Dropped returns the count of pkgs given up before acknowledged
+func (b *SendBuffer) Dropped() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.dropped
+}
+
+// warn: need lock protect
+func (b *SendBuffer) release(n int) {
+	if n > b.count {
+		n = b.count
+	}
+	for i := 0; i < n; i++ {
+		idx := (b.head + i) % len(b.ring)
+		b.ring[idx].Release()
+		b.ring[idx] = nil
+	}
+	b.head = (b.head + n) % len(b.ring)
+	b.first = seqno.Add(b.first, int32(n))
+	b.count -= n
+}
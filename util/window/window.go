@@ -1,40 +1,47 @@
 package window
 
 import (
-	"math"
 	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/beleege/gosrt/protocol/srt"
+	"github.com/beleege/gosrt/util/seqno"
+)
+
+const (
+	_defaultLatency = 120 * time.Millisecond
+	_deliverPeriod  = 10 * time.Millisecond
+	_nakPeriod      = 120 * time.Millisecond
+	// samples kept for receive speed and bandwidth estimate
+	_samples = 16
 )
 
 type NoLossAction func(seq uint32)
 
+// Entity is the receive window, a fixed capacity ring buffer indexed by the seq no offset from the ACK point
 type Entity struct {
 	// update lock
 	mu sync.Mutex
-	// drop task condition
-	cond *sync.Cond
-	// window size
-	size int
-	// used window size
-	used int
-	// maintain list
-	list []node
-	// dirty list
-	dirty []node
-	// map to list
-	dict map[int]*node
-	// initial seq no
-	first uint32
-	// last seq no
-	last uint32
-	// initial timestamp
+	// ring buffer
+	ring []node
+	// ring index of ACK point
+	head int
+	// seq no of ACK point, the first pkg not delivered yet
+	ackSeq uint32
+	// offsets in [0, span) are received or lost
+	span int
+	// pkgs held in window
+	count int
+	// first pkg has arrived
+	started bool
+	// monotonic clock base
+	epoch time.Time
+	// first pkg arrival timestamp in microseconds
 	ts int64
-	// pkg add counter
-	counter uint64
+	// TSBPD latency in microseconds
+	latency int64
 	// pkg add event
 	eventChan chan struct{}
 	// loss channel
@@ -43,16 +50,47 @@ type Entity struct {
 	batchChan chan []*srt.DataPacket
 	// loss monitor start
 	lossMon int32
-	// no loss pkg in window action, normally for ack
+	// pkgs delivered in window action, normally for ack
 	act NoLossAction
+	// drop too late pkgs, negotiated by TLPKTDROP
+	drop bool
+	// report loss periodically, negotiated by NAKREPORT
+	periodicNAK bool
+	// R flag of pkg is valid, negotiated by REXMITFLG
+	rexmit bool
+	// pkg arrival counters
+	counters Counters
+	// arrival time of the last pkg
+	lastArrival int64
+	// intervals between pkg arrivals, ring of _samples
+	arrivals []int64
+	// arrival time of the first pkg of a probing pair
+	probe int64
+	// intervals between pkgs of probing pairs, ring of _samples
+	probes []int64
+	// stop delivery and close channels
+	done   chan struct{}
+	closed bool
+}
+
+type Counters struct {
+	Received      uint64 // pkgs arrived, duplicate and belated ones included
+	Bytes         uint64 // payload bytes arrived
+	Lost          uint64 // pkgs detected lost by a gap of seq no
+	Retransmitted uint64 // pkgs arrived with R flag
+	Recovered     uint64 // lost pkgs filled by retransmission
+	Reordered     uint64 // lost pkgs filled by the original transmission arriving out of order
+	Duplicate     uint64 // pkgs arrived more than once
+	Belated       uint64 // pkgs arrived after their position was delivered or dropped
+	Dropped       uint64 // lost pkgs given up after the latency passed
 }
 
 type node struct {
 	pkg *srt.DataPacket
-	// create time
+	// arrival time of pkg, or loss detection time if pkg is nil
 	t int64
-	// is pkg loss
-	loss bool
+	// pkg is given up by sender, it is not waited for
+	dropped bool
 }
 
 type LossRange struct {
@@ -62,27 +100,98 @@ type LossRange struct {
 
 func New(size int, act NoLossAction) *Entity {
 	p := new(Entity)
-	p.size = size
-	p.list = make([]node, size*2)
-	p.dirty = p.list[:]
-	p.dict = make(map[int]*node)
-	p.eventChan = make(chan struct{})
+	p.ring = make([]node, size)
+	p.epoch = time.Now()
+	p.latency = _defaultLatency.Microseconds()
+	p.eventChan = make(chan struct{}, 1)
+	p.done = make(chan struct{})
 	p.lossChan = make(chan []LossRange, 2048)
 	p.batchChan = make(chan []*srt.DataPacket, 2048)
-
-	p.mu = sync.Mutex{}
-	p.cond = sync.NewCond(&p.mu)
 	p.act = act
+	p.drop = true
+	p.periodicNAK = true
+	p.arrivals = make([]int64, 0, _samples)
+	p.probes = make([]int64, 0, _samples)
 
 	go p.onPkgAdd()
 
 	return p
 }
 
+// Close stops delivery and closes loss channel, batch channel is closed once delivery stops
+func (u *Entity) Close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed {
+		return
+	}
+	u.closed = true
+	close(u.done)
+	u.release()
+	close(u.lossChan)
+}
+
+func (u *Entity) SetDrop(drop bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.drop = drop
+}
+
+func (u *Entity) SetPeriodicNAK(periodic bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.periodicNAK = periodic
+}
+
+func (u *Entity) SetRexmitFlag(rexmit bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.rexmit = rexmit
+}
+
+func (u *Entity) SetLatency(d time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.latency = d.Microseconds()
+}
+
+func (u *Entity) Counters() Counters {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.counters
+}
+
+// Rates returns the receive speed and estimated link bandwidth in pkgs per second,
+// both are medians of the recent arrival intervals, zero until sampled
+func (u *Entity) Rates() (speed, bandwidth uint32) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return perSecond(u.arrivals), perSecond(u.probes)
+}
+
+// TS returns the arrival time of the first pkg in microseconds of the window clock
 func (u *Entity) TS() int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
 	return u.ts
 }
 
+// AckSeq returns the seq no of the first pkg not delivered yet
+func (u *Entity) AckSeq() uint32 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.ackSeq
+}
+
 func (u *Entity) ListenLoss() chan []LossRange {
 	return u.lossChan
 }
@@ -91,194 +200,334 @@ func (u *Entity) ListenBatch() chan []*srt.DataPacket {
 	return u.batchChan
 }
 
+// Append puts pkg into window, returns false if it is beyond the window capacity.
+// A pkg kept by window is retained, and the consumer of batch releases it
 func (u *Entity) Append(p *srt.DataPacket) bool {
-	now := time.Now().Unix()
-	u.cond.L.Lock()
-	defer u.cond.L.Unlock()
+	now := u.now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
 
-	if u.used >= u.size {
+	if u.closed {
 		return false
 	}
-	u.counter++
-
-	// pkg in event
-	u.eventChan <- struct{}{}
-
-	if u.used == 0 {
+	if !u.started {
+		u.started = true
+		u.ackSeq = p.SequenceNum
 		u.ts = now
-		u.first = p.SequenceNum
-		u.last = p.SequenceNum
-		u.dirty[0].t = u.ts
-		u.dirty[0].pkg = p
-		u.used++
-	} else {
-		pos := p.SequenceNum - u.first
-		if pos < 0 {
+	}
+	u.counters.Received++
+	u.counters.Bytes += uint64(len(p.Content))
+	if p.R {
+		u.counters.Retransmitted++
+	}
+	u.sample(p.SequenceNum, now)
+
+	off := int(seqno.SeqOffset(u.ackSeq, p.SequenceNum))
+	if off < 0 {
+		u.counters.Belated++
+		return true
+	} else if off >= len(u.ring) {
+		// burst overflow, pkg will be reported as loss once the window moves on
+		return false
+	}
+
+	n := u.at(off)
+	if off < u.span {
+		if n.pkg != nil {
+			u.counters.Duplicate++
 			return true
-		} else if int(pos) < u.used-1 {
-			u.dirty[pos].pkg = p
-			u.dirty[pos].loss = false
-
-			delete(u.dict, int(pos))
-		} else if int(pos) == u.used-1 {
-			u.dirty[u.used-1].loss = false
-			u.dirty[u.used-1].t = now
-			u.dirty[u.used-1].pkg = p
-			u.last = p.SequenceNum
-			u.used++
+		}
+		if !u.rexmit || p.R {
+			u.counters.Recovered++
 		} else {
-			for i, n := u.used, int(pos); i < n; i++ {
-				u.dirty[i].loss = true
-				u.dirty[i].pkg = nil
-				u.dirty[i].t = now
-				u.dict[i] = &u.dirty[i]
-				u.used++
+			u.counters.Reordered++
+		}
+	} else {
+		if off > u.span {
+			for i := u.span; i < off; i++ {
+				u.at(i).t = now
 			}
-			u.dirty[int(pos)].loss = false
-			u.dirty[int(pos)].t = now
-			u.dirty[int(pos)].pkg = p
-			u.last = p.SequenceNum
-			u.used++
-
-			// start loss monitor
-			go u.lossMonitor()
+			u.counters.Lost += uint64(off - u.span)
+			u.report([]LossRange{{Start: seqno.Add(u.ackSeq, int32(u.span)), End: seqno.Decrement(p.SequenceNum)}})
 		}
+		u.span = off + 1
+	}
+	p.Retain()
+	n.pkg = p
+	n.t = now
+	u.count++
+
+	// pkg in event
+	select {
+	case u.eventChan <- struct{}{}:
+	default:
 	}
 	return true
 }
 
+// Drop gives up the pkgs from first to last for a DROPREQ of sender, delivery skips them if they are missing
+func (u *Entity) Drop(first, last uint32) {
+	now := u.now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed || !u.started {
+		return
+	}
+	start := int(seqno.SeqOffset(u.ackSeq, first))
+	end := int(seqno.SeqOffset(u.ackSeq, last))
+	if start < 0 {
+		start = 0
+	}
+	// pkgs after a gap not detected yet are left to loss detection
+	if start > u.span || end < start {
+		return
+	}
+	if end >= len(u.ring) {
+		end = len(u.ring) - 1
+	}
+	for off := start; off <= end; off++ {
+		n := u.at(off)
+		if off >= u.span {
+			n.t = now
+		}
+		if n.pkg == nil {
+			n.dropped = true
+		}
+	}
+	if end >= u.span {
+		u.span = end + 1
+	}
+	select {
+	case u.eventChan <- struct{}{}:
+	default:
+	}
+}
+
 func (u *Entity) IsFull() bool {
 	u.mu.Lock()
 	defer u.mu.Unlock()
 
-	return u.used == u.size && len(u.dict) == 0
+	return u.span == len(u.ring)
 }
 
+// Len returns the count of pkgs held in window
 func (u *Entity) Len() uint32 {
-	return uint32(len(u.dirty))
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return uint32(u.count)
 }
 
 func (u *Entity) Loss() []LossRange {
 	u.mu.Lock()
 	defer u.mu.Unlock()
 
-	if len(u.dict) > 0 {
-		nos := make([]int, 0, len(u.dict))
-		for i := range u.dict {
-			nos = append(nos, i)
+	return u.loss()
+}
+
+// warn: need lock protect
+func (u *Entity) loss() []LossRange {
+	ranges := make([]LossRange, 0)
+	for i := 0; i < u.span; i++ {
+		if n := u.at(i); n.pkg != nil || n.dropped {
+			continue
 		}
-		if len(nos) == 1 {
-			return []LossRange{{Start: uint32(nos[0]) + u.first, End: uint32(nos[0]) + u.first}}
+		start := i
+		for i+1 < u.span && u.at(i+1).pkg == nil && !u.at(i+1).dropped {
+			i++
 		}
+		ranges = append(ranges, LossRange{Start: seqno.Add(u.ackSeq, int32(start)), End: seqno.Add(u.ackSeq, int32(i))})
+	}
+	return ranges
+}
 
-		sort.Ints(nos)
-		ranges := make([]LossRange, 0, 4)
-		no := nos[0]
-		for idx := 1; idx <= len(nos); idx++ {
-			if idx < len(nos) && nos[idx]-1 == nos[idx-1] {
-				continue
-			}
-			ranges = append(ranges, LossRange{Start: uint32(no) + u.first, End: uint32(nos[idx-1]) + u.first})
-			if idx < len(nos) {
-				no = nos[idx]
-			}
+// sample records the arrival of pkg, every 16th and the next pkg are sent back to back
+// by sender as a probing pair, their interval shows the link capacity.
+// warn: need lock protect
+func (u *Entity) sample(seq uint32, now int64) {
+	if u.lastArrival > 0 {
+		u.arrivals = push(u.arrivals, now-u.lastArrival)
+	}
+	u.lastArrival = now
+	switch seq & 0xF {
+	case 0:
+		u.probe = now
+	case 1:
+		if u.probe > 0 {
+			u.probes = push(u.probes, now-u.probe)
+			u.probe = 0
 		}
-		return ranges
+	default:
+		u.probe = 0
 	}
-	return make([]LossRange, 0)
+}
+
+// push appends v to the ring of _samples
+func push(ring []int64, v int64) []int64 {
+	if len(ring) < _samples {
+		return append(ring, v)
+	}
+	copy(ring, ring[1:])
+	ring[len(ring)-1] = v
+	return ring
+}
+
+// perSecond converts the median of intervals in microseconds to a rate
+func perSecond(intervals []int64) uint32 {
+	if len(intervals) == 0 {
+		return 0
+	}
+	sorted := make([]int64, len(intervals))
+	copy(sorted, intervals)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+	if median <= 0 {
+		median = 1
+	}
+	return uint32(1000000 / median)
+}
+
+// warn: need lock protect
+func (u *Entity) at(off int) *node {
+	return &u.ring[(u.head+off)%len(u.ring)]
 }
 
 // warn: need lock protect
-func (u *Entity) reset() {
-	u.ts = 0
-	u.first = 0
-	u.dirty = u.list[:]
-	u.used = 0
-	for i := range u.dirty {
-		u.dirty[i].pkg = nil
-		u.dirty[i].t = 0
-		u.dirty[i].loss = false
+func (u *Entity) report(loss []LossRange) {
+	select {
+	case u.lossChan <- loss:
+	default:
 	}
-	if len(u.dict) > 0 {
-		u.dict = make(map[int]*node)
+	if u.periodicNAK {
+		go u.lossMonitor()
 	}
 }
 
+// release drops pkgs held in window
+// warn: need lock protect
+func (u *Entity) release() {
+	for i := 0; i < u.span; i++ {
+		if n := u.at(i); n.pkg != nil {
+			n.pkg.Release()
+			*n = node{}
+		}
+	}
+	u.count = 0
+}
+
+func (u *Entity) now() int64 {
+	return time.Since(u.epoch).Microseconds()
+}
+
 func (u *Entity) lossMonitor() {
 	if !atomic.CompareAndSwapInt32(&u.lossMon, 0, 1) {
 		return
 	}
-	defer func() {
-		u.lossMon = 0
-	}()
+	defer atomic.StoreInt32(&u.lossMon, 0)
 
-	timer := time.NewTimer(120 * time.Millisecond)
+	timer := time.NewTimer(_nakPeriod)
+	defer timer.Stop()
 	for {
 		select {
 		case <-timer.C:
-			// check loss
-			loss := u.Loss()
-			if len(loss) > 0 {
-				u.lossChan <- loss
-				timer.Reset(120 * time.Millisecond)
-			} else {
-				return
-			}
+		case <-u.done:
+			return
+		}
+		if !u.reportLoss() {
+			return
 		}
+		timer.Reset(_nakPeriod)
 	}
 }
 
+// reportLoss sends current loss, returns false if there is no loss
+func (u *Entity) reportLoss() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed {
+		return false
+	}
+	loss := u.loss()
+	if len(loss) == 0 {
+		return false
+	}
+	select {
+	case u.lossChan <- loss:
+	default:
+	}
+	return true
+}
+
+// onPkgAdd is the only sender of batch channel, a batch blocked by a slow consumer is dropped on close
 func (u *Entity) onPkgAdd() {
-	timer := time.NewTimer(10 * time.Millisecond)
+	timer := time.NewTimer(_deliverPeriod)
+	defer timer.Stop()
+	defer close(u.batchChan)
 
 	for {
-	LOOP:
 		select {
 		case <-timer.C:
-			timer.Reset(10 * time.Millisecond)
 		case <-u.eventChan:
 			if !timer.Stop() {
 				<-timer.C
 			}
-			timer.Reset(10 * time.Millisecond)
-			goto LOOP
+		case <-u.done:
+			return
 		}
+		timer.Reset(_deliverPeriod)
 
-		u.cond.L.Lock()
-		// check drop
-		now := time.Now().Unix()
-		offset := 0
-		for i := range u.dirty {
-			// a packet timestamp is older than 125% of the SRT latency
-			if u.dirty[i].loss && now-u.dirty[i].t >= 150 {
-				offset = int(math.Max(float64(offset), float64(i)))
-			}
+		arr := u.deliver(u.now())
+		if len(arr) == 0 {
+			continue
 		}
-		if offset > 0 {
-			u.dirty = u.dirty[offset+1:]
-			u.dict = make(map[int]*node)
-			for i := range u.dirty {
-				if u.dirty[i].loss {
-					u.dict[i] = &u.dirty[i]
-				}
+		select {
+		case u.batchChan <- arr:
+		case <-u.done:
+			for _, p := range arr {
+				p.Release()
 			}
+			return
 		}
-		// no loss and have some pkgs，delivery them
-		if len(u.dict) == 0 {
-			// do no loss action
-			go u.act(u.last)
-
-			arr := make([]*srt.DataPacket, 0, len(u.dirty))
-			for i := range u.dirty {
-				if u.dirty[i].pkg != nil {
-					arr = append(arr, u.dirty[i].pkg)
-				}
-			}
-			if len(arr) > 0 {
-				u.batchChan <- arr
-				u.reset()
+	}
+}
+
+// deliver takes the pkgs before the first unrecoverable loss out of window and moves ACK point
+func (u *Entity) deliver(now int64) []*srt.DataPacket {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed {
+		return nil
+	}
+	arr := make([]*srt.DataPacket, 0)
+	off := 0
+	for ; off < u.span; off++ {
+		n := u.at(off)
+		if n.pkg == nil {
+			// a loss older than 125% of the SRT latency is dropped
+			if !n.dropped && (!u.drop || now-n.t < u.latency*5/4) {
+				break
 			}
+			u.counters.Dropped++
+		} else {
+			arr = append(arr, n.pkg)
+			u.count--
 		}
-		u.cond.L.Unlock()
+		*n = node{}
+	}
+	if off == 0 {
+		return nil
+	}
+
+	u.head = (u.head + off) % len(u.ring)
+	u.ackSeq = seqno.Add(u.ackSeq, int32(off))
+	u.span -= off
+
+	// do ack action
+	if u.act != nil {
+		go u.act(seqno.Decrement(u.ackSeq))
 	}
+	return arr
 }
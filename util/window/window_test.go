@@ -1,8 +1,11 @@
 package window
 
 import (
-	"github.com/beleege/gosrt/protocol/srt"
+	"runtime"
 	"testing"
+	"time"
+
+	"github.com/beleege/gosrt/protocol/srt"
 )
 
 func TestWindow(t *testing.T) {
@@ -15,8 +18,8 @@ func TestWindow(t *testing.T) {
 	win.Append(&srt.DataPacket{SequenceNum: 104})
 	win.Append(&srt.DataPacket{SequenceNum: 107})
 	t.Logf("lost seqs: %+v", win.Loss())
-	win.reset()
-	t.Logf("win is %+v", win)
+	win.Close()
+	t.Logf("win len is %d", win.Len())
 }
 
 func TestFull(t *testing.T) {
@@ -26,3 +29,159 @@ func TestFull(t *testing.T) {
 	t.Logf("win is full: %v", win.IsFull())
 	t.Logf("lost seqs: %+v", win.Loss())
 }
+
+func TestDuplicate(t *testing.T) {
+	win := New(10, nil)
+	win.SetRexmitFlag(true)
+	win.Append(&srt.DataPacket{SequenceNum: 100})
+	win.Append(&srt.DataPacket{SequenceNum: 103})
+	win.Append(&srt.DataPacket{SequenceNum: 101, R: true})
+	win.Append(&srt.DataPacket{SequenceNum: 102})
+	win.Append(&srt.DataPacket{SequenceNum: 101, R: true})
+	win.Append(&srt.DataPacket{SequenceNum: 99, R: true})
+	c := win.Counters()
+	if c.Recovered != 1 || c.Reordered != 1 || c.Duplicate != 1 || c.Belated != 1 {
+		t.Errorf("unexpected counters: %+v", c)
+	}
+}
+
+func TestLoss(t *testing.T) {
+	win := New(16, nil)
+	win.Append(&srt.DataPacket{SequenceNum: 0x7FFFFFFE})
+	win.Append(&srt.DataPacket{SequenceNum: 1})
+	win.Append(&srt.DataPacket{SequenceNum: 4})
+	loss := win.Loss()
+	if len(loss) != 2 || loss[0] != (LossRange{Start: 0x7FFFFFFF, End: 0}) || loss[1] != (LossRange{Start: 2, End: 3}) {
+		t.Errorf("unexpected loss: %+v", loss)
+	}
+}
+
+func TestWraparound(t *testing.T) {
+	win := New(16, nil)
+	seqs := []uint32{0x7FFFFFFD, 0x7FFFFFFF, 0x7FFFFFFE, 1, 0, 2}
+	for _, seq := range seqs {
+		win.Append(&srt.DataPacket{SequenceNum: seq})
+	}
+	expect(t, win, []uint32{0x7FFFFFFD, 0x7FFFFFFE, 0x7FFFFFFF, 0, 1, 2})
+	if seq := win.AckSeq(); seq != 3 {
+		t.Errorf("ack seq is %d, want 3", seq)
+	}
+}
+
+func TestReorder(t *testing.T) {
+	win := New(16, nil)
+	seqs := []uint32{10, 13, 12, 15, 11, 14}
+	for _, seq := range seqs {
+		win.Append(&srt.DataPacket{SequenceNum: seq})
+	}
+	expect(t, win, []uint32{10, 11, 12, 13, 14, 15})
+	if c := win.Counters(); c.Recovered != 3 {
+		t.Errorf("unexpected counters: %+v", c)
+	}
+}
+
+func TestBurst(t *testing.T) {
+	win := New(4, nil)
+	for seq := uint32(0); seq < 8; seq++ {
+		if ok := win.Append(&srt.DataPacket{SequenceNum: seq}); ok != (seq < 4) {
+			t.Errorf("append %d returns %v", seq, ok)
+		}
+	}
+	if !win.IsFull() || win.Len() != 4 {
+		t.Errorf("window should be full, len is %d", win.Len())
+	}
+	expect(t, win, []uint32{0, 1, 2, 3})
+	if win.Len() != 0 {
+		t.Errorf("window should be empty, len is %d", win.Len())
+	}
+	// pkgs refused in burst become loss
+	win.Append(&srt.DataPacket{SequenceNum: 6})
+	loss := win.Loss()
+	if len(loss) != 1 || loss[0] != (LossRange{Start: 4, End: 5}) {
+		t.Errorf("unexpected loss: %+v", loss)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	win := New(16, nil)
+	win.SetLatency(time.Millisecond)
+	win.Append(&srt.DataPacket{SequenceNum: 0})
+	win.Append(&srt.DataPacket{SequenceNum: 2})
+	expect(t, win, []uint32{0, 2})
+	if c := win.Counters(); c.Dropped != 1 {
+		t.Errorf("unexpected counters: %+v", c)
+	}
+}
+
+func TestDropReq(t *testing.T) {
+	win := New(16, nil)
+	win.SetDrop(false)
+	win.Append(&srt.DataPacket{SequenceNum: 0})
+	win.Append(&srt.DataPacket{SequenceNum: 3})
+	// 1 and 2 are given up by sender, 4 is dropped before it is detected lost
+	win.Drop(1, 2)
+	win.Drop(4, 4)
+	win.Append(&srt.DataPacket{SequenceNum: 5})
+	expect(t, win, []uint32{0, 3, 5})
+	if c := win.Counters(); c.Dropped != 3 || c.Lost != 2 {
+		t.Errorf("unexpected counters: %+v", c)
+	}
+}
+
+func expect(t *testing.T, win *Entity, seqs []uint32) {
+	got := make([]uint32, 0, len(seqs))
+	timeout := time.After(time.Second)
+	for len(got) < len(seqs) {
+		select {
+		case batch := <-win.ListenBatch():
+			for _, p := range batch {
+				got = append(got, p.SequenceNum)
+			}
+		case <-timeout:
+			t.Fatalf("delivered %v, want %v", got, seqs)
+		}
+	}
+	for i := range seqs {
+		if got[i] != seqs[i] {
+			t.Fatalf("delivered %v, want %v", got, seqs)
+		}
+	}
+}
+
+func TestRates(t *testing.T) {
+	win := New(64, nil)
+	win.Append(&srt.DataPacket{SequenceNum: 16, Content: make([]byte, 100)})
+	win.Append(&srt.DataPacket{SequenceNum: 17, Content: make([]byte, 100)})
+	win.Append(&srt.DataPacket{SequenceNum: 20, Content: make([]byte, 100), R: true})
+	c := win.Counters()
+	if c.Received != 3 || c.Bytes != 300 || c.Lost != 2 || c.Retransmitted != 1 {
+		t.Errorf("unexpected counters: %+v", c)
+	}
+	if speed, bandwidth := win.Rates(); speed == 0 || bandwidth == 0 {
+		t.Errorf("rates are not sampled: %d, %d", speed, bandwidth)
+	}
+}
+
+func TestCloseUnread(t *testing.T) {
+	win := New(10, nil)
+	// batches are delivered to nobody until the channel is full
+	for seq := uint32(0); cap(win.ListenBatch()) > len(win.ListenBatch()); seq++ {
+		win.Append(&srt.DataPacket{SequenceNum: seq})
+		for win.AckSeq() != seq+1 {
+			runtime.Gosched()
+		}
+	}
+	win.Append(&srt.DataPacket{SequenceNum: uint32(cap(win.ListenBatch()))})
+	time.Sleep(2 * _deliverPeriod)
+
+	closed := make(chan struct{})
+	go func() {
+		win.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close is blocked by a batch not read")
+	}
+}